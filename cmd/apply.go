@@ -0,0 +1,95 @@
+/*
+Copyright (c) 2022 Zhang Zhanpeng <zhangregister@outlook.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"context"
+	"os"
+
+	"github.com/MonteCarloClub/kether/flag"
+	"github.com/MonteCarloClub/kether/log"
+	"github.com/MonteCarloClub/kether/object"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// applyCmd represents the apply command
+var applyCmd = &cobra.Command{
+	Use:   "apply",
+	Short: "Converge Kether objects in a YAML file to their desired configuration",
+	Long: `Converge each Kether object in a YAML file to its desired configuration.
+The hash of the desired configuration is stored as a container label and in the
+registry. Objects whose hash has not changed are left untouched, new objects are
+created, and changed objects are recreated: the old container is stopped, the new
+one is created, started and health-checked, and only then the old one is removed.
+Running apply twice is a no-op. For example:
+
+kether apply -f test/http_echo_stack.yml
+kether apply -f test/http_echo_stack.yml --dry-run`,
+	Annotations: withBackends(backendRegistry, backendDocker),
+	Args:        cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := context.WithValue(context.Background(), flag.ContextKey, flag.ContextValType{
+			DryRun:        dryRun,
+			WaitLock:      waitLock,
+			PinDigest:     pinDigest || viper.GetBool("policy.pin_digest"),
+			RequireDigest: requireDigest || viper.GetBool("policy.require_digest"),
+		})
+		if dryRun {
+			getPlans(ctx, yamlPath)
+			return
+		}
+		stack, err := object.ParseStack(yamlPath)
+		if err != nil {
+			log.Error("fail to parse kether objects", "err", err)
+			return
+		}
+		err = stack.Lock(ctx)
+		if err != nil {
+			log.Error("fail to lock kether objects", "err", err)
+			return
+		}
+		defer stack.Unlock(ctx)
+
+		actions, applyErr := object.ApplyStack(ctx, stack)
+		err = printApplyActions(os.Stdout, stack, actions)
+		if err != nil {
+			log.Error("fail to print results of applying kether objects", "err", err)
+		}
+		if applyErr != nil {
+			log.Error("fail to apply kether objects", "err", applyErr)
+			return
+		}
+		log.Info("kether objects applied")
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(applyCmd)
+
+	applyCmd.Flags().BoolVar(&dryRun, "dry-run", false, "Show the plan of each Kether object, as kether diff does, without changing any state")
+	applyCmd.Flags().StringVarP(&yamlPath, "file", "f", "", "Apply Kether objects in this YAML file path, which may contain several objects (required)")
+	applyCmd.MarkFlagRequired("file")
+	applyCmd.Flags().DurationVar(&waitLock, "wait-lock", 0, "Wait up to this duration for other processes modifying the same Kether objects, fail immediately if zero")
+	applyCmd.Flags().BoolVar(&pinDigest, "pin-digest", false, "Create containers with the repo digest of the pulled image instead of its tag (default is policy.pin_digest)")
+	applyCmd.Flags().BoolVar(&requireDigest, "require-digest", false, "Refuse to apply images not pinned by digest, such as repo@sha256:... (default is policy.require_digest)")
+}
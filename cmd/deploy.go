@@ -23,44 +23,67 @@ package cmd
 
 import (
 	"context"
+	"fmt"
+	"time"
 
 	"github.com/MonteCarloClub/kether/flag"
 	"github.com/MonteCarloClub/kether/log"
 	"github.com/MonteCarloClub/kether/object"
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
 )
 
 // deployCmd represents the deploy command
 var (
 	dryRun   bool
 	yamlPath string
+	waitLock time.Duration
+
+	pinDigest     bool
+	requireDigest bool
 
 	deployCmd = &cobra.Command{
 		Use:   "deploy",
-		Short: "A brief description of your command",
-		Long: `A longer description that spans multiple lines and likely contains examples
-and usage of using your command. For example:
+		Short: "Register and deploy the Kether objects in a YAML file",
+		Long: `Register and deploy the Kether objects in a YAML file. Objects are deployed in
+the order of their depends_on relations, objects independent of each other in
+parallel: the image is pulled unless local_image is set, the container is created
+and started, and objects with a health check are marked deployed only once they
+are ready. Objects that are already deployed are refused, use kether apply to
+update them or kether undeploy to remove them first. The command exits with a
+non-zero status if any object fails to register or deploy. For example:
 
-Cobra is a CLI library for Go that empowers applications.
-This application is a tool to generate the needed files
-to quickly create a Cobra application.`,
-		Run: func(cmd *cobra.Command, args []string) {
+kether deploy -f test/dao_2048.yml
+kether deploy -f test/http_echo_stack.yml --dry-run`,
+		Annotations: withBackends(backendRegistry, backendDocker),
+		RunE: func(cmd *cobra.Command, args []string) error {
 			ctx := context.WithValue(context.Background(), flag.ContextKey, flag.ContextValType{
-				DryRun: dryRun,
+				DryRun:        dryRun,
+				WaitLock:      waitLock,
+				PinDigest:     pinDigest || viper.GetBool("policy.pin_digest"),
+				RequireDigest: requireDigest || viper.GetBool("policy.require_digest"),
 			})
-			ketherObject, ketherObjectState, err := object.Register(ctx, yamlPath)
+			if dryRun {
+				if _, ok := getPlans(ctx, yamlPath); !ok {
+					return fmt.Errorf("fail to plan kether objects in %v", yamlPath)
+				}
+				return nil
+			}
+			stack, err := object.RegisterStack(ctx, yamlPath)
 			if err != nil {
-				log.Error("fail to register kether object", "err", err)
-				return
+				log.Error("fail to register kether objects", "err", err)
+				return err
 			}
-			log.Info("kether object registered")
+			defer stack.Unlock(ctx)
+			log.Info("kether objects registered", "count", len(stack.KetherObjects))
 
-			err = object.Deploy(ctx, ketherObject, ketherObjectState)
+			err = object.DeployStack(ctx, stack)
 			if err != nil {
-				log.Error("fail to deploy ketherObject", "err", err)
-				return
+				log.Error("fail to deploy kether objects", "err", err)
+				return err
 			}
-			log.Info("kether object deployed")
+			log.Info("kether objects deployed")
+			return nil
 		},
 	}
 )
@@ -77,7 +100,10 @@ func init() {
 	// Cobra supports local flags which will only run when this command
 	// is called directly, e.g.:
 	// deployCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
-	deployCmd.Flags().BoolVar(&dryRun, "dry-run", false, "Output actions to be performed without changing any state")
-	deployCmd.Flags().StringVarP(&yamlPath, "file", "f", "", "Construct Kether object and its state with this YAML file path (required)")
+	deployCmd.Flags().BoolVar(&dryRun, "dry-run", false, "Show the plan of each Kether object, as kether diff does, without changing any state")
+	deployCmd.Flags().StringVarP(&yamlPath, "file", "f", "", "Construct Kether objects and their states with this YAML file path, which may contain several objects (required)")
 	deployCmd.MarkFlagRequired("file")
+	deployCmd.Flags().DurationVar(&waitLock, "wait-lock", 0, "Wait up to this duration for other processes modifying the same Kether objects, fail immediately if zero")
+	deployCmd.Flags().BoolVar(&pinDigest, "pin-digest", false, "Create containers with the repo digest of the pulled image instead of its tag (default is policy.pin_digest)")
+	deployCmd.Flags().BoolVar(&requireDigest, "require-digest", false, "Refuse to deploy images not pinned by digest, such as repo@sha256:... (default is policy.require_digest)")
 }
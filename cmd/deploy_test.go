@@ -0,0 +1,49 @@
+/*
+Copyright (c) 2022 Zhang Zhanpeng <zhangregister@outlook.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/MonteCarloClub/kether/container"
+	"github.com/MonteCarloClub/kether/registry"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeployCmdReturnsError(t *testing.T) {
+	container.DefaultEngine = container.NewFakeEngine()
+	container.ResetImageAvailabilityCache()
+	registry.DefaultStore = registry.NewMemoryStore()
+	defer func() {
+		container.DefaultEngine = nil
+		registry.DefaultStore = nil
+		dryRun = false
+		yamlPath = ""
+	}()
+	yamlPath = filepath.Join(t.TempDir(), "missing.yml")
+
+	// 注册或规划失败时命令必须返回错误，以非零状态退出
+	assert.Error(t, deployCmd.RunE(deployCmd, nil))
+	dryRun = true
+	assert.Error(t, deployCmd.RunE(deployCmd, nil))
+}
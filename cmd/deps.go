@@ -0,0 +1,93 @@
+/*
+Copyright (c) 2022 Zhang Zhanpeng <zhangregister@outlook.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"strings"
+
+	"github.com/MonteCarloClub/kether/container"
+	"github.com/MonteCarloClub/kether/log"
+	"github.com/MonteCarloClub/kether/registry"
+	"github.com/spf13/cobra"
+)
+
+const (
+	// annotationBackends 是命令的注解，值为逗号分隔的命令需要的后端，没有该注解的命令不需要任何后端
+	annotationBackends = "kether.backends"
+
+	backendRegistry = "registry"
+	backendDocker   = "docker"
+)
+
+// dependencies 是命令依赖的客户端，在配置加载后按命令声明的后端构造
+type dependencies struct {
+	Store  registry.Store
+	Engine container.Engine
+}
+
+var deps = &dependencies{}
+
+// withBackends 返回声明命令需要的后端的注解
+func withBackends(backends ...string) map[string]string {
+	return map[string]string{
+		annotationBackends: strings.Join(backends, ","),
+	}
+}
+
+func getBackends(cmd *cobra.Command) map[string]bool {
+	backends := make(map[string]bool)
+	for _, backend := range strings.Split(cmd.Annotations[annotationBackends], ",") {
+		if backend != "" {
+			backends[backend] = true
+		}
+	}
+	return backends
+}
+
+// initDependencies 构造命令需要的后端，并注入为 registry 和 container 包的缺省后端，任一后端构造失败时中止命令
+func initDependencies(cmd *cobra.Command) error {
+	backends := getBackends(cmd)
+	if backends[backendRegistry] && deps.Store == nil {
+		err := registry.InitStore(getStoreConfig())
+		if err != nil {
+			log.Error("fail to init registry", "err", err)
+			return err
+		}
+		deps.Store = registry.DefaultStore
+	}
+	if backends[backendDocker] && deps.Engine == nil {
+		dockerConfig, err := getDockerConfig()
+		if err != nil {
+			log.Error("fail to get docker config", "err", err)
+			return err
+		}
+		err = container.InitDockerApiClient(dockerConfig)
+		if err != nil {
+			log.Error("fail to init docker api client", "err", err)
+			return err
+		}
+		deps.Engine = container.DefaultEngine
+	}
+	registry.DefaultStore = deps.Store
+	container.DefaultEngine = deps.Engine
+	return nil
+}
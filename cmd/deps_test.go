@@ -0,0 +1,62 @@
+/*
+Copyright (c) 2022 Zhang Zhanpeng <zhangregister@outlook.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"testing"
+
+	"github.com/MonteCarloClub/kether/container"
+	"github.com/MonteCarloClub/kether/log"
+	"github.com/MonteCarloClub/kether/registry"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func init() {
+	log.InitLogger()
+}
+
+func TestInitDependencies(t *testing.T) {
+	defer func() {
+		deps = &dependencies{}
+		registry.DefaultStore = nil
+		container.DefaultEngine = nil
+	}()
+	viper.Set("registry.backend", registry.BackendMemory)
+	defer viper.Set("registry.backend", nil)
+
+	assert.Nil(t, initDependencies(&cobra.Command{}))
+	assert.Nil(t, deps.Store)
+	assert.Nil(t, registry.DefaultStore)
+
+	assert.Nil(t, initDependencies(&cobra.Command{Annotations: withBackends(backendRegistry)}))
+	assert.NotNil(t, deps.Store)
+	assert.Equal(t, deps.Store, registry.DefaultStore)
+	assert.Nil(t, container.DefaultEngine)
+
+	viper.Set("registry.backend", "etcd")
+	deps = &dependencies{}
+	assert.EqualError(t, initDependencies(&cobra.Command{Annotations: withBackends(backendRegistry)}), `unknown registry backend "etcd"`)
+
+	assert.Equal(t, map[string]bool{backendRegistry: true, backendDocker: true}, getBackends(deployCmd))
+}
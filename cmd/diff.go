@@ -0,0 +1,116 @@
+/*
+Copyright (c) 2022 Zhang Zhanpeng <zhangregister@outlook.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"context"
+	"os"
+
+	"github.com/MonteCarloClub/kether/flag"
+	"github.com/MonteCarloClub/kether/log"
+	"github.com/MonteCarloClub/kether/object"
+	"github.com/spf13/cobra"
+)
+
+// diffCmd represents the diff command
+var (
+	diffExitCode bool
+
+	diffCmd = &cobra.Command{
+		Use:   "diff",
+		Short: "Compare Kether objects in a YAML file with their running containers",
+		Long: `Inspect the container of each Kether object in a YAML file and show the
+differences between the desired and the actual image, status, environment
+variables, ports, mounts, networks and limits, together with the action kether
+would take: create, recreate or no-op. Nothing is changed. With --exit-code the
+command exits with status 1 if any object would be created or recreated, which
+is useful as a CI gate. It exits with status 2 if the YAML file cannot be parsed
+or planned, for example when the Docker engine is unreachable. For example:
+
+kether diff -f test/http_echo_stack.yml
+kether diff -f test/http_echo_stack.yml -o json --exit-code`,
+		Annotations: withBackends(backendDocker),
+		Args:        cobra.NoArgs,
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return checkOutputFormat()
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+			ctx := context.WithValue(context.Background(), flag.ContextKey, flag.ContextValType{
+				DryRun: true,
+			})
+			if exitCode := getDiffExitCode(ctx, yamlPath, diffExitCode); exitCode != diffExitCodeNoChanges {
+				os.Exit(exitCode)
+			}
+		},
+	}
+)
+
+const (
+	diffExitCodeNoChanges = 0
+	diffExitCodeChanges   = 1
+	diffExitCodeFailure   = 2
+)
+
+// getDiffExitCode 输出 YAML 文件中对象的计划并返回退出码，与 `git diff --exit-code` 一致：
+// 无法解析或计划时返回 2，exitCode 为 true 且有对象需要创建或重建时返回 1，否则返回 0
+func getDiffExitCode(ctx context.Context, yamlPath string, exitCode bool) int {
+	plans, ok := getPlans(ctx, yamlPath)
+	if !ok {
+		return diffExitCodeFailure
+	}
+	for _, plan := range plans {
+		if exitCode && plan.Action != object.PlanActionNoop {
+			return diffExitCodeChanges
+		}
+	}
+	return diffExitCodeNoChanges
+}
+
+// getPlans 解析 YAML 文件，输出并返回其中每个 Kether 对象的计划
+func getPlans(ctx context.Context, yamlPath string) ([]*object.Plan, bool) {
+	stack, err := object.ParseStack(yamlPath)
+	if err != nil {
+		log.Error("fail to parse kether objects", "err", err)
+		return nil, false
+	}
+	plans, err := object.GetStackPlans(ctx, stack)
+	if err != nil {
+		log.Error("fail to plan kether objects", "err", err)
+		return nil, false
+	}
+	err = printPlans(os.Stdout, plans, isColorEnabled(os.Stdout))
+	if err != nil {
+		log.Error("fail to print plans of kether objects", "err", err)
+		return nil, false
+	}
+	return plans, true
+}
+
+func init() {
+	rootCmd.AddCommand(diffCmd)
+
+	diffCmd.Flags().StringVarP(&yamlPath, "file", "f", "", "Compare Kether objects in this YAML file path (required)")
+	diffCmd.MarkFlagRequired("file")
+	diffCmd.Flags().StringVarP(&outputFormat, "output", "o", outputFormatTable, "Output format, one of table (coloured diff), json and yaml")
+	diffCmd.Flags().BoolVar(&noColor, "no-color", false, "Disable coloured output")
+	diffCmd.Flags().BoolVar(&diffExitCode, "exit-code", false, "Exit with status 1 if any Kether object would be created or recreated, status 2 on failure")
+}
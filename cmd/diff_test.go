@@ -0,0 +1,56 @@
+/*
+Copyright (c) 2022 Zhang Zhanpeng <zhangregister@outlook.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/MonteCarloClub/kether/container"
+	"github.com/MonteCarloClub/kether/flag"
+	"github.com/MonteCarloClub/kether/registry"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetDiffExitCode(t *testing.T) {
+	fakeEngine := container.NewFakeEngine()
+	fakeEngine.RemoteImages["ghcr.io/daocloud/dao-2048:1.1.0-alpha.6"] = true
+	container.DefaultEngine = fakeEngine
+	container.ResetImageAvailabilityCache()
+	registry.DefaultStore = registry.NewMemoryStore()
+	defer func() {
+		container.DefaultEngine = nil
+		registry.DefaultStore = nil
+	}()
+	ctx := context.WithValue(context.Background(), flag.ContextKey, flag.ContextValType{DryRun: true})
+	yamlPath := filepath.Join("..", "test", "dao_2048.yml")
+
+	assert.Equal(t, diffExitCodeNoChanges, getDiffExitCode(ctx, yamlPath, false))
+	assert.Equal(t, diffExitCodeChanges, getDiffExitCode(ctx, yamlPath, true))
+
+	// 无法解析的 YAML 和不可达的引擎不能被当作没有变化
+	assert.Equal(t, diffExitCodeFailure, getDiffExitCode(ctx, filepath.Join(t.TempDir(), "missing.yml"), true))
+	fakeEngine.Errors["InspectContainer"] = errors.New("cannot connect to the docker daemon")
+	assert.Equal(t, diffExitCodeFailure, getDiffExitCode(ctx, yamlPath, false))
+}
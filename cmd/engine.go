@@ -0,0 +1,76 @@
+/*
+Copyright (c) 2022 Zhang Zhanpeng <zhangregister@outlook.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"context"
+	"os"
+
+	"github.com/MonteCarloClub/kether/container"
+	"github.com/MonteCarloClub/kether/log"
+	"github.com/spf13/cobra"
+)
+
+// engineCmd represents the engine command
+var engineCmd = &cobra.Command{
+	Use:   "engine",
+	Short: "Inspect the Docker engine of the current context",
+	Long: `Inspect the Docker engine addressed by the current Docker context. The
+context is chosen by --context or docker.context in the config file, and
+defaults to the settings under docker, DOCKER_HOST and the other Docker
+environment variables.`,
+}
+
+// engineInfoCmd represents the engine info command
+var engineInfoCmd = &cobra.Command{
+	Use:   "info",
+	Short: "Show the version and capabilities of the Docker engine",
+	Long: `Show the version, negotiated API version and capabilities of the Docker
+engine of the current context, such as the storage driver, the cgroup driver
+and whether resource limits are supported. For example:
+
+kether engine info
+kether engine info --context staging -o json`,
+	Annotations: withBackends(backendDocker),
+	Args:        cobra.NoArgs,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		return checkOutputFormat()
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		engineInfo, err := container.GetDockerEngineInfo(context.Background())
+		if err != nil {
+			log.Error("fail to get docker engine info", "err", err)
+			return
+		}
+		err = printEngineInfo(os.Stdout, engineInfo)
+		if err != nil {
+			log.Error("fail to print docker engine info", "err", err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(engineCmd)
+	engineCmd.AddCommand(engineInfoCmd)
+
+	engineInfoCmd.Flags().StringVarP(&outputFormat, "output", "o", outputFormatTable, "Output format, one of table, json and yaml")
+}
@@ -0,0 +1,65 @@
+/*
+Copyright (c) 2022 Zhang Zhanpeng <zhangregister@outlook.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"context"
+	"os"
+
+	"github.com/MonteCarloClub/kether/log"
+	"github.com/MonteCarloClub/kether/object"
+	"github.com/spf13/cobra"
+)
+
+// historyCmd represents the history command
+var historyCmd = &cobra.Command{
+	Use:   "history <name>",
+	Short: "Show the state transitions of a Kether object",
+	Long: `Show the append-only history of state transitions of a Kether object
+recorded in the registry, including the time, the states before and after
+each transition, and the reason. For example:
+
+kether history dao-2048-test
+kether history dao-2048-test -o json`,
+	Annotations: withBackends(backendRegistry),
+	Args:        cobra.ExactArgs(1),
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		return checkOutputFormat()
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		transitions, err := object.GetHistory(context.Background(), args[0])
+		if err != nil {
+			log.Error("fail to get history of kether object", "name", args[0], "err", err)
+			return
+		}
+		err = printKetherObjectTransitions(os.Stdout, transitions)
+		if err != nil {
+			log.Error("fail to print history of kether object", "err", err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(historyCmd)
+
+	historyCmd.Flags().StringVarP(&outputFormat, "output", "o", outputFormatTable, "Output format, one of table, json and yaml")
+}
@@ -0,0 +1,64 @@
+/*
+Copyright (c) 2022 Zhang Zhanpeng <zhangregister@outlook.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"context"
+	"os"
+
+	"github.com/MonteCarloClub/kether/log"
+	"github.com/MonteCarloClub/kether/object"
+	"github.com/spf13/cobra"
+)
+
+// listCmd represents the list command
+var listCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List all Kether objects in the registry",
+	Long: `List all Kether objects in the registry, with the state stored in the
+registry and the live state of their containers. For example:
+
+kether list
+kether list -o json`,
+	Annotations: withBackends(backendRegistry, backendDocker),
+	Args:        cobra.NoArgs,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		return checkOutputFormat()
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		ketherObjectStatusList, err := object.ListStatus(context.Background())
+		if err != nil {
+			log.Error("fail to list kether objects", "err", err)
+			return
+		}
+		err = printKetherObjectStatusList(os.Stdout, ketherObjectStatusList)
+		if err != nil {
+			log.Error("fail to print kether objects", "err", err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(listCmd)
+
+	listCmd.Flags().StringVarP(&outputFormat, "output", "o", outputFormatTable, "Output format, one of table, json and yaml")
+}
@@ -0,0 +1,87 @@
+/*
+Copyright (c) 2022 Zhang Zhanpeng <zhangregister@outlook.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"context"
+	"os"
+	"os/signal"
+
+	"github.com/MonteCarloClub/kether/container"
+	"github.com/MonteCarloClub/kether/log"
+	"github.com/MonteCarloClub/kether/object"
+	"github.com/docker/docker/api/types"
+	"github.com/spf13/cobra"
+)
+
+// logsCmd represents the logs command
+var (
+	follow bool
+	since  string
+	tail   string
+
+	logsCmd = &cobra.Command{
+		Use:   "logs <name>",
+		Short: "Print the logs of a Kether object's container",
+		Long: `Print the stdout and stderr of a Kether object's container, which is
+useful for objects deployed in detach mode. For example:
+
+kether logs http-https-echo-server --follow --tail 100
+kether logs http-https-echo-server --since 10m`,
+		Annotations: withBackends(backendDocker),
+		Args:        cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			signalChan := make(chan os.Signal, 1)
+			signal.Notify(signalChan, os.Interrupt)
+			defer signal.Stop(signalChan)
+			go func() {
+				select {
+				case <-signalChan:
+					cancel()
+				case <-ctx.Done():
+				}
+			}()
+
+			ketherObject, _ := object.GetKetherObjectOfName(args[0])
+			err := container.StreamDockerContainerLogs(ctx, ketherObject.GetContainerName(), types.ContainerLogsOptions{
+				ShowStdout: true,
+				ShowStderr: true,
+				Follow:     follow,
+				Since:      since,
+				Tail:       tail,
+			}, os.Stdout, os.Stderr)
+			if err != nil {
+				log.Error("fail to print logs of kether object", "name", args[0], "err", err)
+			}
+		},
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(logsCmd)
+
+	logsCmd.Flags().BoolVarP(&follow, "follow", "f", false, "Follow log output")
+	logsCmd.Flags().StringVar(&since, "since", "", "Show logs since timestamp (e.g. 2022-01-02T13:23:37Z) or relative (e.g. 42m for 42 minutes)")
+	logsCmd.Flags().StringVar(&tail, "tail", "all", "Number of lines to show from the end of the logs")
+}
@@ -0,0 +1,274 @@
+/*
+Copyright (c) 2022 Zhang Zhanpeng <zhangregister@outlook.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/MonteCarloClub/kether/container"
+	"github.com/MonteCarloClub/kether/object"
+	"github.com/MonteCarloClub/kether/registry"
+	"github.com/docker/go-units"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	outputFormatTable = "table"
+	outputFormatJson  = "json"
+	outputFormatYaml  = "yaml"
+)
+
+var outputFormat string
+
+func checkOutputFormat() error {
+	switch outputFormat {
+	case outputFormatTable, outputFormatJson, outputFormatYaml:
+		return nil
+	default:
+		return fmt.Errorf("unsupported output format %q, expect one of %v", outputFormat, strings.Join([]string{outputFormatTable, outputFormatJson, outputFormatYaml}, ", "))
+	}
+}
+
+// printStructured 以 JSON 或 YAML 格式输出 v，返回 false 表示应由调用方输出表格
+func printStructured(w io.Writer, v interface{}) (bool, error) {
+	switch outputFormat {
+	case outputFormatJson:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return true, encoder.Encode(v)
+	case outputFormatYaml:
+		yamlBytes, err := yaml.Marshal(v)
+		if err != nil {
+			return true, err
+		}
+		_, err = w.Write(yamlBytes)
+		return true, err
+	default:
+		return false, nil
+	}
+}
+
+func printKetherObjectStatusList(w io.Writer, ketherObjectStatusList []*object.KetherObjectStatus) error {
+	if ok, err := printStructured(w, ketherObjectStatusList); ok {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tSTATE\tCONTAINER\tRUNNING\tEXIT CODE\tSTARTED AT\tPORTS")
+	for _, ketherObjectStatus := range ketherObjectStatusList {
+		ports := "-"
+		if len(ketherObjectStatus.Ports) > 0 {
+			ports = strings.Join(ketherObjectStatus.Ports, ", ")
+		}
+		containerStatus := ketherObjectStatus.Container
+		if !containerStatus.Exists {
+			fmt.Fprintf(tw, "%v\t%v\t%v\t-\t-\t-\t%v\n", ketherObjectStatus.Name, ketherObjectStatus.State, "<none>", ports)
+			continue
+		}
+		fmt.Fprintf(tw, "%v\t%v\t%v\t%v\t%v\t%v\t%v\n", ketherObjectStatus.Name, ketherObjectStatus.State, containerStatus.Status, containerStatus.Running, containerStatus.ExitCode, containerStatus.StartedAt, ports)
+	}
+	return tw.Flush()
+}
+
+func printKetherObjectStatus(w io.Writer, ketherObjectStatus *object.KetherObjectStatus) error {
+	if ok, err := printStructured(w, ketherObjectStatus); ok {
+		return err
+	}
+	return printKetherObjectStatusList(w, []*object.KetherObjectStatus{ketherObjectStatus})
+}
+
+func printKetherObjectTransitions(w io.Writer, transitions []*object.KetherObjectTransition) error {
+	if ok, err := printStructured(w, transitions); ok {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "TIME\tFROM\tTO\tREASON")
+	for _, transition := range transitions {
+		fmt.Fprintf(tw, "%v\t%v\t%v\t%v\n", transition.Timestamp.Local().Format(time.RFC3339), transition.From, transition.To, transition.Reason)
+	}
+	return tw.Flush()
+}
+
+func printKetherObjectRevisions(w io.Writer, revisions []*object.KetherObjectRevision) error {
+	if ok, err := printStructured(w, revisions); ok {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "REVISION\tTIME\tIMAGE\tDIGEST\tCAUSE")
+	for _, revision := range revisions {
+		cause := "-"
+		if revision.RollbackOf > 0 {
+			cause = fmt.Sprintf("rollback to %v", revision.RollbackOf)
+		}
+		fmt.Fprintf(tw, "%v\t%v\t%v\t%v\t%v\n", revision.Revision, revision.Timestamp.Local().Format(time.RFC3339), revision.Image, revision.ImageDigest, cause)
+	}
+	return tw.Flush()
+}
+
+func printPortReservations(w io.Writer, portReservations []registry.PortReservation) error {
+	if ok, err := printStructured(w, portReservations); ok {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "HOST\tPORT\tPROTOCOL\tNAME\tRESERVED AT")
+	for _, portReservation := range portReservations {
+		fmt.Fprintf(tw, "%v\t%v\t%v\t%v\t%v\n", portReservation.Host, portReservation.Port, portReservation.Protocol, portReservation.Name, portReservation.Timestamp.Local().Format(time.RFC3339))
+	}
+	return tw.Flush()
+}
+
+func printEngineInfo(w io.Writer, engineInfo *container.EngineInfo) error {
+	if ok, err := printStructured(w, engineInfo); ok {
+		return err
+	}
+
+	capabilities := make([]string, 0, len(engineInfo.Capabilities))
+	for capability, supported := range engineInfo.Capabilities {
+		if supported {
+			capabilities = append(capabilities, capability)
+		}
+	}
+	sort.Strings(capabilities)
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "Context:\t%v\n", engineInfo.Context)
+	fmt.Fprintf(tw, "Host:\t%v\n", engineInfo.Host)
+	fmt.Fprintf(tw, "Server Version:\t%v\n", engineInfo.ServerVersion)
+	fmt.Fprintf(tw, "API Version:\t%v (minimum %v, client %v)\n", engineInfo.APIVersion, engineInfo.MinAPIVersion, engineInfo.ClientAPIVersion)
+	fmt.Fprintf(tw, "Operating System:\t%v\n", engineInfo.OperatingSystem)
+	fmt.Fprintf(tw, "OS/Arch:\t%v/%v\n", engineInfo.OSType, engineInfo.Architecture)
+	fmt.Fprintf(tw, "Kernel Version:\t%v\n", engineInfo.KernelVersion)
+	fmt.Fprintf(tw, "CPUs:\t%v\n", engineInfo.CPUs)
+	fmt.Fprintf(tw, "Memory:\t%v\n", units.BytesSize(float64(engineInfo.Memory)))
+	fmt.Fprintf(tw, "Storage Driver:\t%v\n", engineInfo.StorageDriver)
+	fmt.Fprintf(tw, "Cgroup Driver:\t%v (version %v)\n", engineInfo.CgroupDriver, engineInfo.CgroupVersion)
+	fmt.Fprintf(tw, "Runtimes:\t%v (default %v)\n", strings.Join(engineInfo.Runtimes, ", "), engineInfo.DefaultRuntime)
+	fmt.Fprintf(tw, "Security Options:\t%v\n", strings.Join(engineInfo.SecurityOptions, ", "))
+	fmt.Fprintf(tw, "Experimental:\t%v\n", engineInfo.Experimental)
+	fmt.Fprintf(tw, "Containers:\t%v\n", engineInfo.Containers)
+	fmt.Fprintf(tw, "Images:\t%v\n", engineInfo.Images)
+	fmt.Fprintf(tw, "Capabilities:\t%v\n", strings.Join(capabilities, ", "))
+	return tw.Flush()
+}
+
+// printFieldErrors 以 file:line:column: field: message 的形式逐行输出字段错误，与编译器的输出一致，便于编辑器跳转
+func printFieldErrors(w io.Writer, yamlPath string, fieldErrors object.FieldErrors) {
+	for _, fieldError := range fieldErrors {
+		position := yamlPath
+		if fieldError.Line > 0 {
+			position = fmt.Sprintf("%v:%v:%v", yamlPath, fieldError.Line, fieldError.Column)
+		}
+		fmt.Fprintf(w, "%v: %v\n", position, fieldError.Error())
+	}
+}
+
+const (
+	colorRed    = "\033[31m"
+	colorGreen  = "\033[32m"
+	colorYellow = "\033[33m"
+	colorReset  = "\033[0m"
+)
+
+var noColor bool
+
+// isColorEnabled 判断是否输出彩色文本：w 是终端，且没有指定 --no-color 或 NO_COLOR 环境变量
+func isColorEnabled(w io.Writer) bool {
+	if noColor || os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	file, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	fileInfo, err := file.Stat()
+	return err == nil && fileInfo.Mode()&os.ModeCharDevice != 0
+}
+
+func colorize(color bool, code string, text string) string {
+	if !color {
+		return text
+	}
+	return code + text + colorReset
+}
+
+// printPlans 输出每个 Kether 对象的计划，+ 表示只在 YAML 中存在的配置项，- 表示只在容器中存在的配置项，~ 表示取值不同的配置项
+func printPlans(w io.Writer, plans []*object.Plan, color bool) error {
+	if ok, err := printStructured(w, plans); ok {
+		return err
+	}
+
+	counts := make(map[string]int)
+	for _, plan := range plans {
+		counts[plan.Action]++
+		switch plan.Action {
+		case object.PlanActionCreate:
+			fmt.Fprintln(w, colorize(color, colorGreen, fmt.Sprintf("+ %v (%v)", plan.Name, plan.Action)))
+		case object.PlanActionRecreate:
+			fmt.Fprintln(w, colorize(color, colorYellow, fmt.Sprintf("~ %v (%v)", plan.Name, plan.Action)))
+		default:
+			fmt.Fprintf(w, "  %v (%v)\n", plan.Name, plan.Action)
+		}
+		for _, fieldDiff := range plan.Diffs {
+			switch {
+			case fieldDiff.Actual == "":
+				fmt.Fprintln(w, colorize(color, colorGreen, fmt.Sprintf("    + %v: %v", fieldDiff.Field, fieldDiff.Desired)))
+			case fieldDiff.Desired == "":
+				fmt.Fprintln(w, colorize(color, colorRed, fmt.Sprintf("    - %v: %v", fieldDiff.Field, fieldDiff.Actual)))
+			default:
+				fmt.Fprintln(w, colorize(color, colorYellow, fmt.Sprintf("    ~ %v: %v => %v", fieldDiff.Field, fieldDiff.Actual, fieldDiff.Desired)))
+			}
+		}
+	}
+	_, err := fmt.Fprintf(w, "Plan: %v to create, %v to recreate, %v unchanged.\n", counts[object.PlanActionCreate], counts[object.PlanActionRecreate], counts[object.PlanActionNoop])
+	return err
+}
+
+// applyResults 是 apply 的动作在输出中的说法
+var applyResults = map[string]string{
+	object.PlanActionCreate:   "created",
+	object.PlanActionRecreate: "recreated",
+	object.PlanActionNoop:     "unchanged",
+}
+
+// printApplyActions 按栈中对象的顺序输出每个对象的结果，没有动作的对象失败或被跳过
+func printApplyActions(w io.Writer, stack *object.Stack, actions map[string]string) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tRESULT")
+	for _, ketherObject := range stack.KetherObjects {
+		result, ok := applyResults[actions[ketherObject.Name]]
+		if !ok {
+			result = "failed"
+		}
+		fmt.Fprintf(tw, "%v\t%v\n", ketherObject.Name, result)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,69 @@
+/*
+Copyright (c) 2022 Zhang Zhanpeng <zhangregister@outlook.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/MonteCarloClub/kether/object"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrintPlans(t *testing.T) {
+	plans := []*object.Plan{
+		{
+			Name:   "bootnode",
+			Action: object.PlanActionRecreate,
+			Diffs: []*object.FieldDiff{
+				{Field: "image", Desired: "ethereum/client-go:v1.10.17", Actual: "ethereum/client-go:v1.10.16"},
+				{Field: "env.VERBOSITY", Desired: "VERBOSITY=3"},
+				{Field: "limits.memory", Actual: "536870912"},
+			},
+		},
+		{
+			Name:   "explorer",
+			Action: object.PlanActionNoop,
+			Diffs:  []*object.FieldDiff{},
+		},
+	}
+	outputFormat = outputFormatTable
+	buffer := &bytes.Buffer{}
+	assert.Nil(t, printPlans(buffer, plans, false))
+	assert.Equal(t, `~ bootnode (recreate)
+    ~ image: ethereum/client-go:v1.10.16 => ethereum/client-go:v1.10.17
+    + env.VERBOSITY: VERBOSITY=3
+    - limits.memory: 536870912
+  explorer (no-op)
+Plan: 0 to create, 1 to recreate, 1 unchanged.
+`, buffer.String())
+
+	buffer.Reset()
+	assert.Nil(t, printPlans(buffer, plans[:1], true))
+	assert.Contains(t, buffer.String(), colorGreen+"    + env.VERBOSITY: VERBOSITY=3"+colorReset)
+
+	outputFormat = outputFormatJson
+	buffer.Reset()
+	assert.Nil(t, printPlans(buffer, plans[1:], false))
+	assert.JSONEq(t, `[{"name": "explorer", "action": "no-op", "diffs": []}]`, buffer.String())
+	outputFormat = outputFormatTable
+}
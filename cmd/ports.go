@@ -0,0 +1,65 @@
+/*
+Copyright (c) 2022 Zhang Zhanpeng <zhangregister@outlook.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"context"
+	"os"
+
+	"github.com/MonteCarloClub/kether/log"
+	"github.com/MonteCarloClub/kether/registry"
+	"github.com/spf13/cobra"
+)
+
+// portsCmd represents the ports command
+var portsCmd = &cobra.Command{
+	Use:   "ports",
+	Short: "List host ports reserved by Kether objects",
+	Long: `List host ports reserved by Kether objects in the registry, ordered by host,
+port and protocol. Host ports allocated in registry.port_ranges by the remap
+policy and random ports chosen by docker are reserved as well. For example:
+
+kether ports
+kether ports -o json`,
+	Annotations: withBackends(backendRegistry),
+	Args:        cobra.NoArgs,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		return checkOutputFormat()
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		portReservations, err := registry.ListPortReservations(context.Background())
+		if err != nil {
+			log.Error("fail to list ports", "err", err)
+			return
+		}
+		err = printPortReservations(os.Stdout, portReservations)
+		if err != nil {
+			log.Error("fail to print ports", "err", err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(portsCmd)
+
+	portsCmd.Flags().StringVarP(&outputFormat, "output", "o", outputFormatTable, "Output format, one of table, json and yaml")
+}
@@ -0,0 +1,76 @@
+/*
+Copyright (c) 2022 Zhang Zhanpeng <zhangregister@outlook.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"context"
+
+	"github.com/MonteCarloClub/kether/flag"
+	"github.com/MonteCarloClub/kether/log"
+	"github.com/MonteCarloClub/kether/object"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// rollbackCmd represents the rollback command
+var (
+	toRevision int
+
+	rollbackCmd = &cobra.Command{
+		Use:   "rollback <name>",
+		Short: "Redeploy a previous revision of a Kether object",
+		Long: `Redeploy a previous revision of a Kether object. The object recorded in the
+revision is deployed with the image pinned to the recorded digest, even if its
+tag has been moved since, and replaces the current container as kether apply
+does. A revision whose image has no recorded digest is refused when digests are
+required. The rollback is recorded as a new revision. For example:
+
+kether rollback dao-2048-test
+kether rollback dao-2048-test --to-revision 2`,
+		Annotations: withBackends(backendRegistry, backendDocker),
+		Args:        cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			ctx := context.WithValue(context.Background(), flag.ContextKey, flag.ContextValType{
+				WaitLock:      waitLock,
+				RequireDigest: requireDigest || viper.GetBool("policy.require_digest"),
+			})
+			action, err := object.Rollback(ctx, args[0], toRevision)
+			if err != nil {
+				log.Error("fail to roll back kether object", "name", args[0], "err", err)
+				return
+			}
+			if action == object.PlanActionNoop {
+				log.Info("kether object already at revision", "name", args[0])
+				return
+			}
+			log.Info("kether object rolled back", "name", args[0])
+		},
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(rollbackCmd)
+
+	rollbackCmd.Flags().IntVar(&toRevision, "to-revision", 0, "Roll back to this revision, the one before the latest revision if zero")
+	rollbackCmd.Flags().BoolVar(&requireDigest, "require-digest", false, "Refuse to roll back to revisions whose image is not pinned by digest (default is policy.require_digest)")
+	rollbackCmd.Flags().DurationVar(&waitLock, "wait-lock", 0, "Wait up to this duration for other processes modifying the same Kether object, fail immediately if zero")
+}
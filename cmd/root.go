@@ -24,7 +24,11 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"sort"
+	"strings"
 
+	"github.com/MonteCarloClub/kether/container"
+	"github.com/MonteCarloClub/kether/registry"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
@@ -44,6 +48,17 @@ to quickly create a Cobra application.`,
 	// Uncomment the following line if your bare application
 	// has an action associated with it:
 	// Run: func(cmd *cobra.Command, args []string) { },
+
+	// 在参数解析和配置加载之后按命令声明的后端构造客户端，使配置能够影响客户端，--help 等命令不需要任何客户端
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		// 此后的错误不是用法错误，不再打印用法
+		cmd.SilenceUsage = true
+		err := initConfig()
+		if err != nil {
+			return err
+		}
+		return initDependencies(cmd)
+	},
 }
 
 // Execute adds all child commands to the root command and sets flags appropriately.
@@ -56,13 +71,13 @@ func Execute() {
 }
 
 func init() {
-	cobra.OnInitialize(initConfig)
-
 	// Here you will define your flags and configuration settings.
 	// Cobra supports persistent flags, which, if defined here,
 	// will be global for your application.
 
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.kether.yaml)")
+	rootCmd.PersistentFlags().String("context", "", "Name of the Docker context in docker.contexts of the config file to use (default is docker.context)")
+	viper.BindPFlag("docker.context", rootCmd.PersistentFlags().Lookup("context"))
 
 	// Cobra also supports local flags, which will only run
 	// when this action is called directly.
@@ -70,14 +85,16 @@ func init() {
 }
 
 // initConfig reads in config file and ENV variables if set.
-func initConfig() {
+func initConfig() error {
 	if cfgFile != "" {
 		// Use config file from the flag.
 		viper.SetConfigFile(cfgFile)
 	} else {
 		// Find home directory.
 		home, err := os.UserHomeDir()
-		cobra.CheckErr(err)
+		if err != nil {
+			return err
+		}
 
 		// Search config in home directory with name ".kether" (without extension).
 		viper.AddConfigPath(home)
@@ -85,10 +102,91 @@ func initConfig() {
 		viper.SetConfigName(".kether")
 	}
 
+	// 环境变量以 KETHER_ 为前缀，嵌套键的 . 替换为 _，如 redis.tls.ca_file 对应 KETHER_REDIS_TLS_CA_FILE
+	viper.SetEnvPrefix("KETHER")
+	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
 	viper.AutomaticEnv() // read in environment variables that match
 
 	// If a config file is found, read it in.
 	if err := viper.ReadInConfig(); err == nil {
 		fmt.Fprintln(os.Stderr, "Using config file:", viper.ConfigFileUsed())
 	}
+
+	return nil
+}
+
+// getStringList 读取列表配置，环境变量中的列表以逗号分隔
+func getStringList(key string) []string {
+	value := viper.Get(key)
+	if s, ok := value.(string); ok {
+		list := make([]string, 0)
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		return list
+	}
+	return viper.GetStringSlice(key)
+}
+
+// getStoreConfig 读取注册表配置，registry.backend 可选 redis（缺省）、memory 和 file，
+// file 后端的文件路径由 registry.file.path 指定，redis 后端的连接由 redis 字段配置
+func getStoreConfig() registry.StoreConfig {
+	return registry.StoreConfig{
+		Backend:    viper.GetString("registry.backend"),
+		FilePath:   viper.GetString("registry.file.path"),
+		PortRanges: getStringList("registry.port_ranges"),
+		Redis: registry.RedisConfig{
+			Addrs:    getStringList("redis.addr"),
+			Username: viper.GetString("redis.username"),
+			Password: viper.GetString("redis.password"),
+			DB:       viper.GetInt("redis.db"),
+			TLS: registry.RedisTLSConfig{
+				Enabled:            viper.GetBool("redis.tls.enabled"),
+				CAFile:             viper.GetString("redis.tls.ca_file"),
+				CertFile:           viper.GetString("redis.tls.cert_file"),
+				KeyFile:            viper.GetString("redis.tls.key_file"),
+				ServerName:         viper.GetString("redis.tls.server_name"),
+				InsecureSkipVerify: viper.GetBool("redis.tls.insecure_skip_verify"),
+			},
+			SentinelMaster:   viper.GetString("redis.sentinel.master"),
+			SentinelAddrs:    getStringList("redis.sentinel.addrs"),
+			SentinelUsername: viper.GetString("redis.sentinel.username"),
+			SentinelPassword: viper.GetString("redis.sentinel.password"),
+			Cluster:          viper.GetBool("redis.cluster"),
+			DialTimeout:      viper.GetDuration("redis.dial_timeout"),
+		},
+	}
+}
+
+// getDockerConfig 读取当前 Docker 上下文的连接配置，docker.context 为空或 default 时使用 docker 字段，
+// 否则使用 docker.contexts 中的同名配置
+func getDockerConfig() (container.DockerConfig, error) {
+	name := viper.GetString("docker.context")
+	prefix := "docker"
+	if name == "" {
+		name = container.DefaultDockerContext
+	}
+	if name != container.DefaultDockerContext {
+		prefix = "docker.contexts." + name
+		if !viper.IsSet(prefix) {
+			names := make([]string, 0)
+			for contextName := range viper.GetStringMap("docker.contexts") {
+				names = append(names, contextName)
+			}
+			sort.Strings(names)
+			return container.DockerConfig{}, fmt.Errorf("unknown docker context %q, expect %v or one of [%v] in docker.contexts", name, container.DefaultDockerContext, strings.Join(names, ", "))
+		}
+	}
+	return container.DockerConfig{
+		Context:    name,
+		Host:       viper.GetString(prefix + ".host"),
+		APIVersion: viper.GetString(prefix + ".api_version"),
+		TLS: container.DockerTLSConfig{
+			CAFile:   viper.GetString(prefix + ".tls.ca_file"),
+			CertFile: viper.GetString(prefix + ".tls.cert_file"),
+			KeyFile:  viper.GetString(prefix + ".tls.key_file"),
+		},
+	}, nil
 }
@@ -0,0 +1,93 @@
+/*
+Copyright (c) 2022 Zhang Zhanpeng <zhangregister@outlook.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/MonteCarloClub/kether/flag"
+	"github.com/MonteCarloClub/kether/log"
+	"github.com/MonteCarloClub/kether/object"
+	"github.com/spf13/cobra"
+)
+
+// undeployCmd represents the undeploy command
+var (
+	removeVolumes bool
+
+	undeployCmd = &cobra.Command{
+		Use:   "undeploy [name]",
+		Short: "Stop and remove the container of a deployed Kether object",
+		Long: `Stop and remove the container of a deployed Kether object, and mark the
+object as undeployed in the registry. The object is named either by a YAML
+file or by its name. Objects in a YAML file are undeployed in the reverse
+order of their dependencies. For example:
+
+kether undeploy -f test/dao_2048.yml
+kether undeploy dao-2048-test --volumes`,
+		Annotations: withBackends(backendRegistry, backendDocker),
+		Args: func(cmd *cobra.Command, args []string) error {
+			if err := cobra.MaximumNArgs(1)(cmd, args); err != nil {
+				return err
+			}
+			if (yamlPath == "") == (len(args) == 0) {
+				return fmt.Errorf("either a YAML file or an object name is required")
+			}
+			return nil
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+			ctx := context.WithValue(context.Background(), flag.ContextKey, flag.ContextValType{
+				DryRun:   dryRun,
+				WaitLock: waitLock,
+			})
+
+			var err error
+			if yamlPath != "" {
+				var stack *object.Stack
+				stack, err = object.ParseStack(yamlPath)
+				if err != nil {
+					log.Error("fail to parse yaml file", "yamlPath", yamlPath, "err", err)
+					return
+				}
+				err = object.UndeployStack(ctx, stack, removeVolumes)
+			} else {
+				ketherObject, ketherObjectState := object.GetKetherObjectOfName(args[0])
+				err = object.Undeploy(ctx, ketherObject, ketherObjectState, removeVolumes)
+			}
+			if err != nil {
+				log.Error("fail to undeploy kether object", "err", err)
+				return
+			}
+			log.Info("kether object undeployed")
+		},
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(undeployCmd)
+
+	undeployCmd.Flags().BoolVar(&dryRun, "dry-run", false, "Output actions to be performed without changing any state")
+	undeployCmd.Flags().StringVarP(&yamlPath, "file", "f", "", "Find Kether object with this YAML file path")
+	undeployCmd.Flags().DurationVar(&waitLock, "wait-lock", 0, "Wait up to this duration for other processes modifying the same Kether objects, fail immediately if zero")
+	undeployCmd.Flags().BoolVarP(&removeVolumes, "volumes", "v", false, "Remove anonymous volumes associated with the container")
+}
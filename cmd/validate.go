@@ -0,0 +1,74 @@
+/*
+Copyright (c) 2022 Zhang Zhanpeng <zhangregister@outlook.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"os"
+
+	"github.com/MonteCarloClub/kether/log"
+	"github.com/MonteCarloClub/kether/object"
+	"github.com/spf13/cobra"
+)
+
+// validateCmd represents the validate command
+var (
+	validateYamlPaths []string
+
+	validateCmd = &cobra.Command{
+		Use:   "validate",
+		Short: "Check YAML files of Kether objects without deploying them",
+		Long: `Check YAML files of Kether objects strictly without connecting to the
+registry or the Docker engine. Unknown fields, unknown kinds, missing image
+repositories, malformed ports, volumes and networks, and invalid dependencies
+are reported with their file, line and column, and the command exits with a
+non-zero status if any problem is found. For example:
+
+kether validate -f test/http_echo_stack.yml
+kether validate -f a.yml -f b.yml`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			problemCount := 0
+			for _, yamlPath := range validateYamlPaths {
+				fieldErrors, err := object.ValidateStack(yamlPath)
+				if err != nil {
+					log.Error("fail to validate yaml file", "yamlPath", yamlPath, "err", err)
+					problemCount++
+					continue
+				}
+				printFieldErrors(os.Stdout, yamlPath, fieldErrors)
+				problemCount += len(fieldErrors)
+			}
+			if problemCount > 0 {
+				log.Error("invalid kether objects", "problems", problemCount)
+				os.Exit(1)
+			}
+			log.Info("kether objects validated", "files", len(validateYamlPaths))
+		},
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(validateCmd)
+
+	validateCmd.Flags().StringSliceVarP(&validateYamlPaths, "file", "f", nil, "Check this YAML file path, may be repeated (required)")
+	validateCmd.MarkFlagRequired("file")
+}
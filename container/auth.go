@@ -0,0 +1,182 @@
+/*
+Copyright (c) 2022 Zhang Zhanpeng <zhangregister@outlook.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package container
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/MonteCarloClub/kether/log"
+	"github.com/docker/distribution/reference"
+	"github.com/docker/docker/api/types"
+)
+
+// defaultRegistryServer 是 Docker CLI 保存 Docker Hub 凭据时使用的地址
+const defaultRegistryServer = "https://index.docker.io/v1/"
+
+// dockerCLIConfig 是 Docker CLI 配置文件 config.json 中与镜像仓库凭据有关的部分
+type dockerCLIConfig struct {
+	Auths       map[string]types.AuthConfig `json:"auths"`
+	CredsStore  string                      `json:"credsStore"`
+	CredHelpers map[string]string           `json:"credHelpers"`
+}
+
+func getDockerCLIConfigPath() (string, error) {
+	if dir := os.Getenv("DOCKER_CONFIG"); dir != "" {
+		return filepath.Join(dir, "config.json"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".docker", "config.json"), nil
+}
+
+func loadDockerCLIConfig() (*dockerCLIConfig, error) {
+	dockerCLIConfig := &dockerCLIConfig{}
+	configPath, err := getDockerCLIConfigPath()
+	if err != nil {
+		return dockerCLIConfig, err
+	}
+	configBytes, err := ioutil.ReadFile(configPath)
+	if os.IsNotExist(err) {
+		return dockerCLIConfig, nil
+	}
+	if err != nil {
+		return dockerCLIConfig, err
+	}
+	err = json.Unmarshal(configBytes, dockerCLIConfig)
+	return dockerCLIConfig, err
+}
+
+// getRegistryServer 返回镜像所在的镜像仓库地址，Docker Hub 的镜像使用 defaultRegistryServer
+func getRegistryServer(imageName string) (string, error) {
+	named, err := reference.ParseNormalizedNamed(imageName)
+	if err != nil {
+		return "", err
+	}
+	domain := reference.Domain(named)
+	if domain == "docker.io" {
+		return defaultRegistryServer, nil
+	}
+	return domain, nil
+}
+
+// getAuthConfigFromHelper 通过 docker-credential-<helper> 查询凭据，未保存凭据时返回空配置
+func getAuthConfigFromHelper(helper string, server string) (types.AuthConfig, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("docker-credential-"+helper, "get")
+	cmd.Stdin = strings.NewReader(server)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		output := strings.TrimSpace(stdout.String() + stderr.String())
+		if strings.Contains(output, "credentials not found") {
+			return types.AuthConfig{}, nil
+		}
+		return types.AuthConfig{}, fmt.Errorf("docker-credential-%v: %v: %v", helper, err, output)
+	}
+	credentials := struct {
+		Username string
+		Secret   string
+	}{}
+	if err := json.Unmarshal(stdout.Bytes(), &credentials); err != nil {
+		return types.AuthConfig{}, err
+	}
+	authConfig := types.AuthConfig{
+		ServerAddress: server,
+	}
+	// Docker CLI 以 <token> 作为用户名保存 identity token
+	if credentials.Username == "<token>" {
+		authConfig.IdentityToken = credentials.Secret
+	} else {
+		authConfig.Username = credentials.Username
+		authConfig.Password = credentials.Secret
+	}
+	return authConfig, nil
+}
+
+// getAuthConfig 按 credHelpers、auths、credsStore 的顺序查询镜像仓库的凭据，与 docker login 保存的位置一致
+func getAuthConfig(dockerCLIConfig *dockerCLIConfig, server string) (types.AuthConfig, error) {
+	if helper, ok := dockerCLIConfig.CredHelpers[server]; ok {
+		return getAuthConfigFromHelper(helper, server)
+	}
+	for key, authConfig := range dockerCLIConfig.Auths {
+		if key != server && strings.TrimPrefix(strings.TrimPrefix(key, "https://"), "http://") != server {
+			continue
+		}
+		if authConfig.Auth != "" {
+			decoded, err := base64.StdEncoding.DecodeString(authConfig.Auth)
+			if err != nil {
+				return types.AuthConfig{}, err
+			}
+			usernamePassword := strings.SplitN(string(decoded), ":", 2)
+			if len(usernamePassword) != 2 {
+				return types.AuthConfig{}, fmt.Errorf("invalid auth for %v", key)
+			}
+			authConfig.Username, authConfig.Password = usernamePassword[0], usernamePassword[1]
+			authConfig.Auth = ""
+		}
+		authConfig.ServerAddress = server
+		return authConfig, nil
+	}
+	if dockerCLIConfig.CredsStore != "" {
+		return getAuthConfigFromHelper(dockerCLIConfig.CredsStore, server)
+	}
+	return types.AuthConfig{}, nil
+}
+
+// GetEncodedRegistryAuth 返回拉取镜像和查询 distribution manifest 时使用的 registry auth，
+// 凭据来自 Docker CLI 的配置文件，没有凭据时返回空字符串
+func GetEncodedRegistryAuth(imageName string) (string, error) {
+	server, err := getRegistryServer(imageName)
+	if err != nil {
+		log.Error("fail to parse image name", "imageName", imageName, "err", err)
+		return "", err
+	}
+	dockerCLIConfig, err := loadDockerCLIConfig()
+	if err != nil {
+		log.Error("fail to load docker cli config", "err", err)
+		return "", err
+	}
+	authConfig, err := getAuthConfig(dockerCLIConfig, server)
+	if err != nil {
+		log.Error("fail to get registry credentials", "server", server, "err", err)
+		return "", err
+	}
+	if authConfig == (types.AuthConfig{}) {
+		return "", nil
+	}
+	authBytes, err := json.Marshal(authConfig)
+	if err != nil {
+		log.Error("fail to encode registry credentials", "server", server, "err", err)
+		return "", err
+	}
+	return base64.URLEncoding.EncodeToString(authBytes), nil
+}
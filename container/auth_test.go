@@ -0,0 +1,101 @@
+/*
+Copyright (c) 2022 Zhang Zhanpeng <zhangregister@outlook.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package container
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/stretchr/testify/assert"
+)
+
+const testPrivateImageName = "registry.example.com/kether/bootnode:1.0"
+
+func writeTestDockerCLIConfig(t *testing.T, dockerCLIConfig string) {
+	dir := t.TempDir()
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "config.json"), []byte(dockerCLIConfig), 0600))
+	previous, ok := os.LookupEnv("DOCKER_CONFIG")
+	os.Setenv("DOCKER_CONFIG", dir)
+	t.Cleanup(func() {
+		if ok {
+			os.Setenv("DOCKER_CONFIG", previous)
+		} else {
+			os.Unsetenv("DOCKER_CONFIG")
+		}
+	})
+}
+
+func TestGetEncodedRegistryAuth(t *testing.T) {
+	writeTestDockerCLIConfig(t, `{"auths": {"registry.example.com": {"auth": "a2V0aGVyOnNlY3JldA=="}, "https://index.docker.io/v1/": {"identitytoken": "token"}}}`)
+
+	registryAuth, err := GetEncodedRegistryAuth(testPrivateImageName)
+	assert.Nil(t, err)
+	authBytes, err := base64.URLEncoding.DecodeString(registryAuth)
+	assert.Nil(t, err)
+	authConfig := types.AuthConfig{}
+	assert.Nil(t, json.Unmarshal(authBytes, &authConfig))
+	assert.Equal(t, types.AuthConfig{Username: "kether", Password: "secret", ServerAddress: "registry.example.com"}, authConfig)
+
+	registryAuth, err = GetEncodedRegistryAuth("ethereum/client-go")
+	assert.Nil(t, err)
+	assert.NotEmpty(t, registryAuth)
+
+	registryAuth, err = GetEncodedRegistryAuth("ghcr.io/daocloud/dao-2048")
+	assert.Nil(t, err)
+	assert.Empty(t, registryAuth)
+}
+
+func TestCheckIfDockerImageAvailable(t *testing.T) {
+	writeTestDockerCLIConfig(t, `{"auths": {"registry.example.com": {"auth": "a2V0aGVyOnNlY3JldA=="}}}`)
+	registryAuth, err := GetEncodedRegistryAuth(testPrivateImageName)
+	assert.Nil(t, err)
+
+	fakeEngine := NewFakeEngine()
+	fakeEngine.RemoteImages[testPrivateImageName] = true
+	fakeEngine.RegistryAuths[testPrivateImageName] = registryAuth
+	fakeEngine.LocalImages["kether/cached:1.0"] = true
+	DefaultEngine = fakeEngine
+	ResetImageAvailabilityCache()
+	ctx := context.Background()
+
+	// 私有镜像仓库使用与拉取相同的凭据查询 manifest
+	available, reason := CheckIfDockerImageAvailable(ctx, testPrivateImageName, false)
+	assert.True(t, available, reason)
+	assert.Nil(t, PullDockerImage(ctx, testPrivateImageName))
+
+	// 本地镜像缓存中的镜像不再查询镜像仓库
+	available, reason = CheckIfDockerImageAvailable(ctx, "kether/cached:1.0", false)
+	assert.True(t, available, reason)
+	assert.Equal(t, []string{"InspectImage", "InspectDistribution", "PullImage", "InspectImage"}, fakeEngine.Calls())
+
+	fakeEngine.RegistryAuths["kether/private:1.0"] = registryAuth
+	fakeEngine.RemoteImages["kether/private:1.0"] = true
+	available, reason = CheckIfDockerImageAvailable(ctx, "kether/private:1.0", false)
+	assert.False(t, available)
+	assert.Contains(t, reason, "unauthorized")
+}
@@ -22,21 +22,103 @@ THE SOFTWARE.
 package container
 
 import (
+	"fmt"
+	"net/http"
+	"os"
+
 	"github.com/MonteCarloClub/kether/log"
 	"github.com/docker/docker/client"
 )
 
+const (
+	DefaultDockerContext = "default"
+)
+
 var (
 	DockerApiClient *client.Client
+	DefaultEngine   Engine
+	// DockerContext 是当前使用的 Docker 上下文名称
+	DockerContext string
+	// dockerHost 是 Docker 引擎的地址，ssh:// 地址不会体现在 DaemonHost 中
+	dockerHost string
 )
 
-func InitDockerApiClient() error {
-	var err error
-	DockerApiClient, err = client.NewClientWithOpts()
+// DockerTLSConfig 是连接 Docker 引擎的 TLS 配置
+type DockerTLSConfig struct {
+	CAFile   string
+	CertFile string
+	KeyFile  string
+}
+
+// DockerConfig 是 Docker 引擎的连接配置，未设置的选项使用 DOCKER_HOST、DOCKER_API_VERSION、DOCKER_CERT_PATH 和 DOCKER_TLS_VERIFY 环境变量；
+// Host 可以是 unix://、tcp://、npipe:// 或 ssh://，ssh:// 通过本地 ssh 命令连接远程主机上的 Docker 引擎
+type DockerConfig struct {
+	// Context 是配置所属的 Docker 上下文名称，仅用于展示
+	Context string
+	Host    string
+	// APIVersion 固定客户端的 API 版本，为空时与引擎协商
+	APIVersion string
+	TLS        DockerTLSConfig
+}
+
+func getDockerClientOpts(dockerConfig DockerConfig) ([]client.Opt, error) {
+	opts := []client.Opt{client.FromEnv}
+	if dockerConfig.APIVersion != "" {
+		opts = append(opts, client.WithVersion(dockerConfig.APIVersion))
+	} else if os.Getenv("DOCKER_API_VERSION") == "" {
+		opts = append(opts, client.WithAPIVersionNegotiation())
+	}
+
+	host := dockerConfig.Host
+	if host == "" {
+		host = os.Getenv("DOCKER_HOST")
+	}
+	sshDialContext, err := getSSHDialContext(host)
+	if err != nil {
+		return nil, err
+	}
+	if sshDialContext != nil {
+		// 主机名只用于 HTTP 请求，连接由 ssh 命令建立
+		opts = append(opts,
+			client.WithHTTPClient(&http.Client{Transport: &http.Transport{DialContext: sshDialContext}}),
+			client.WithHost("http://docker.example.com"),
+			client.WithDialContext(sshDialContext),
+		)
+	} else if dockerConfig.Host != "" {
+		opts = append(opts, client.WithHost(dockerConfig.Host))
+	}
+
+	tlsConfig := dockerConfig.TLS
+	if tlsConfig.CAFile != "" || tlsConfig.CertFile != "" || tlsConfig.KeyFile != "" {
+		if sshDialContext != nil {
+			return nil, fmt.Errorf("tls cannot be used with ssh host %v", host)
+		}
+		if (tlsConfig.CertFile == "") != (tlsConfig.KeyFile == "") {
+			return nil, fmt.Errorf("tls cert file and key file must be set together")
+		}
+		opts = append(opts, client.WithTLSClientConfig(tlsConfig.CAFile, tlsConfig.CertFile, tlsConfig.KeyFile))
+	}
+	return opts, nil
+}
+
+// InitDockerApiClient 按配置构造 Docker 客户端，并把它设置为缺省的 Docker 引擎
+func InitDockerApiClient(dockerConfig DockerConfig) error {
+	opts, err := getDockerClientOpts(dockerConfig)
 	if err != nil {
-		log.Error("fail to init docker api client", "err", err)
+		log.Error("invalid docker config", "context", dockerConfig.Context, "err", err)
+		return fmt.Errorf("invalid docker config of context %v: %v", dockerConfig.Context, err)
+	}
+	DockerApiClient, err = client.NewClientWithOpts(opts...)
+	if err != nil {
+		log.Error("fail to init docker api client", "context", dockerConfig.Context, "err", err)
 		return err
 	}
-	log.Info("docker api client inited")
+	DefaultEngine = NewDockerEngine(DockerApiClient)
+	DockerContext = dockerConfig.Context
+	dockerHost = dockerConfig.Host
+	if dockerHost == "" {
+		dockerHost = DockerApiClient.DaemonHost()
+	}
+	log.Info("docker api client inited", "context", dockerConfig.Context, "host", DockerApiClient.DaemonHost())
 	return nil
 }
@@ -23,15 +23,20 @@ package container
 
 import (
 	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
 
 	"github.com/MonteCarloClub/kether/log"
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/client"
 )
 
 func CreateDockerContainer(ctx context.Context, containerConfig *container.Config, hostConfig *container.HostConfig, networkingConfig *network.NetworkingConfig, containerName string) (string, error) {
-	containerCreateCreatedBody, err := DockerApiClient.ContainerCreate(ctx, containerConfig, hostConfig, networkingConfig, nil, containerName)
+	containerCreateCreatedBody, err := DefaultEngine.CreateContainer(ctx, containerConfig, hostConfig, networkingConfig, containerName)
 	if len(containerCreateCreatedBody.Warnings) > 0 {
 		log.Warn("ContainerCreate returned warning(s)", "warning", containerCreateCreatedBody.Warnings)
 	}
@@ -43,29 +48,71 @@ func CreateDockerContainer(ctx context.Context, containerConfig *container.Confi
 	return containerCreateCreatedBody.ID, nil
 }
 
-func RunDockerContainer(ctx context.Context, id string) error {
-	err := DockerApiClient.ContainerStart(ctx, id, types.ContainerStartOptions{})
+// logsDrainTimeout 是容器退出后等待日志流输出剩余日志的最长时间
+var logsDrainTimeout = 2 * time.Second
+
+// RunDockerContainer 在前台运行容器，把容器日志输出到终端，返回容器的退出码
+func RunDockerContainer(ctx context.Context, id string) (int64, error) {
+	err := DefaultEngine.StartContainer(ctx, id)
 	if err != nil {
 		log.Error("fail to start container", "id", id, "err", err)
-		return err
+		return 0, err
 	}
 	log.Info("container started", "id", id)
 
-	statusCh, errChan := DockerApiClient.ContainerWait(ctx, id, container.WaitConditionNotRunning)
+	// 按重启策略被重启的容器的日志流不随进程退出而结束，等待结束后取消日志流
+	logsCtx, cancelLogs := context.WithCancel(ctx)
+	defer cancelLogs()
+	reader, tty, err := openDockerContainerLogs(logsCtx, id, types.ContainerLogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		Follow:     true,
+	})
+	if err != nil {
+		log.Error("fail to attach container logs", "id", id, "err", err)
+		return 0, err
+	}
+	defer reader.Close()
+	logsDone := make(chan struct{})
+	go func() {
+		defer close(logsDone)
+		err := copyDockerContainerLogs(reader, tty, os.Stdout, os.Stderr)
+		if err != nil && logsCtx.Err() == nil {
+			log.Warn("fail to stream container logs", "id", id, "err", err)
+		}
+	}()
+	stopLogs := func() {
+		select {
+		case <-logsDone:
+		case <-time.After(logsDrainTimeout):
+			cancelLogs()
+			reader.Close()
+			<-logsDone
+		}
+	}
+
+	statusCh, errChan := DefaultEngine.WaitContainer(ctx, id, container.WaitConditionNotRunning)
 	select {
-	case <-statusCh:
-		log.Info("container not running", "id", id)
+	case status := <-statusCh:
+		// 容器退出后日志流通常随之结束，等待剩余日志输出完毕
+		stopLogs()
+		if status.Error != nil {
+			err = fmt.Errorf("%v", status.Error.Message)
+			log.Error("error encountered while waiting container", "id", id, "err", err)
+			return status.StatusCode, err
+		}
+		log.Info("container not running", "id", id, "statusCode", status.StatusCode)
+		return status.StatusCode, nil
 	case err := <-errChan:
+		cancelLogs()
+		<-logsDone
 		log.Error("error encountered while container running", "id", id, "err", err)
-		return err
+		return 0, err
 	}
-
-	// TODO 输出容器日志，参考：https://docs.docker.com/engine/api/sdk/examples/
-	return nil
 }
 
 func RunDockerContainerInBackground(ctx context.Context, id string) error {
-	err := DockerApiClient.ContainerStart(ctx, id, types.ContainerStartOptions{})
+	err := DefaultEngine.StartContainer(ctx, id)
 	if err != nil {
 		log.Error("fail to start container in background", "id", id, "err", err)
 		return err
@@ -73,3 +120,88 @@ func RunDockerContainerInBackground(ctx context.Context, id string) error {
 	log.Info("container started in background", "id", id)
 	return nil
 }
+
+// StopDockerContainer 停止容器，容器不存在时返回 false
+func StopDockerContainer(ctx context.Context, id string) (bool, error) {
+	err := DefaultEngine.StopContainer(ctx, id)
+	if client.IsErrNotFound(err) {
+		log.Warn("container not found, skip stopping", "id", id)
+		return false, nil
+	}
+	if err != nil {
+		log.Error("fail to stop container", "id", id, "err", err)
+		return true, err
+	}
+	log.Info("container stopped", "id", id)
+	return true, nil
+}
+
+// RemoveDockerContainer 删除容器，removeVolumes 为 true 时同时删除容器的匿名卷
+func RemoveDockerContainer(ctx context.Context, id string, removeVolumes bool) error {
+	err := DefaultEngine.RemoveContainer(ctx, id, removeVolumes)
+	if client.IsErrNotFound(err) {
+		log.Warn("container not found, skip removing", "id", id)
+		return nil
+	}
+	if err != nil {
+		log.Error("fail to remove container", "id", id, "removeVolumes", removeVolumes, "err", err)
+		return err
+	}
+	log.Info("container removed", "id", id, "removeVolumes", removeVolumes)
+	return nil
+}
+
+// RenameDockerContainer 重命名容器，用于以新容器替换同名的旧容器
+func RenameDockerContainer(ctx context.Context, id string, newName string) error {
+	err := DefaultEngine.RenameContainer(ctx, id, newName)
+	if err != nil {
+		log.Error("fail to rename container", "id", id, "newName", newName, "err", err)
+		return err
+	}
+	log.Info("container renamed", "id", id, "newName", newName)
+	return nil
+}
+
+// InspectDockerContainer 查询容器详情，容器不存在时返回 ok == false
+func InspectDockerContainer(ctx context.Context, id string) (containerJSON types.ContainerJSON, ok bool, err error) {
+	containerJSON, err = DefaultEngine.InspectContainer(ctx, id)
+	if client.IsErrNotFound(err) {
+		return containerJSON, false, nil
+	}
+	if err != nil {
+		log.Error("fail to inspect container", "id", id, "err", err)
+		return containerJSON, false, err
+	}
+	return containerJSON, true, nil
+}
+
+// ExecInDockerContainer 在运行中的容器内执行命令，返回退出码和输出
+func ExecInDockerContainer(ctx context.Context, id string, cmd []string) (int, string, error) {
+	exitCode, output, err := DefaultEngine.ExecContainer(ctx, id, cmd)
+	if err != nil {
+		log.Error("fail to exec in container", "id", id, "cmd", cmd, "err", err)
+		return 0, output, err
+	}
+	return exitCode, output, nil
+}
+
+// FindDockerContainerOfHostPort 查找发布了主机端口的运行中的容器，返回容器名称，没有找到时返回空字符串
+func FindDockerContainerOfHostPort(ctx context.Context, hostPort int, protocol string) (string, error) {
+	containers, err := DefaultEngine.ListContainers(ctx)
+	if err != nil {
+		log.Error("fail to list containers", "err", err)
+		return "", err
+	}
+	for _, container := range containers {
+		for _, port := range container.Ports {
+			if int(port.PublicPort) != hostPort || port.Type != protocol {
+				continue
+			}
+			if len(container.Names) > 0 {
+				return strings.TrimPrefix(container.Names[0], "/"), nil
+			}
+			return container.ID, nil
+		}
+	}
+	return "", nil
+}
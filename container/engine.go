@@ -0,0 +1,189 @@
+/*
+Copyright (c) 2022 Zhang Zhanpeng <zhangregister@outlook.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package container
+
+import (
+	"bytes"
+	"context"
+	"io"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/api/types/registry"
+	"github.com/docker/docker/api/types/volume"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/stdcopy"
+)
+
+// Engine 抽象 Kether 用到的容器引擎操作，容器可由 ID 或名称指定
+type Engine interface {
+	CreateContainer(ctx context.Context, containerConfig *container.Config, hostConfig *container.HostConfig, networkingConfig *network.NetworkingConfig, containerName string) (container.ContainerCreateCreatedBody, error)
+	StartContainer(ctx context.Context, id string) error
+	WaitContainer(ctx context.Context, id string, condition container.WaitCondition) (<-chan container.ContainerWaitOKBody, <-chan error)
+	StopContainer(ctx context.Context, id string) error
+	RemoveContainer(ctx context.Context, id string, removeVolumes bool) error
+	RenameContainer(ctx context.Context, id string, newName string) error
+	// PullImage 拉取镜像，registryAuth 是 base64 编码的镜像仓库凭据，可以为空
+	PullImage(ctx context.Context, imageName string, registryAuth string) (io.ReadCloser, error)
+	// InspectImage 查询本地镜像缓存
+	InspectImage(ctx context.Context, imageName string) (types.ImageInspect, error)
+	// InspectDistribution 查询镜像仓库的 distribution manifest
+	InspectDistribution(ctx context.Context, imageName string, registryAuth string) (registry.DistributionInspect, error)
+	InspectContainer(ctx context.Context, id string) (types.ContainerJSON, error)
+	// ListContainers 列出运行中的容器及其发布的端口
+	ListContainers(ctx context.Context) ([]types.Container, error)
+	GetContainerLogs(ctx context.Context, id string, options types.ContainerLogsOptions) (io.ReadCloser, error)
+	// ExecContainer 在运行中的容器内执行命令，返回退出码和合并的标准输出与标准错误
+	ExecContainer(ctx context.Context, id string, cmd []string) (int, string, error)
+	// GetInfo 查询引擎的系统信息，如存储驱动、cgroup 驱动和运行时
+	GetInfo(ctx context.Context) (types.Info, error)
+	GetServerVersion(ctx context.Context) (types.Version, error)
+	// InspectNetwork 按名称或 ID 查询网络
+	InspectNetwork(ctx context.Context, networkName string) (types.NetworkResource, error)
+	CreateNetwork(ctx context.Context, networkName string, options types.NetworkCreate) (types.NetworkCreateResponse, error)
+	// ConnectNetwork 把已经创建的容器连接到网络
+	ConnectNetwork(ctx context.Context, networkName string, id string, endpointSettings *network.EndpointSettings) error
+	// InspectVolume 按名称查询命名卷
+	InspectVolume(ctx context.Context, volumeName string) (types.Volume, error)
+	CreateVolume(ctx context.Context, options volume.VolumeCreateBody) (types.Volume, error)
+}
+
+// dockerEngine 是基于 Docker SDK 的 Engine 实现
+type dockerEngine struct {
+	dockerApiClient *client.Client
+}
+
+func NewDockerEngine(dockerApiClient *client.Client) Engine {
+	return &dockerEngine{
+		dockerApiClient: dockerApiClient,
+	}
+}
+
+func (engine *dockerEngine) CreateContainer(ctx context.Context, containerConfig *container.Config, hostConfig *container.HostConfig, networkingConfig *network.NetworkingConfig, containerName string) (container.ContainerCreateCreatedBody, error) {
+	return engine.dockerApiClient.ContainerCreate(ctx, containerConfig, hostConfig, networkingConfig, nil, containerName)
+}
+
+func (engine *dockerEngine) StartContainer(ctx context.Context, id string) error {
+	return engine.dockerApiClient.ContainerStart(ctx, id, types.ContainerStartOptions{})
+}
+
+func (engine *dockerEngine) WaitContainer(ctx context.Context, id string, condition container.WaitCondition) (<-chan container.ContainerWaitOKBody, <-chan error) {
+	return engine.dockerApiClient.ContainerWait(ctx, id, condition)
+}
+
+func (engine *dockerEngine) StopContainer(ctx context.Context, id string) error {
+	return engine.dockerApiClient.ContainerStop(ctx, id, nil)
+}
+
+func (engine *dockerEngine) RemoveContainer(ctx context.Context, id string, removeVolumes bool) error {
+	return engine.dockerApiClient.ContainerRemove(ctx, id, types.ContainerRemoveOptions{
+		RemoveVolumes: removeVolumes,
+	})
+}
+
+func (engine *dockerEngine) RenameContainer(ctx context.Context, id string, newName string) error {
+	return engine.dockerApiClient.ContainerRename(ctx, id, newName)
+}
+
+func (engine *dockerEngine) PullImage(ctx context.Context, imageName string, registryAuth string) (io.ReadCloser, error) {
+	return engine.dockerApiClient.ImagePull(ctx, imageName, types.ImagePullOptions{
+		RegistryAuth: registryAuth,
+	})
+}
+
+func (engine *dockerEngine) InspectImage(ctx context.Context, imageName string) (types.ImageInspect, error) {
+	imageInspect, _, err := engine.dockerApiClient.ImageInspectWithRaw(ctx, imageName)
+	return imageInspect, err
+}
+
+func (engine *dockerEngine) InspectDistribution(ctx context.Context, imageName string, registryAuth string) (registry.DistributionInspect, error) {
+	return engine.dockerApiClient.DistributionInspect(ctx, imageName, registryAuth)
+}
+
+func (engine *dockerEngine) InspectContainer(ctx context.Context, id string) (types.ContainerJSON, error) {
+	return engine.dockerApiClient.ContainerInspect(ctx, id)
+}
+
+func (engine *dockerEngine) ListContainers(ctx context.Context) ([]types.Container, error) {
+	return engine.dockerApiClient.ContainerList(ctx, types.ContainerListOptions{})
+}
+
+func (engine *dockerEngine) GetContainerLogs(ctx context.Context, id string, options types.ContainerLogsOptions) (io.ReadCloser, error) {
+	return engine.dockerApiClient.ContainerLogs(ctx, id, options)
+}
+
+func (engine *dockerEngine) ExecContainer(ctx context.Context, id string, cmd []string) (int, string, error) {
+	idResponse, err := engine.dockerApiClient.ContainerExecCreate(ctx, id, types.ExecConfig{
+		AttachStdout: true,
+		AttachStderr: true,
+		Cmd:          cmd,
+	})
+	if err != nil {
+		return 0, "", err
+	}
+
+	hijackedResponse, err := engine.dockerApiClient.ContainerExecAttach(ctx, idResponse.ID, types.ExecStartCheck{})
+	if err != nil {
+		return 0, "", err
+	}
+	defer hijackedResponse.Close()
+	output := &bytes.Buffer{}
+	_, err = stdcopy.StdCopy(output, output, hijackedResponse.Reader)
+	if err != nil {
+		return 0, output.String(), err
+	}
+
+	execInspect, err := engine.dockerApiClient.ContainerExecInspect(ctx, idResponse.ID)
+	if err != nil {
+		return 0, output.String(), err
+	}
+	return execInspect.ExitCode, output.String(), nil
+}
+
+func (engine *dockerEngine) GetInfo(ctx context.Context) (types.Info, error) {
+	return engine.dockerApiClient.Info(ctx)
+}
+
+func (engine *dockerEngine) GetServerVersion(ctx context.Context) (types.Version, error) {
+	return engine.dockerApiClient.ServerVersion(ctx)
+}
+
+func (engine *dockerEngine) InspectNetwork(ctx context.Context, networkName string) (types.NetworkResource, error) {
+	return engine.dockerApiClient.NetworkInspect(ctx, networkName, types.NetworkInspectOptions{})
+}
+
+func (engine *dockerEngine) CreateNetwork(ctx context.Context, networkName string, options types.NetworkCreate) (types.NetworkCreateResponse, error) {
+	return engine.dockerApiClient.NetworkCreate(ctx, networkName, options)
+}
+
+func (engine *dockerEngine) ConnectNetwork(ctx context.Context, networkName string, id string, endpointSettings *network.EndpointSettings) error {
+	return engine.dockerApiClient.NetworkConnect(ctx, networkName, id, endpointSettings)
+}
+
+func (engine *dockerEngine) InspectVolume(ctx context.Context, volumeName string) (types.Volume, error) {
+	return engine.dockerApiClient.VolumeInspect(ctx, volumeName)
+}
+
+func (engine *dockerEngine) CreateVolume(ctx context.Context, options volume.VolumeCreateBody) (types.Volume, error) {
+	return engine.dockerApiClient.VolumeCreate(ctx, options)
+}
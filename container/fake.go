@@ -0,0 +1,715 @@
+/*
+Copyright (c) 2022 Zhang Zhanpeng <zhangregister@outlook.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package container
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/distribution/reference"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/api/types/registry"
+	"github.com/docker/docker/api/types/volume"
+	"github.com/docker/docker/errdefs"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/docker/go-connections/nat"
+)
+
+// FakeEngine 是进程内的 Engine 实现，记录调用并模拟容器的生命周期，用于测试
+type FakeEngine struct {
+	// Errors 按方法名或方法名加参数注入错误，如 "PullImage" 或 "CreateContainer bootnode"
+	Errors map[string]error
+	// ExitCodes 按容器名指定退出码，指定了退出码的容器启动后立即以该退出码退出，否则一直运行到被停止
+	ExitCodes map[string]int
+	// Logs 按容器名指定容器的标准输出
+	Logs map[string]string
+	// FollowLogs 按容器名指定跟随的日志流在容器退出后仍不结束，直到 ctx 被取消，模拟按重启策略被重启的容器
+	FollowLogs map[string]bool
+	// LocalImages 是本地镜像缓存中的镜像名，拉取成功的镜像会被加入
+	LocalImages map[string]bool
+	// RemoteImages 是镜像仓库中存在的镜像名
+	RemoteImages map[string]bool
+	// RegistryAuths 按镜像名指定私有镜像仓库要求的 registry auth，不匹配时拉取和查询 manifest 返回未授权错误
+	RegistryAuths map[string]string
+	// HealthStatuses 按容器名指定 Docker 健康检查的状态，如 "healthy"
+	HealthStatuses map[string]string
+	// ExecExitCodes 按容器名指定在容器内执行命令的退出码，缺省为 0
+	ExecExitCodes map[string]int
+	// Info 和 Version 是引擎的系统信息和版本
+	Info    types.Info
+	Version types.Version
+	// Networks 按名称记录已经存在的网络，缺省有 bridge、host 和 none，创建的网络会被加入
+	Networks map[string]types.NetworkResource
+	// Volumes 按名称记录已经存在的命名卷，创建的卷会被加入
+	Volumes map[string]types.Volume
+
+	mu         sync.Mutex
+	calls      []string
+	targets    []string
+	containers map[string]*fakeContainer
+	nextId     int
+	nextPort   int
+}
+
+type fakeContainer struct {
+	id               string
+	name             string
+	config           *container.Config
+	hostConfig       *container.HostConfig
+	networkingConfig *network.NetworkingConfig
+	status           string
+	exitCode         int
+	startedAt        time.Time
+	// ports 是容器启动时绑定的主机端口，与 Docker 一致，空的主机端口从 32768 起分配
+	ports   nat.PortMap
+	waiters []chan container.ContainerWaitOKBody
+}
+
+func NewFakeEngine() *FakeEngine {
+	return &FakeEngine{
+		Errors:         make(map[string]error),
+		ExitCodes:      make(map[string]int),
+		Logs:           make(map[string]string),
+		FollowLogs:     make(map[string]bool),
+		LocalImages:    make(map[string]bool),
+		RemoteImages:   make(map[string]bool),
+		RegistryAuths:  make(map[string]string),
+		HealthStatuses: make(map[string]string),
+		ExecExitCodes:  make(map[string]int),
+		Info: types.Info{
+			Name:            "fake-host",
+			OperatingSystem: "Fake Linux",
+			OSType:          "linux",
+			Architecture:    "x86_64",
+			Driver:          "overlay2",
+			CgroupDriver:    "systemd",
+			CgroupVersion:   "2",
+			DefaultRuntime:  "runc",
+			MemoryLimit:     true,
+			SwapLimit:       true,
+			CPUCfsQuota:     true,
+			PidsLimit:       true,
+			IPv4Forwarding:  true,
+		},
+		Version: types.Version{
+			Version:       "20.10.12",
+			APIVersion:    "1.41",
+			MinAPIVersion: "1.12",
+			Os:            "linux",
+			Arch:          "amd64",
+		},
+		Networks: map[string]types.NetworkResource{
+			"bridge": {Name: "bridge", ID: fmt.Sprintf("fakenet%057d", 0), Driver: "bridge"},
+			"host":   {Name: "host", ID: fmt.Sprintf("fakenet%057d", 1), Driver: "host"},
+			"none":   {Name: "none", ID: fmt.Sprintf("fakenet%057d", 2), Driver: "null"},
+		},
+		Volumes:    make(map[string]types.Volume),
+		containers: make(map[string]*fakeContainer),
+	}
+}
+
+// Calls 返回按顺序记录的方法名
+func (engine *FakeEngine) Calls() []string {
+	engine.mu.Lock()
+	defer engine.mu.Unlock()
+	return append([]string(nil), engine.calls...)
+}
+
+// CallsWithTarget 返回按顺序记录的方法名和参数，如 "CreateContainer bootnode"
+func (engine *FakeEngine) CallsWithTarget() []string {
+	engine.mu.Lock()
+	defer engine.mu.Unlock()
+	callsWithTarget := make([]string, 0, len(engine.calls))
+	for i := range engine.calls {
+		callsWithTarget = append(callsWithTarget, fmt.Sprintf("%v %v", engine.calls[i], engine.targets[i]))
+	}
+	return callsWithTarget
+}
+
+// GetContainer 返回容器的状态和创建参数，容器不存在时返回 ok == false
+func (engine *FakeEngine) GetContainer(id string) (status string, containerConfig *container.Config, hostConfig *container.HostConfig, ok bool) {
+	engine.mu.Lock()
+	defer engine.mu.Unlock()
+	fakeContainer := engine.lookup(id)
+	if fakeContainer == nil {
+		return "", nil, nil, false
+	}
+	return fakeContainer.status, fakeContainer.config, fakeContainer.hostConfig, true
+}
+
+// record 记录调用并返回注入的错误，调用方需持有锁
+func (engine *FakeEngine) record(method string, target string) error {
+	engine.calls = append(engine.calls, method)
+	engine.targets = append(engine.targets, target)
+	if err, ok := engine.Errors[fmt.Sprintf("%v %v", method, target)]; ok {
+		return err
+	}
+	return engine.Errors[method]
+}
+
+func (engine *FakeEngine) lookup(id string) *fakeContainer {
+	if fakeContainer, ok := engine.containers[id]; ok {
+		return fakeContainer
+	}
+	for _, fakeContainer := range engine.containers {
+		if fakeContainer.name == id {
+			return fakeContainer
+		}
+	}
+	return nil
+}
+
+// getFakeImageId 返回由镜像名决定的镜像 ID
+func getFakeImageId(imageName string) string {
+	return fmt.Sprintf("sha256:%064x", len(imageName))
+}
+
+// getFakeRepoDigest 返回由镜像名决定的仓库摘要引用，如 busybox@sha256:...，摘要引用本身原样返回
+func getFakeRepoDigest(imageName string) string {
+	named, err := reference.ParseNormalizedNamed(imageName)
+	if err != nil {
+		return ""
+	}
+	if _, ok := named.(reference.Canonical); ok {
+		return reference.FamiliarString(named)
+	}
+	return fmt.Sprintf("%v@sha256:%x", reference.FamiliarName(named), sha256.Sum256([]byte(imageName)))
+}
+
+// isRemoteImage 判断镜像是否存在于仓库中，仓库中镜像的摘要引用也视为存在，调用方需持有锁
+func (engine *FakeEngine) isRemoteImage(imageName string) bool {
+	if engine.RemoteImages[imageName] {
+		return true
+	}
+	for remoteImageName, ok := range engine.RemoteImages {
+		if ok && getFakeRepoDigest(remoteImageName) == imageName {
+			return true
+		}
+	}
+	return false
+}
+
+func notFoundError(id string) error {
+	return errdefs.NotFound(fmt.Errorf("no such container: %v", id))
+}
+
+// exit 把容器置为退出状态并通知等待者，调用方需持有锁
+func (fakeContainer *fakeContainer) exit(exitCode int) {
+	fakeContainer.status = "exited"
+	fakeContainer.exitCode = exitCode
+	fakeContainer.ports = nil
+	for _, waiter := range fakeContainer.waiters {
+		waiter <- container.ContainerWaitOKBody{StatusCode: int64(exitCode)}
+		close(waiter)
+	}
+	fakeContainer.waiters = nil
+}
+
+func (engine *FakeEngine) CreateContainer(ctx context.Context, containerConfig *container.Config, hostConfig *container.HostConfig, networkingConfig *network.NetworkingConfig, containerName string) (container.ContainerCreateCreatedBody, error) {
+	engine.mu.Lock()
+	defer engine.mu.Unlock()
+	if err := engine.record("CreateContainer", containerName); err != nil {
+		return container.ContainerCreateCreatedBody{}, err
+	}
+	if containerName != "" && engine.lookup(containerName) != nil {
+		return container.ContainerCreateCreatedBody{}, errdefs.Conflict(fmt.Errorf("container name %v is already in use", containerName))
+	}
+
+	engine.nextId++
+	id := fmt.Sprintf("fake%060d", engine.nextId)
+	engine.containers[id] = &fakeContainer{
+		id:               id,
+		name:             containerName,
+		config:           containerConfig,
+		hostConfig:       hostConfig,
+		networkingConfig: networkingConfig,
+		status:           "created",
+	}
+	return container.ContainerCreateCreatedBody{ID: id}, nil
+}
+
+func (engine *FakeEngine) bindPorts(hostConfig *container.HostConfig) nat.PortMap {
+	if hostConfig == nil || len(hostConfig.PortBindings) == 0 {
+		return nil
+	}
+	ports := make(nat.PortMap, len(hostConfig.PortBindings))
+	for port, portBindings := range hostConfig.PortBindings {
+		for _, portBinding := range portBindings {
+			hostIP := portBinding.HostIP
+			if hostIP == "" {
+				hostIP = "0.0.0.0"
+			}
+			hostPort, _, _ := nat.ParsePortRange(portBinding.HostPort)
+			if hostPort == 0 {
+				hostPort = uint64(32768 + engine.nextPort)
+				engine.nextPort++
+			}
+			ports[port] = append(ports[port], nat.PortBinding{HostIP: hostIP, HostPort: strconv.FormatUint(hostPort, 10)})
+		}
+	}
+	return ports
+}
+
+func (engine *FakeEngine) StartContainer(ctx context.Context, id string) error {
+	engine.mu.Lock()
+	defer engine.mu.Unlock()
+	if err := engine.record("StartContainer", id); err != nil {
+		return err
+	}
+	fakeContainer := engine.lookup(id)
+	if fakeContainer == nil {
+		return notFoundError(id)
+	}
+	fakeContainer.status = "running"
+	fakeContainer.startedAt = time.Now()
+	fakeContainer.ports = engine.bindPorts(fakeContainer.hostConfig)
+	if exitCode, ok := engine.ExitCodes[fakeContainer.name]; ok {
+		fakeContainer.exit(exitCode)
+	}
+	return nil
+}
+
+func (engine *FakeEngine) WaitContainer(ctx context.Context, id string, condition container.WaitCondition) (<-chan container.ContainerWaitOKBody, <-chan error) {
+	engine.mu.Lock()
+	defer engine.mu.Unlock()
+	statusChan := make(chan container.ContainerWaitOKBody, 1)
+	errChan := make(chan error, 1)
+	if err := engine.record("WaitContainer", id); err != nil {
+		errChan <- err
+		return statusChan, errChan
+	}
+	fakeContainer := engine.lookup(id)
+	if fakeContainer == nil {
+		errChan <- notFoundError(id)
+		return statusChan, errChan
+	}
+	if fakeContainer.status != "running" {
+		statusChan <- container.ContainerWaitOKBody{StatusCode: int64(fakeContainer.exitCode)}
+		return statusChan, errChan
+	}
+	fakeContainer.waiters = append(fakeContainer.waiters, statusChan)
+	return statusChan, errChan
+}
+
+func (engine *FakeEngine) StopContainer(ctx context.Context, id string) error {
+	engine.mu.Lock()
+	defer engine.mu.Unlock()
+	if err := engine.record("StopContainer", id); err != nil {
+		return err
+	}
+	fakeContainer := engine.lookup(id)
+	if fakeContainer == nil {
+		return notFoundError(id)
+	}
+	if fakeContainer.status == "running" {
+		fakeContainer.exit(0)
+	}
+	return nil
+}
+
+func (engine *FakeEngine) RemoveContainer(ctx context.Context, id string, removeVolumes bool) error {
+	engine.mu.Lock()
+	defer engine.mu.Unlock()
+	if err := engine.record("RemoveContainer", id); err != nil {
+		return err
+	}
+	fakeContainer := engine.lookup(id)
+	if fakeContainer == nil {
+		return notFoundError(id)
+	}
+	if fakeContainer.status == "running" {
+		return errdefs.Conflict(fmt.Errorf("container %v is running", id))
+	}
+	delete(engine.containers, fakeContainer.id)
+	return nil
+}
+
+func (engine *FakeEngine) RenameContainer(ctx context.Context, id string, newName string) error {
+	engine.mu.Lock()
+	defer engine.mu.Unlock()
+	if err := engine.record("RenameContainer", id); err != nil {
+		return err
+	}
+	fakeContainer := engine.lookup(id)
+	if fakeContainer == nil {
+		return notFoundError(id)
+	}
+	if other := engine.lookup(newName); other != nil && other != fakeContainer {
+		return errdefs.Conflict(fmt.Errorf("container name %v is already in use", newName))
+	}
+	fakeContainer.name = newName
+	return nil
+}
+
+func (engine *FakeEngine) PullImage(ctx context.Context, imageName string, registryAuth string) (io.ReadCloser, error) {
+	engine.mu.Lock()
+	defer engine.mu.Unlock()
+	if err := engine.record("PullImage", imageName); err != nil {
+		return nil, err
+	}
+	if err := engine.checkRegistryAuth(imageName, registryAuth); err != nil {
+		return nil, err
+	}
+	if !engine.isRemoteImage(imageName) {
+		return nil, errdefs.NotFound(fmt.Errorf("manifest for %v not found", imageName))
+	}
+	engine.LocalImages[imageName] = true
+	return ioutil.NopCloser(strings.NewReader(fmt.Sprintf("{\"status\":\"Downloaded newer image for %v\"}\n", imageName))), nil
+}
+
+func (engine *FakeEngine) checkRegistryAuth(imageName string, registryAuth string) error {
+	if expected, ok := engine.RegistryAuths[imageName]; ok && expected != registryAuth {
+		return errdefs.Unauthorized(fmt.Errorf("unauthorized: authentication required for %v", imageName))
+	}
+	return nil
+}
+
+func (engine *FakeEngine) InspectImage(ctx context.Context, imageName string) (types.ImageInspect, error) {
+	engine.mu.Lock()
+	defer engine.mu.Unlock()
+	if err := engine.record("InspectImage", imageName); err != nil {
+		return types.ImageInspect{}, err
+	}
+	if !engine.LocalImages[imageName] {
+		return types.ImageInspect{}, errdefs.NotFound(fmt.Errorf("no such image: %v", imageName))
+	}
+	imageInspect := types.ImageInspect{
+		ID:       getFakeImageId(imageName),
+		RepoTags: []string{imageName},
+	}
+	// 只有从仓库拉取的镜像有仓库摘要
+	if engine.isRemoteImage(imageName) {
+		imageInspect.RepoDigests = []string{getFakeRepoDigest(imageName)}
+	}
+	return imageInspect, nil
+}
+
+func (engine *FakeEngine) InspectDistribution(ctx context.Context, imageName string, registryAuth string) (registry.DistributionInspect, error) {
+	engine.mu.Lock()
+	defer engine.mu.Unlock()
+	if err := engine.record("InspectDistribution", imageName); err != nil {
+		return registry.DistributionInspect{}, err
+	}
+	if err := engine.checkRegistryAuth(imageName, registryAuth); err != nil {
+		return registry.DistributionInspect{}, err
+	}
+	if !engine.isRemoteImage(imageName) {
+		return registry.DistributionInspect{}, errdefs.NotFound(fmt.Errorf("manifest for %v not found", imageName))
+	}
+	return registry.DistributionInspect{}, nil
+}
+
+func (engine *FakeEngine) InspectContainer(ctx context.Context, id string) (types.ContainerJSON, error) {
+	engine.mu.Lock()
+	defer engine.mu.Unlock()
+	if err := engine.record("InspectContainer", id); err != nil {
+		return types.ContainerJSON{}, err
+	}
+	fakeContainer := engine.lookup(id)
+	if fakeContainer == nil {
+		return types.ContainerJSON{}, notFoundError(id)
+	}
+	startedAt := ""
+	if !fakeContainer.startedAt.IsZero() {
+		startedAt = fakeContainer.startedAt.Format(time.RFC3339Nano)
+	}
+	var health *types.Health
+	if healthStatus, ok := engine.HealthStatuses[fakeContainer.name]; ok {
+		health = &types.Health{
+			Status: healthStatus,
+		}
+	}
+	// 与 Docker 一致，没有指定网络的容器连接到 bridge 网络
+	networks := map[string]*network.EndpointSettings{
+		"bridge": {},
+	}
+	if fakeContainer.networkingConfig != nil && len(fakeContainer.networkingConfig.EndpointsConfig) > 0 {
+		networks = fakeContainer.networkingConfig.EndpointsConfig
+	}
+	imageId := ""
+	if fakeContainer.config != nil {
+		imageId = getFakeImageId(fakeContainer.config.Image)
+	}
+	return types.ContainerJSON{
+		ContainerJSONBase: &types.ContainerJSONBase{
+			ID:    fakeContainer.id,
+			Name:  "/" + fakeContainer.name,
+			Image: imageId,
+			State: &types.ContainerState{
+				Status:    fakeContainer.status,
+				Running:   fakeContainer.status == "running",
+				ExitCode:  fakeContainer.exitCode,
+				StartedAt: startedAt,
+				Health:    health,
+			},
+			HostConfig: fakeContainer.hostConfig,
+		},
+		Config: fakeContainer.config,
+		NetworkSettings: &types.NetworkSettings{
+			NetworkSettingsBase: types.NetworkSettingsBase{
+				Ports: fakeContainer.ports,
+			},
+			Networks: networks,
+		},
+	}, nil
+}
+
+func (engine *FakeEngine) ListContainers(ctx context.Context) ([]types.Container, error) {
+	engine.mu.Lock()
+	defer engine.mu.Unlock()
+	if err := engine.record("ListContainers", ""); err != nil {
+		return nil, err
+	}
+	containers := make([]types.Container, 0, len(engine.containers))
+	for _, fakeContainer := range engine.containers {
+		if fakeContainer.status != "running" {
+			continue
+		}
+		ports := make([]types.Port, 0)
+		for port, portBindings := range fakeContainer.ports {
+			for _, portBinding := range portBindings {
+				hostPort, _ := strconv.Atoi(portBinding.HostPort)
+				ports = append(ports, types.Port{
+					IP:          portBinding.HostIP,
+					PrivatePort: uint16(port.Int()),
+					PublicPort:  uint16(hostPort),
+					Type:        port.Proto(),
+				})
+			}
+		}
+		containers = append(containers, types.Container{
+			ID:    fakeContainer.id,
+			Names: []string{"/" + fakeContainer.name},
+			State: fakeContainer.status,
+			Ports: ports,
+		})
+	}
+	sort.Slice(containers, func(i, j int) bool {
+		return containers[i].ID < containers[j].ID
+	})
+	return containers, nil
+}
+
+func (engine *FakeEngine) GetContainerLogs(ctx context.Context, id string, options types.ContainerLogsOptions) (io.ReadCloser, error) {
+	engine.mu.Lock()
+	defer engine.mu.Unlock()
+	if err := engine.record("GetContainerLogs", id); err != nil {
+		return nil, err
+	}
+	fakeContainer := engine.lookup(id)
+	if fakeContainer == nil {
+		return nil, notFoundError(id)
+	}
+	logs := engine.Logs[fakeContainer.name]
+	var reader io.Reader = strings.NewReader(logs)
+	if fakeContainer.config == nil || !fakeContainer.config.Tty {
+		buffer := &bytes.Buffer{}
+		stdcopy.NewStdWriter(buffer, stdcopy.Stdout).Write([]byte(logs))
+		reader = buffer
+	}
+	if !options.Follow || !engine.FollowLogs[fakeContainer.name] {
+		return ioutil.NopCloser(reader), nil
+	}
+	pipeReader, pipeWriter := io.Pipe()
+	go func() {
+		io.Copy(pipeWriter, reader)
+		<-ctx.Done()
+		pipeWriter.CloseWithError(ctx.Err())
+	}()
+	return pipeReader, nil
+}
+
+func (engine *FakeEngine) ExecContainer(ctx context.Context, id string, cmd []string) (int, string, error) {
+	engine.mu.Lock()
+	defer engine.mu.Unlock()
+	if err := engine.record("ExecContainer", id); err != nil {
+		return 0, "", err
+	}
+	fakeContainer := engine.lookup(id)
+	if fakeContainer == nil {
+		return 0, "", notFoundError(id)
+	}
+	if fakeContainer.status != "running" {
+		return 0, "", errdefs.Conflict(fmt.Errorf("container %v is not running", id))
+	}
+	return engine.ExecExitCodes[fakeContainer.name], strings.Join(cmd, " "), nil
+}
+
+func (engine *FakeEngine) GetInfo(ctx context.Context) (types.Info, error) {
+	engine.mu.Lock()
+	defer engine.mu.Unlock()
+	if err := engine.record("GetInfo", ""); err != nil {
+		return types.Info{}, err
+	}
+	return engine.Info, nil
+}
+
+func (engine *FakeEngine) GetServerVersion(ctx context.Context) (types.Version, error) {
+	engine.mu.Lock()
+	defer engine.mu.Unlock()
+	if err := engine.record("GetServerVersion", ""); err != nil {
+		return types.Version{}, err
+	}
+	return engine.Version, nil
+}
+
+func (engine *FakeEngine) lookupNetwork(networkName string) (types.NetworkResource, bool) {
+	if networkResource, ok := engine.Networks[networkName]; ok {
+		return networkResource, true
+	}
+	for _, networkResource := range engine.Networks {
+		if networkResource.ID == networkName {
+			return networkResource, true
+		}
+	}
+	return types.NetworkResource{}, false
+}
+
+func (engine *FakeEngine) InspectNetwork(ctx context.Context, networkName string) (types.NetworkResource, error) {
+	engine.mu.Lock()
+	defer engine.mu.Unlock()
+	if err := engine.record("InspectNetwork", networkName); err != nil {
+		return types.NetworkResource{}, err
+	}
+	networkResource, ok := engine.lookupNetwork(networkName)
+	if !ok {
+		return types.NetworkResource{}, errdefs.NotFound(fmt.Errorf("network %v not found", networkName))
+	}
+	return networkResource, nil
+}
+
+func (engine *FakeEngine) CreateNetwork(ctx context.Context, networkName string, options types.NetworkCreate) (types.NetworkCreateResponse, error) {
+	engine.mu.Lock()
+	defer engine.mu.Unlock()
+	if err := engine.record("CreateNetwork", networkName); err != nil {
+		return types.NetworkCreateResponse{}, err
+	}
+	if _, ok := engine.Networks[networkName]; ok {
+		return types.NetworkCreateResponse{}, errdefs.Conflict(fmt.Errorf("network with name %v already exists", networkName))
+	}
+	driver := options.Driver
+	if driver == "" {
+		driver = "bridge"
+	}
+	id := fmt.Sprintf("fakenet%057d", len(engine.Networks))
+	engine.Networks[networkName] = types.NetworkResource{
+		Name:   networkName,
+		ID:     id,
+		Driver: driver,
+		IPAM:   ipamOrDefault(options.IPAM),
+		Labels: options.Labels,
+	}
+	return types.NetworkCreateResponse{ID: id}, nil
+}
+
+func ipamOrDefault(ipam *network.IPAM) network.IPAM {
+	if ipam == nil {
+		return network.IPAM{Driver: "default"}
+	}
+	return *ipam
+}
+
+func (engine *FakeEngine) ConnectNetwork(ctx context.Context, networkName string, id string, endpointSettings *network.EndpointSettings) error {
+	engine.mu.Lock()
+	defer engine.mu.Unlock()
+	if err := engine.record("ConnectNetwork", networkName); err != nil {
+		return err
+	}
+	fakeContainer := engine.lookup(id)
+	if fakeContainer == nil {
+		return notFoundError(id)
+	}
+	networkResource, ok := engine.lookupNetwork(networkName)
+	if !ok {
+		return errdefs.NotFound(fmt.Errorf("network %v not found", networkName))
+	}
+	endpointsConfig := map[string]*network.EndpointSettings{
+		"bridge": {},
+	}
+	if fakeContainer.networkingConfig != nil && len(fakeContainer.networkingConfig.EndpointsConfig) > 0 {
+		endpointsConfig = make(map[string]*network.EndpointSettings, len(fakeContainer.networkingConfig.EndpointsConfig)+1)
+		for name, settings := range fakeContainer.networkingConfig.EndpointsConfig {
+			endpointsConfig[name] = settings
+		}
+	}
+	if _, ok := endpointsConfig[networkResource.Name]; ok {
+		return errdefs.Forbidden(fmt.Errorf("container %v is already attached to network %v", id, networkResource.Name))
+	}
+	if endpointSettings == nil {
+		endpointSettings = &network.EndpointSettings{}
+	}
+	endpointsConfig[networkResource.Name] = endpointSettings
+	fakeContainer.networkingConfig = &network.NetworkingConfig{
+		EndpointsConfig: endpointsConfig,
+	}
+	return nil
+}
+
+func (engine *FakeEngine) InspectVolume(ctx context.Context, volumeName string) (types.Volume, error) {
+	engine.mu.Lock()
+	defer engine.mu.Unlock()
+	if err := engine.record("InspectVolume", volumeName); err != nil {
+		return types.Volume{}, err
+	}
+	fakeVolume, ok := engine.Volumes[volumeName]
+	if !ok {
+		return types.Volume{}, errdefs.NotFound(fmt.Errorf("no such volume: %v", volumeName))
+	}
+	return fakeVolume, nil
+}
+
+// CreateVolume 与 Docker 一致，卷已经存在时返回已有的卷
+func (engine *FakeEngine) CreateVolume(ctx context.Context, options volume.VolumeCreateBody) (types.Volume, error) {
+	engine.mu.Lock()
+	defer engine.mu.Unlock()
+	if err := engine.record("CreateVolume", options.Name); err != nil {
+		return types.Volume{}, err
+	}
+	if fakeVolume, ok := engine.Volumes[options.Name]; ok {
+		return fakeVolume, nil
+	}
+	driver := options.Driver
+	if driver == "" {
+		driver = "local"
+	}
+	fakeVolume := types.Volume{
+		Name:       options.Name,
+		Driver:     driver,
+		Options:    options.DriverOpts,
+		Labels:     options.Labels,
+		Mountpoint: "/var/lib/docker/volumes/" + options.Name + "/_data",
+		Scope:      "local",
+	}
+	engine.Volumes[options.Name] = fakeVolume
+	return fakeVolume, nil
+}
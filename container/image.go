@@ -27,14 +27,87 @@ import (
 	"io"
 	"io/ioutil"
 	"os"
+	"sync"
 
 	"github.com/MonteCarloClub/kether/log"
 	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/jsonmessage"
 )
 
-func CheckIfDockerImageAvailable(imageName string) bool {
-	// TODO 根据 Docker 镜像名检查对应的 Docker 镜像是否可用
-	return true
+type imageAvailability struct {
+	available bool
+	reason    string
+}
+
+var (
+	// imageAvailabilityCache 缓存本次运行中镜像可用性的检查结果
+	imageAvailabilityCache      = make(map[string]imageAvailability)
+	imageAvailabilityCacheMutex sync.Mutex
+)
+
+func getImageAvailabilityCacheKey(imageName string, localImage bool) string {
+	return fmt.Sprintf("%v|%v", imageName, localImage)
+}
+
+func ResetImageAvailabilityCache() {
+	imageAvailabilityCacheMutex.Lock()
+	defer imageAvailabilityCacheMutex.Unlock()
+	imageAvailabilityCache = make(map[string]imageAvailability)
+}
+
+// CheckIfDockerImageAvailable 检查 Docker 镜像是否可用，本地镜像缓存中的镜像总是可用，localImage 为 false 时
+// 再以 Docker CLI 保存的凭据检查镜像仓库中的 manifest，不可用时返回原因
+func CheckIfDockerImageAvailable(ctx context.Context, imageName string, localImage bool) (bool, string) {
+	key := getImageAvailabilityCacheKey(imageName, localImage)
+	imageAvailabilityCacheMutex.Lock()
+	result, ok := imageAvailabilityCache[key]
+	imageAvailabilityCacheMutex.Unlock()
+	if ok {
+		return result.available, result.reason
+	}
+
+	result = imageAvailability{
+		available: true,
+	}
+	// 本地镜像缓存中已有的镜像总是可用的，local_image 为 false 时再查询镜像仓库
+	_, err := DefaultEngine.InspectImage(ctx, imageName)
+	if err == nil {
+		log.Info("image found in local image cache", "imageName", imageName)
+	} else if localImage {
+		if client.IsErrNotFound(err) {
+			result = imageAvailability{false, "not found in local image cache"}
+		} else {
+			result = imageAvailability{false, fmt.Sprintf("fail to inspect local image: %v", err)}
+		}
+	} else {
+		registryAuth, err := GetEncodedRegistryAuth(imageName)
+		if err != nil {
+			result = imageAvailability{false, fmt.Sprintf("fail to get registry credentials: %v", err)}
+		} else if _, err = DefaultEngine.InspectDistribution(ctx, imageName, registryAuth); err != nil {
+			result = imageAvailability{false, fmt.Sprintf("fail to inspect distribution manifest: %v", err)}
+		}
+	}
+	// 上下文取消导致的失败不缓存
+	if ctx.Err() == nil {
+		imageAvailabilityCacheMutex.Lock()
+		imageAvailabilityCache[key] = result
+		imageAvailabilityCacheMutex.Unlock()
+	}
+	return result.available, result.reason
+}
+
+// InspectDockerImage 查询本地镜像缓存中的镜像详情，镜像不存在时返回 ok == false
+func InspectDockerImage(ctx context.Context, imageName string) (imageInspect types.ImageInspect, ok bool, err error) {
+	imageInspect, err = DefaultEngine.InspectImage(ctx, imageName)
+	if client.IsErrNotFound(err) {
+		return imageInspect, false, nil
+	}
+	if err != nil {
+		log.Error("fail to inspect image", "imageName", imageName, "err", err)
+		return imageInspect, false, err
+	}
+	return imageInspect, true, nil
 }
 
 func PullDockerImage(ctx context.Context, imageName string) error {
@@ -45,15 +118,25 @@ func PullDockerImage(ctx context.Context, imageName string) error {
 		return err
 	}
 
-	reader, err := DockerApiClient.ImagePull(ctx, imageName, types.ImagePullOptions{})
+	registryAuth, err := GetEncodedRegistryAuth(imageName)
+	if err != nil {
+		log.Error("fail to get registry credentials", "refStr", imageName, "err", err)
+		return err
+	}
+	reader, err := DefaultEngine.PullImage(ctx, imageName, registryAuth)
+	if err != nil {
+		log.Error("fail to pull docker image", "refStr", imageName, "err", err)
+		return err
+	}
+	defer reader.Close()
 	var dst io.Writer
 	if log.IfTraceOrDebug() {
 		dst = os.Stdout
 	} else {
 		dst = ioutil.Discard
 	}
-	defer reader.Close()
-	io.Copy(dst, reader)
+	// 拉取过程中的错误（如标签不存在）在进度流中返回
+	err = jsonmessage.DisplayJSONMessagesStream(reader, dst, 0, false, nil)
 	if err != nil {
 		log.Error("fail to pull docker image", "refStr", imageName, "err", err)
 		return err
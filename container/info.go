@@ -0,0 +1,129 @@
+/*
+Copyright (c) 2022 Zhang Zhanpeng <zhangregister@outlook.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package container
+
+import (
+	"context"
+	"sort"
+
+	"github.com/MonteCarloClub/kether/log"
+)
+
+// EngineInfo 是 Docker 引擎的版本和能力
+type EngineInfo struct {
+	Context          string          `json:"context" yaml:"context"`
+	Host             string          `json:"host,omitempty" yaml:"host,omitempty"`
+	ClientAPIVersion string          `json:"client_api_version,omitempty" yaml:"client_api_version,omitempty"`
+	ServerVersion    string          `json:"server_version" yaml:"server_version"`
+	APIVersion       string          `json:"api_version" yaml:"api_version"`
+	MinAPIVersion    string          `json:"min_api_version" yaml:"min_api_version"`
+	OperatingSystem  string          `json:"operating_system" yaml:"operating_system"`
+	OSType           string          `json:"os_type" yaml:"os_type"`
+	Architecture     string          `json:"architecture" yaml:"architecture"`
+	KernelVersion    string          `json:"kernel_version" yaml:"kernel_version"`
+	CPUs             int             `json:"cpus" yaml:"cpus"`
+	Memory           int64           `json:"memory" yaml:"memory"`
+	StorageDriver    string          `json:"storage_driver" yaml:"storage_driver"`
+	CgroupDriver     string          `json:"cgroup_driver" yaml:"cgroup_driver"`
+	CgroupVersion    string          `json:"cgroup_version" yaml:"cgroup_version"`
+	DefaultRuntime   string          `json:"default_runtime" yaml:"default_runtime"`
+	Runtimes         []string        `json:"runtimes" yaml:"runtimes"`
+	SecurityOptions  []string        `json:"security_options" yaml:"security_options"`
+	Experimental     bool            `json:"experimental" yaml:"experimental"`
+	Containers       int             `json:"containers" yaml:"containers"`
+	Images           int             `json:"images" yaml:"images"`
+	Capabilities     map[string]bool `json:"capabilities" yaml:"capabilities"`
+}
+
+// GetDockerEngineInfo 查询当前 Docker 上下文的引擎版本和能力，Kether 对象的资源限制等选项依赖这些能力
+func GetDockerEngineInfo(ctx context.Context) (*EngineInfo, error) {
+	version, err := DefaultEngine.GetServerVersion(ctx)
+	if err != nil {
+		log.Error("fail to get docker engine version", "err", err)
+		return nil, err
+	}
+	info, err := DefaultEngine.GetInfo(ctx)
+	if err != nil {
+		log.Error("fail to get docker engine info", "err", err)
+		return nil, err
+	}
+
+	engineInfo := &EngineInfo{
+		Context:         DockerContext,
+		ServerVersion:   version.Version,
+		APIVersion:      version.APIVersion,
+		MinAPIVersion:   version.MinAPIVersion,
+		OperatingSystem: info.OperatingSystem,
+		OSType:          info.OSType,
+		Architecture:    info.Architecture,
+		KernelVersion:   info.KernelVersion,
+		CPUs:            info.NCPU,
+		Memory:          info.MemTotal,
+		StorageDriver:   info.Driver,
+		CgroupDriver:    info.CgroupDriver,
+		CgroupVersion:   info.CgroupVersion,
+		DefaultRuntime:  info.DefaultRuntime,
+		Runtimes:        make([]string, 0, len(info.Runtimes)),
+		SecurityOptions: info.SecurityOptions,
+		Experimental:    info.ExperimentalBuild,
+		Containers:      info.Containers,
+		Images:          info.Images,
+		Capabilities: map[string]bool{
+			"memory_limit":       info.MemoryLimit,
+			"swap_limit":         info.SwapLimit,
+			"kernel_memory":      info.KernelMemory,
+			"cpu_cfs_period":     info.CPUCfsPeriod,
+			"cpu_cfs_quota":      info.CPUCfsQuota,
+			"cpu_shares":         info.CPUShares,
+			"cpuset":             info.CPUSet,
+			"pids_limit":         info.PidsLimit,
+			"oom_kill_disable":   info.OomKillDisable,
+			"ipv4_forwarding":    info.IPv4Forwarding,
+			"bridge_nf_iptables": info.BridgeNfIptables,
+		},
+	}
+	for runtime := range info.Runtimes {
+		engineInfo.Runtimes = append(engineInfo.Runtimes, runtime)
+	}
+	sort.Strings(engineInfo.Runtimes)
+	if DockerApiClient != nil {
+		// 协商后的 API 版本在第一次请求之后才确定
+		engineInfo.Host = DockerApiClient.DaemonHost()
+		engineInfo.ClientAPIVersion = DockerApiClient.ClientVersion()
+	}
+	return engineInfo, nil
+}
+
+// GetDockerHostName 返回 Docker 引擎所在主机的名称，用于在注册表中区分不同主机上的端口；无法查询时使用 Docker 上下文名称
+func GetDockerHostName(ctx context.Context) string {
+	info, err := DefaultEngine.GetInfo(ctx)
+	if err == nil && info.Name != "" {
+		return info.Name
+	}
+	if err != nil {
+		log.Warn("fail to get docker engine info, docker context used as host name", "context", DockerContext, "err", err)
+	}
+	if DockerContext != "" {
+		return DockerContext
+	}
+	return DefaultDockerContext
+}
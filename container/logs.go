@@ -0,0 +1,76 @@
+/*
+Copyright (c) 2022 Zhang Zhanpeng <zhangregister@outlook.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package container
+
+import (
+	"context"
+	"io"
+
+	"github.com/MonteCarloClub/kether/log"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/pkg/stdcopy"
+)
+
+// openDockerContainerLogs 打开容器日志流，tty 为 true 时日志流未经多路复用
+func openDockerContainerLogs(ctx context.Context, id string, options types.ContainerLogsOptions) (reader io.ReadCloser, tty bool, err error) {
+	containerJSON, err := DefaultEngine.InspectContainer(ctx, id)
+	if err != nil {
+		log.Error("fail to inspect container", "id", id, "err", err)
+		return nil, false, err
+	}
+	if containerJSON.Config != nil {
+		tty = containerJSON.Config.Tty
+	}
+
+	reader, err = DefaultEngine.GetContainerLogs(ctx, id, options)
+	if err != nil {
+		log.Error("fail to get container logs", "id", id, "err", err)
+		return nil, false, err
+	}
+	return reader, tty, nil
+}
+
+// copyDockerContainerLogs 把日志流中的标准输出和标准错误分别写到 stdout 和 stderr
+func copyDockerContainerLogs(reader io.Reader, tty bool, stdout io.Writer, stderr io.Writer) error {
+	var err error
+	if tty {
+		_, err = io.Copy(stdout, reader)
+	} else {
+		_, err = stdcopy.StdCopy(stdout, stderr, reader)
+	}
+	return err
+}
+
+func StreamDockerContainerLogs(ctx context.Context, id string, options types.ContainerLogsOptions, stdout io.Writer, stderr io.Writer) error {
+	reader, tty, err := openDockerContainerLogs(ctx, id, options)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	err = copyDockerContainerLogs(reader, tty, stdout, stderr)
+	if err != nil && ctx.Err() == nil {
+		log.Error("fail to stream container logs", "id", id, "err", err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+/*
+Copyright (c) 2022 Zhang Zhanpeng <zhangregister@outlook.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package container
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/MonteCarloClub/kether/log"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/stretchr/testify/assert"
+)
+
+func init() {
+	log.InitLogger()
+}
+
+func TestStreamDockerContainerLogs(t *testing.T) {
+	fakeEngine := NewFakeEngine()
+	fakeEngine.Logs["logs-test"] = "hello\n"
+	fakeEngine.Logs["logs-tty-test"] = "hello tty\n"
+	DefaultEngine = fakeEngine
+
+	ctx := context.Background()
+	_, err := fakeEngine.CreateContainer(ctx, &container.Config{}, nil, nil, "logs-test")
+	assert.Nil(t, err)
+	_, err = fakeEngine.CreateContainer(ctx, &container.Config{Tty: true}, nil, nil, "logs-tty-test")
+	assert.Nil(t, err)
+
+	for name, expectStdout := range map[string]string{
+		"logs-test":     "hello\n",
+		"logs-tty-test": "hello tty\n",
+	} {
+		stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+		err = StreamDockerContainerLogs(ctx, name, types.ContainerLogsOptions{ShowStdout: true, ShowStderr: true}, stdout, stderr)
+		assert.Nil(t, err)
+		assert.Equal(t, expectStdout, stdout.String())
+		assert.Empty(t, stderr.String())
+	}
+
+	err = StreamDockerContainerLogs(ctx, "not-exist", types.ContainerLogsOptions{}, &bytes.Buffer{}, &bytes.Buffer{})
+	assert.NotNil(t, err)
+}
+
+// TestRunDockerContainerWithFollowingLogs 检查容器退出后日志流不结束时，前台运行的容器不会一直等待日志
+func TestRunDockerContainerWithFollowingLogs(t *testing.T) {
+	fakeEngine := NewFakeEngine()
+	fakeEngine.Logs["restart-test"] = "hello\n"
+	fakeEngine.ExitCodes["restart-test"] = 3
+	fakeEngine.FollowLogs["restart-test"] = true
+	DefaultEngine = fakeEngine
+	defaultLogsDrainTimeout := logsDrainTimeout
+	logsDrainTimeout = 10 * time.Millisecond
+	defer func() {
+		logsDrainTimeout = defaultLogsDrainTimeout
+	}()
+
+	ctx := context.Background()
+	_, err := fakeEngine.CreateContainer(ctx, &container.Config{Tty: true}, nil, nil, "restart-test")
+	assert.Nil(t, err)
+	done := make(chan int64)
+	go func() {
+		statusCode, err := RunDockerContainer(ctx, "restart-test")
+		assert.Nil(t, err)
+		done <- statusCode
+	}()
+	select {
+	case statusCode := <-done:
+		assert.Equal(t, int64(3), statusCode)
+	case <-time.After(5 * time.Second):
+		t.Fatal("RunDockerContainer blocked on the log stream")
+	}
+}
@@ -0,0 +1,67 @@
+/*
+Copyright (c) 2022 Zhang Zhanpeng <zhangregister@outlook.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package container
+
+import (
+	"context"
+
+	"github.com/MonteCarloClub/kether/log"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/client"
+)
+
+// InspectDockerNetwork 按名称或 ID 查询网络详情，网络不存在时返回 ok == false
+func InspectDockerNetwork(ctx context.Context, networkName string) (networkResource types.NetworkResource, ok bool, err error) {
+	networkResource, err = DefaultEngine.InspectNetwork(ctx, networkName)
+	if client.IsErrNotFound(err) {
+		return networkResource, false, nil
+	}
+	if err != nil {
+		log.Error("fail to inspect network", "networkName", networkName, "err", err)
+		return networkResource, false, err
+	}
+	return networkResource, true, nil
+}
+
+func CreateDockerNetwork(ctx context.Context, networkName string, options types.NetworkCreate) (string, error) {
+	networkCreateResponse, err := DefaultEngine.CreateNetwork(ctx, networkName, options)
+	if err != nil {
+		log.Error("fail to create network", "networkName", networkName, "err", err)
+		return "", err
+	}
+	if networkCreateResponse.Warning != "" {
+		log.Warn("network created with warning", "networkName", networkName, "warning", networkCreateResponse.Warning)
+	}
+	log.Info("network created", "networkName", networkName, "id", networkCreateResponse.ID)
+	return networkCreateResponse.ID, nil
+}
+
+func ConnectDockerNetwork(ctx context.Context, networkName string, id string, endpointSettings *network.EndpointSettings) error {
+	err := DefaultEngine.ConnectNetwork(ctx, networkName, id, endpointSettings)
+	if err != nil {
+		log.Error("fail to connect container to network", "networkName", networkName, "id", id, "err", err)
+		return err
+	}
+	log.Info("container connected to network", "networkName", networkName, "id", id)
+	return nil
+}
@@ -0,0 +1,200 @@
+/*
+Copyright (c) 2022 Zhang Zhanpeng <zhangregister@outlook.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package container
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// getSSHArgs 把 ssh:// 形式的主机转换为在远程主机上运行 docker system dial-stdio 的 ssh 命令参数，其他主机返回 nil
+func getSSHArgs(host string) ([]string, error) {
+	if !strings.HasPrefix(host, "ssh://") {
+		return nil, nil
+	}
+	sshURL, err := url.Parse(host)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ssh host %v: %v", host, err)
+	}
+	if sshURL.Hostname() == "" {
+		return nil, fmt.Errorf("no host name in ssh host %v", host)
+	}
+	if sshURL.Path != "" && sshURL.Path != "/" {
+		return nil, fmt.Errorf("extra path %v in ssh host %v", sshURL.Path, host)
+	}
+
+	args := make([]string, 0)
+	if sshURL.User != nil {
+		if _, ok := sshURL.User.Password(); ok {
+			return nil, fmt.Errorf("plain-text password in ssh host %v is not supported, use ssh keys instead", host)
+		}
+		args = append(args, "-l", sshURL.User.Username())
+	}
+	if sshURL.Port() != "" {
+		args = append(args, "-p", sshURL.Port())
+	}
+	return append(args, "--", sshURL.Hostname(), "docker", "system", "dial-stdio"), nil
+}
+
+// getSSHDialContext 为 ssh:// 形式的主机返回通过本地 ssh 命令连接 Docker 引擎的拨号函数，其他主机返回 nil
+func getSSHDialContext(host string) (func(ctx context.Context, network, addr string) (net.Conn, error), error) {
+	args, err := getSSHArgs(host)
+	if args == nil || err != nil {
+		return nil, err
+	}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return newCommandConn("ssh", args...)
+	}, nil
+}
+
+const (
+	commandConnStderrTimeout = time.Second
+)
+
+// lockedBuffer 是并发安全的缓冲区，用于收集子进程的标准错误
+type lockedBuffer struct {
+	mu     sync.Mutex
+	buffer bytes.Buffer
+}
+
+func (buffer *lockedBuffer) Write(p []byte) (int, error) {
+	buffer.mu.Lock()
+	defer buffer.mu.Unlock()
+	return buffer.buffer.Write(p)
+}
+
+func (buffer *lockedBuffer) String() string {
+	buffer.mu.Lock()
+	defer buffer.mu.Unlock()
+	return buffer.buffer.String()
+}
+
+// commandConn 把子进程的标准输入输出包装为 net.Conn
+type commandConn struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout io.ReadCloser
+	stderr lockedBuffer
+	// stderrDone 在子进程的标准错误读取完毕后关闭
+	stderrDone chan struct{}
+
+	closeOnce sync.Once
+}
+
+func newCommandConn(name string, args ...string) (net.Conn, error) {
+	// 连接的生命周期长于拨号的 ctx，因此不使用 exec.CommandContext
+	conn := &commandConn{
+		cmd:        exec.Command(name, args...),
+		stderrDone: make(chan struct{}),
+	}
+	var err error
+	conn.stdin, err = conn.cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	conn.stdout, err = conn.cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	stderr, err := conn.cmd.StderrPipe()
+	if err != nil {
+		return nil, err
+	}
+	err = conn.cmd.Start()
+	if err != nil {
+		return nil, fmt.Errorf("fail to start %v: %v", name, err)
+	}
+	go func() {
+		defer close(conn.stderrDone)
+		io.Copy(&conn.stderr, stderr)
+	}()
+	return conn, nil
+}
+
+func (conn *commandConn) Read(p []byte) (int, error) {
+	n, err := conn.stdout.Read(p)
+	if err == io.EOF {
+		// 子进程异常退出时返回它的标准错误，而不是难以理解的 EOF
+		select {
+		case <-conn.stderrDone:
+		case <-time.After(commandConnStderrTimeout):
+		}
+		if stderr := strings.TrimSpace(conn.stderr.String()); stderr != "" {
+			return n, fmt.Errorf("connection closed by %v: %v", conn.cmd.Path, stderr)
+		}
+	}
+	return n, err
+}
+
+func (conn *commandConn) Write(p []byte) (int, error) {
+	return conn.stdin.Write(p)
+}
+
+func (conn *commandConn) Close() error {
+	conn.closeOnce.Do(func() {
+		conn.stdin.Close()
+		conn.stdout.Close()
+		if conn.cmd.Process != nil {
+			conn.cmd.Process.Kill()
+		}
+		conn.cmd.Wait()
+	})
+	return nil
+}
+
+type commandAddr string
+
+func (addr commandAddr) Network() string {
+	return "command"
+}
+
+func (addr commandAddr) String() string {
+	return string(addr)
+}
+
+func (conn *commandConn) LocalAddr() net.Addr {
+	return commandAddr("local")
+}
+
+func (conn *commandConn) RemoteAddr() net.Addr {
+	return commandAddr(conn.cmd.Path)
+}
+
+func (conn *commandConn) SetDeadline(t time.Time) error {
+	return nil
+}
+
+func (conn *commandConn) SetReadDeadline(t time.Time) error {
+	return nil
+}
+
+func (conn *commandConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
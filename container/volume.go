@@ -0,0 +1,60 @@
+/*
+Copyright (c) 2022 Zhang Zhanpeng <zhangregister@outlook.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package container
+
+import (
+	"context"
+	"strings"
+
+	"github.com/MonteCarloClub/kether/log"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/volume"
+	"github.com/docker/docker/client"
+)
+
+// InspectDockerVolume 按名称查询命名卷，卷不存在时返回 ok == false
+func InspectDockerVolume(ctx context.Context, volumeName string) (dockerVolume types.Volume, ok bool, err error) {
+	dockerVolume, err = DefaultEngine.InspectVolume(ctx, volumeName)
+	if client.IsErrNotFound(err) {
+		return dockerVolume, false, nil
+	}
+	if err != nil {
+		log.Error("fail to inspect volume", "volumeName", volumeName, "err", err)
+		return dockerVolume, false, err
+	}
+	return dockerVolume, true, nil
+}
+
+func CreateDockerVolume(ctx context.Context, options volume.VolumeCreateBody) error {
+	dockerVolume, err := DefaultEngine.CreateVolume(ctx, options)
+	if err != nil {
+		log.Error("fail to create volume", "volumeName", options.Name, "err", err)
+		return err
+	}
+	log.Info("volume created", "volumeName", dockerVolume.Name, "driver", dockerVolume.Driver)
+	return nil
+}
+
+// IsRemoteDockerEngine 判断 Docker 引擎是否通过 tcp:// 或 ssh:// 连接，远程引擎的绑定挂载源路径在远程主机上，无法在本地检查
+func IsRemoteDockerEngine() bool {
+	return strings.HasPrefix(dockerHost, "tcp://") || strings.HasPrefix(dockerHost, "ssh://")
+}
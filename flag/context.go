@@ -21,10 +21,20 @@ THE SOFTWARE.
 */
 package flag
 
+import (
+	"time"
+)
+
 type ContextKeyType string
 
 type ContextValType struct {
 	DryRun bool
+	// WaitLock 是等待其他持有者释放 Kether 对象租约锁的最长时间
+	WaitLock time.Duration
+	// PinDigest 为 true 时容器以镜像的仓库摘要创建，而不是可能移动的 tag
+	PinDigest bool
+	// RequireDigest 为 true 时拒绝部署没有以摘要固定的镜像
+	RequireDigest bool
 }
 
 const (
@@ -22,33 +22,98 @@ THE SOFTWARE.
 package machine
 
 import (
+	"bufio"
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"net"
+	"os"
+	"path/filepath"
 	"strconv"
-
-	"github.com/MonteCarloClub/kether/log"
+	"strings"
 )
 
-func CheckIfHostPortAvailable(hostPort string) bool {
-	hostPortInt, err := strconv.Atoi(hostPort)
-	if err != nil || hostPortInt < 1024 || hostPortInt > 49151 {
-		return false
+// IsHostPortAvailable 检查本机的端口是否可以按协议监听；无法探测的协议和没有权限监听的特权端口视为可用，由 Docker 报告冲突
+func IsHostPortAvailable(port int, protocol string) bool {
+	address := fmt.Sprintf(":%v", port)
+	switch protocol {
+	case "", "tcp":
+		ln, err := net.Listen("tcp", address)
+		if err != nil {
+			return isPermissionError(err)
+		}
+		return ln.Close() == nil
+	case "udp":
+		conn, err := net.ListenPacket("udp", address)
+		if err != nil {
+			return isPermissionError(err)
+		}
+		return conn.Close() == nil
+	default:
+		return true
 	}
-	ln, err := net.Listen("tcp", fmt.Sprintf(":%v", hostPort))
-	if err != nil {
-		return false
+}
+
+func isPermissionError(err error) bool {
+	return errors.Is(err, os.ErrPermission)
+}
+
+// GetHostPortProcess 在 /proc 中查找监听本机端口的进程，返回形如 nginx (pid 42) 的描述；
+// 非 Linux 主机或没有权限读取其他用户的进程时返回 false
+func GetHostPortProcess(port int, protocol string) (string, bool) {
+	inodes := make(map[string]bool)
+	for _, suffix := range []string{"", "6"} {
+		for _, inode := range getSocketInodes(fmt.Sprintf("/proc/net/%v%v", protocol, suffix), port, protocol) {
+			inodes[inode] = true
+		}
 	}
-	err = ln.Close()
-	return err == nil
+	if len(inodes) == 0 {
+		return "", false
+	}
+	fdPaths, _ := filepath.Glob("/proc/[0-9]*/fd/*")
+	for _, fdPath := range fdPaths {
+		link, err := os.Readlink(fdPath)
+		if err != nil || !strings.HasPrefix(link, "socket:[") {
+			continue
+		}
+		if !inodes[strings.TrimSuffix(strings.TrimPrefix(link, "socket:["), "]")] {
+			continue
+		}
+		pidDir := filepath.Dir(filepath.Dir(fdPath))
+		comm, err := ioutil.ReadFile(filepath.Join(pidDir, "comm"))
+		if err != nil {
+			return fmt.Sprintf("pid %v", filepath.Base(pidDir)), true
+		}
+		return fmt.Sprintf("%v (pid %v)", strings.TrimSpace(string(comm)), filepath.Base(pidDir)), true
+	}
+	return "", false
 }
 
-func GetAvailableHostPort() string {
-	for i := 0; i < 1000; i++ {
-		candidateHostPort := strconv.Itoa(8000 + i)
-		if CheckIfHostPortAvailable(candidateHostPort) {
-			return candidateHostPort
+// getSocketInodes 读取 /proc/net/{tcp,udp}[6]，返回绑定了端口的套接字的 inode，TCP 只考虑处于 LISTEN 状态的套接字
+func getSocketInodes(path string, port int, protocol string) []string {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+	inodes := make([]string, 0)
+	scanner := bufio.NewScanner(file)
+	// 跳过表头
+	scanner.Scan()
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 10 {
+			continue
+		}
+		localAddress := strings.Split(fields[1], ":")
+		localPort, err := strconv.ParseInt(localAddress[len(localAddress)-1], 16, 32)
+		if err != nil || int(localPort) != port {
+			continue
+		}
+		if protocol == "tcp" && fields[3] != "0A" {
+			continue
 		}
+		inodes = append(inodes, fields[9])
 	}
-	log.Warn("fail to get available host port in [8000, 9000)")
-	return ""
+	return inodes
 }
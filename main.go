@@ -23,8 +23,10 @@ package main
 
 import (
 	"github.com/MonteCarloClub/kether/cmd"
+	"github.com/MonteCarloClub/kether/log"
 )
 
 func main() {
+	log.InitLogger()
 	cmd.Execute()
 }
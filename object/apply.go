@@ -0,0 +1,257 @@
+/*
+Copyright (c) 2022 Zhang Zhanpeng <zhangregister@outlook.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package object
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/MonteCarloClub/kether/container"
+	"github.com/MonteCarloClub/kether/flag"
+	"github.com/MonteCarloClub/kether/log"
+	"github.com/MonteCarloClub/kether/registry"
+	"github.com/docker/docker/api/types"
+)
+
+const (
+	// ConfigHashLabel 是容器上记录期望配置哈希的标签
+	ConfigHashLabel = "kether.config-hash"
+)
+
+// GetConfigHash 返回期望配置的哈希，由镜像名、运行需求、解析后的环境变量和健康检查计算，任何一项变化都会使哈希变化；
+// 环境变量中引用的其他对象的端口按注册表中记录的实际端口解析，依赖的对象端口变化时哈希同样变化
+func (ketherObject *KetherObject) GetConfigHash(ctx context.Context) (string, error) {
+	imageName, err := ketherObject.GetImageName(ctx)
+	if err != nil {
+		return "", err
+	}
+	env, err := ketherObject.getEnv()
+	if err != nil {
+		return "", err
+	}
+	env, err = resolvePeerPorts(ctx, env, true)
+	if err != nil {
+		return "", err
+	}
+	configBytes, err := json.Marshal(struct {
+		Image       string
+		Requirement *RunDescription
+		Env         []string
+		Healthcheck *Healthcheck
+	}{imageName, ketherObject.Requirement, env, ketherObject.Healthcheck})
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(configBytes)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// getApplyAction 判断使容器收敛到期望配置需要的动作：容器不存在时创建；对象已部署、注册表和容器标签中的配置哈希都与期望一致、
+// 且后台运行的容器仍在运行时不做改动；否则重建
+func getApplyAction(ketherObject *KetherObject, state KetherObjectStateType, deployment registry.Deployment, recorded bool, containerJSON types.ContainerJSON, exists bool, configHash string) string {
+	if !exists {
+		return PlanActionCreate
+	}
+	if state != DEPLOYED || !recorded || deployment.ConfigHash != configHash {
+		return PlanActionRecreate
+	}
+	if containerJSON.Config == nil || containerJSON.Config.Labels[ConfigHashLabel] != configHash {
+		return PlanActionRecreate
+	}
+	if ketherObject.Requirement.Detach && (containerJSON.State == nil || !containerJSON.State.Running) {
+		return PlanActionRecreate
+	}
+	return PlanActionNoop
+}
+
+// Apply 使 Kether 对象的容器收敛到期望配置，返回执行的动作：create、recreate 或 no-op。
+// 已部署的对象经由 UPDATING 状态更新，其他对象重新注册后部署
+func Apply(ctx context.Context, ketherObject *KetherObject, ketherObjectState *KetherObjectState) (string, error) {
+	dryRun := ctx.Value(flag.ContextKey).(flag.ContextValType).DryRun
+	release, err := ketherObjectState.lock(ctx)
+	if err != nil {
+		log.Error("fail to lock kether object", "name", ketherObject.Name, "err", err)
+		return "", err
+	}
+	defer release()
+
+	configHash, err := ketherObject.GetConfigHash(ctx)
+	if err != nil {
+		log.Error("fail to get config hash of kether object", "name", ketherObject.Name, "err", err)
+		return "", err
+	}
+	state, _, err := getStateOfName(ctx, ketherObject.Name)
+	if err != nil {
+		log.Error("fail to get state of kether object", "name", ketherObject.Name, "err", err)
+		return "", err
+	}
+	deployment, recorded, err := registry.GetDeploymentOfName(ctx, ketherObject.Name)
+	if err != nil {
+		return "", err
+	}
+	containerJSON, exists, err := ketherObject.inspectContainer(ctx, deployment, recorded)
+	if err != nil {
+		return "", err
+	}
+	if exists && !dryRun {
+		containerJSON, err = ketherObject.renameTemporaryContainer(ctx, containerJSON)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	action := getApplyAction(ketherObject, state, deployment, recorded, containerJSON, exists, configHash)
+	if action == PlanActionNoop {
+		log.Info("kether object unchanged", "name", ketherObject.Name, "configHash", configHash)
+		return action, nil
+	}
+	if dryRun {
+		log.Info("applying kether object in dry run mode will not change any state", "name", ketherObject.Name, "action", action)
+		return action, nil
+	}
+	log.Info("kether object will be applied", "name", ketherObject.Name, "action", action, "configHash", configHash, "previousConfigHash", deployment.ConfigHash)
+
+	if state == DEPLOYED {
+		err = ketherObjectState.SetState(ctx, UPDATING, configHash)
+	} else {
+		err = ketherObjectState.SetState(ctx, REGISTERING, "")
+		if err == nil {
+			err = ketherObjectState.SetState(ctx, REGISTERED, "")
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if action == PlanActionCreate {
+		id, err := deploy(ctx, ketherObject, ketherObjectState, ketherObject.GetContainerName())
+		if err != nil {
+			return "", err
+		}
+		return action, setDeployed(ctx, ketherObject, ketherObjectState, id)
+	}
+	return action, replace(ctx, ketherObject, ketherObjectState, containerJSON, configHash)
+}
+
+// replace 以新容器替换同名的旧容器：停止旧容器以释放端口，以临时名称创建并启动新容器，新容器就绪后删除旧容器，
+// 再把新容器重命名为对象名称，重命名失败时保留临时名称，由下一次 apply 重试；新容器失败时删除新容器，并重新启动原来在运行的旧容器
+func replace(ctx context.Context, ketherObject *KetherObject, ketherObjectState *KetherObjectState, oldContainerJSON types.ContainerJSON, configHash string) error {
+	containerName := ketherObject.GetContainerName()
+	oldId := oldContainerJSON.ID
+	wasRunning := oldContainerJSON.State != nil && oldContainerJSON.State.Running
+
+	_, err := container.StopDockerContainer(ctx, oldId)
+	if err != nil {
+		ketherObjectState.SetState(ctx, FAIL_TO_DEPLOY, fmt.Sprintf("fail to stop old docker container: %v", err))
+		return err
+	}
+
+	newName := fmt.Sprintf("%v-%v", containerName, configHash[:12])
+	// 清理上一次被中断的替换留下的容器
+	if _, ok, _ := container.InspectDockerContainer(ctx, newName); ok {
+		log.Warn("container left by an interrupted replacement found", "containerName", newName)
+		container.StopDockerContainer(ctx, newName)
+		container.RemoveDockerContainer(ctx, newName, false)
+	}
+
+	id, err := deploy(ctx, ketherObject, ketherObjectState, newName)
+	if err != nil {
+		if id != "" {
+			container.StopDockerContainer(ctx, id)
+			container.RemoveDockerContainer(ctx, id, false)
+		}
+		if wasRunning {
+			startErr := container.RunDockerContainerInBackground(ctx, oldId)
+			if startErr != nil {
+				log.Error("fail to restart old docker container", "id", oldId, "err", startErr)
+			} else {
+				log.Info("old docker container restarted", "id", oldId)
+			}
+		}
+		return err
+	}
+
+	err = container.RemoveDockerContainer(ctx, oldId, false)
+	if err != nil {
+		ketherObjectState.SetState(ctx, FAIL_TO_DEPLOY, fmt.Sprintf("fail to remove old docker container: %v", err))
+		return err
+	}
+	err = container.RenameDockerContainer(ctx, id, containerName)
+	if err != nil {
+		// 旧容器已经删除，临时名称的新容器作为对象的容器记录在部署记录中，下一次 apply 时再重命名
+		log.Warn("fail to rename docker container, it will be renamed on the next apply", "name", ketherObject.Name, "id", id, "containerName", newName, "err", err)
+	} else {
+		log.Info("docker container replaced", "name", ketherObject.Name, "oldId", oldId, "id", id)
+	}
+	return setDeployed(ctx, ketherObject, ketherObjectState, id)
+}
+
+// inspectContainer 查询对象的容器，对象名称的容器不存在时，按部署记录中的容器 ID 查找替换时重命名失败、仍使用临时名称的容器
+func (ketherObject *KetherObject) inspectContainer(ctx context.Context, deployment registry.Deployment, recorded bool) (types.ContainerJSON, bool, error) {
+	containerJSON, ok, err := container.InspectDockerContainer(ctx, ketherObject.GetContainerName())
+	if err != nil || ok || !recorded || deployment.ContainerID == "" {
+		return containerJSON, ok, err
+	}
+	return container.InspectDockerContainer(ctx, deployment.ContainerID)
+}
+
+// renameTemporaryContainer 把仍使用临时名称的容器重命名为对象名称
+func (ketherObject *KetherObject) renameTemporaryContainer(ctx context.Context, containerJSON types.ContainerJSON) (types.ContainerJSON, error) {
+	containerName := ketherObject.GetContainerName()
+	if strings.TrimPrefix(containerJSON.Name, "/") == containerName {
+		return containerJSON, nil
+	}
+	log.Warn("container with temporary name found", "name", ketherObject.Name, "containerName", containerJSON.Name)
+	err := container.RenameDockerContainer(ctx, containerJSON.ID, containerName)
+	if err != nil {
+		return containerJSON, err
+	}
+	containerJSON.Name = "/" + containerName
+	return containerJSON, nil
+}
+
+// ApplyStack 按依赖关系的拓扑顺序使栈中的对象收敛到期望配置，互不依赖的对象并行处理，返回每个成功的对象执行的动作；
+// 依赖的对象失败时，对象保持原状。调用方需持有栈中所有对象的锁
+func ApplyStack(ctx context.Context, stack *Stack) (map[string]string, error) {
+	var actionsMutex sync.Mutex
+	actions := make(map[string]string, len(stack.KetherObjects))
+	errs := stack.runInDependencyOrder(false, func(ketherObject *KetherObject, ketherObjectState *KetherObjectState) error {
+		action, err := Apply(ctx, ketherObject, ketherObjectState)
+		if err != nil {
+			return err
+		}
+		actionsMutex.Lock()
+		actions[ketherObject.Name] = action
+		actionsMutex.Unlock()
+		return nil
+	}, func(ketherObject *KetherObject, ketherObjectState *KetherObjectState, err error) error {
+		err = fmt.Errorf("dependency %v", err)
+		log.Error("skip applying kether object", "name", ketherObject.Name, "err", err)
+		return err
+	})
+	return actions, stack.getStackError(errs)
+}
@@ -0,0 +1,208 @@
+/*
+Copyright (c) 2022 Zhang Zhanpeng <zhangregister@outlook.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package object
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/MonteCarloClub/kether/container"
+	"github.com/MonteCarloClub/kether/registry"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApply(t *testing.T) {
+	fakeEngine, ctx := newTestEnv(t)
+
+	ketherObject, ketherObjectState := getTestKetherObject(true, true)
+	ketherObject.Requirement.Env = []string{"NETWORK_ID=1337"}
+	action, err := Apply(ctx, ketherObject, ketherObjectState)
+	assert.Nil(t, err)
+	assert.Equal(t, PlanActionCreate, action)
+	status, containerConfig, _, ok := fakeEngine.GetContainer(ketherObject.GetContainerName())
+	assert.True(t, ok)
+	assert.Equal(t, "running", status)
+	configHash, err := ketherObject.GetConfigHash(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, configHash, containerConfig.Labels[ConfigHashLabel])
+	deployment, ok, err := registry.GetDeploymentOfName(ctx, ketherObject.Name)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, configHash, deployment.ConfigHash)
+	assert.Equal(t, testImageName, deployment.Image)
+
+	calls := len(fakeEngine.Calls())
+	action, err = Apply(ctx, ketherObject, ketherObjectState)
+	assert.Nil(t, err)
+	assert.Equal(t, PlanActionNoop, action)
+	assert.Equal(t, []string{"InspectContainer"}, fakeEngine.Calls()[calls:])
+
+	// 新容器失败时旧容器被重新启动
+	ketherObject.Requirement.Env = []string{"NETWORK_ID=1"}
+	newConfigHash, err := ketherObject.GetConfigHash(ctx)
+	assert.Nil(t, err)
+	newName := fmt.Sprintf("%v-%v", ketherObject.GetContainerName(), newConfigHash[:12])
+	fakeEngine.Errors["CreateContainer "+newName] = fmt.Errorf("no space left on device")
+	_, err = Apply(ctx, ketherObject, ketherObjectState)
+	assert.NotNil(t, err)
+	status, containerConfig, _, ok = fakeEngine.GetContainer(ketherObject.GetContainerName())
+	assert.True(t, ok)
+	assert.Equal(t, "running", status)
+	assert.Equal(t, []string{"NETWORK_ID=1337"}, containerConfig.Env)
+	_, _, _, ok = fakeEngine.GetContainer(newName)
+	assert.False(t, ok)
+	state, _, err := getStateOfName(ctx, ketherObject.Name)
+	assert.Nil(t, err)
+	assert.Equal(t, FAIL_TO_DEPLOY, state)
+
+	delete(fakeEngine.Errors, "CreateContainer "+newName)
+	calls = len(fakeEngine.Calls())
+	action, err = Apply(ctx, ketherObject, ketherObjectState)
+	assert.Nil(t, err)
+	assert.Equal(t, PlanActionRecreate, action)
+	assert.Equal(t, []string{"StopContainer", "CreateContainer", "StartContainer", "RemoveContainer", "RenameContainer"},
+		filterCalls(fakeEngine.Calls()[calls:], "StopContainer", "CreateContainer", "StartContainer", "RemoveContainer", "RenameContainer"))
+	status, containerConfig, _, ok = fakeEngine.GetContainer(ketherObject.GetContainerName())
+	assert.True(t, ok)
+	assert.Equal(t, "running", status)
+	assert.Equal(t, []string{"NETWORK_ID=1"}, containerConfig.Env)
+	state, _, err = getStateOfName(ctx, ketherObject.Name)
+	assert.Nil(t, err)
+	assert.Equal(t, DEPLOYED, state)
+
+	action, err = Apply(ctx, ketherObject, ketherObjectState)
+	assert.Nil(t, err)
+	assert.Equal(t, PlanActionNoop, action)
+
+	// 已部署的对象经由 UPDATING 重建
+	ketherObject.Requirement.Env = []string{"NETWORK_ID=2"}
+	action, err = Apply(ctx, ketherObject, ketherObjectState)
+	assert.Nil(t, err)
+	assert.Equal(t, PlanActionRecreate, action)
+	transitions, err := GetHistory(ctx, ketherObject.Name)
+	assert.Nil(t, err)
+	states := make([]string, 0)
+	for _, transition := range transitions[len(transitions)-5:] {
+		states = append(states, transition.To)
+	}
+	assert.Equal(t, []string{"DEPLOYED", "UPDATING", "CREATING", "STARTING", "DEPLOYED"}, states)
+}
+
+func TestApplyRenameFailure(t *testing.T) {
+	fakeEngine, ctx := newTestEnv(t)
+
+	ketherObject, ketherObjectState := getTestKetherObject(true, true)
+	ketherObject.Requirement.Env = []string{"NETWORK_ID=1337"}
+	_, err := Apply(ctx, ketherObject, ketherObjectState)
+	assert.Nil(t, err)
+
+	// 重命名失败时临时名称的新容器作为对象的容器
+	ketherObject.Requirement.Env = []string{"NETWORK_ID=1"}
+	configHash, err := ketherObject.GetConfigHash(ctx)
+	assert.Nil(t, err)
+	newName := fmt.Sprintf("%v-%v", ketherObject.GetContainerName(), configHash[:12])
+	fakeEngine.Errors["RenameContainer"] = fmt.Errorf("rename failed")
+	action, err := Apply(ctx, ketherObject, ketherObjectState)
+	assert.Nil(t, err)
+	assert.Equal(t, PlanActionRecreate, action)
+	_, _, _, ok := fakeEngine.GetContainer(ketherObject.GetContainerName())
+	assert.False(t, ok)
+	status, containerConfig, _, ok := fakeEngine.GetContainer(newName)
+	assert.True(t, ok)
+	assert.Equal(t, "running", status)
+	assert.Equal(t, []string{"NETWORK_ID=1"}, containerConfig.Env)
+	deployment, ok, err := registry.GetDeploymentOfName(ctx, ketherObject.Name)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	containerJSON, ok, err := container.InspectDockerContainer(ctx, newName)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, containerJSON.ID, deployment.ContainerID)
+	state, _, err := getStateOfName(ctx, ketherObject.Name)
+	assert.Nil(t, err)
+	assert.Equal(t, DEPLOYED, state)
+
+	// 重命名仍然失败时 apply 失败，容器保持运行
+	_, err = Apply(ctx, ketherObject, ketherObjectState)
+	assert.NotNil(t, err)
+	status, _, _, ok = fakeEngine.GetContainer(newName)
+	assert.True(t, ok)
+	assert.Equal(t, "running", status)
+
+	// 下一次 apply 重试重命名，容器不再重建
+	delete(fakeEngine.Errors, "RenameContainer")
+	calls := len(fakeEngine.Calls())
+	action, err = Apply(ctx, ketherObject, ketherObjectState)
+	assert.Nil(t, err)
+	assert.Equal(t, PlanActionNoop, action)
+	assert.Equal(t, []string{"InspectContainer", "InspectContainer", "RenameContainer"}, fakeEngine.Calls()[calls:])
+	status, _, _, ok = fakeEngine.GetContainer(ketherObject.GetContainerName())
+	assert.True(t, ok)
+	assert.Equal(t, "running", status)
+	_, _, _, ok = fakeEngine.GetContainer(newName)
+	assert.False(t, ok)
+}
+
+func TestDeployTwice(t *testing.T) {
+	fakeEngine, ctx := newTestEnv(t)
+	yamlPath := writeTestYaml(t, `name: deploy-test
+predicate:
+  repository: `+testRepository+`
+priority:
+  tag: 1.1.0-alpha.6
+requirement:
+  local_image: true
+  detach: true
+`)
+
+	ketherObject, ketherObjectState, err := Register(ctx, yamlPath)
+	assert.Nil(t, err)
+	assert.Nil(t, Deploy(ctx, ketherObject, ketherObjectState))
+	ketherObjectState.Unlock(ctx)
+
+	// 再次部署已部署的对象时给出 apply 和 undeploy 的提示，容器和状态不变
+	calls := len(fakeEngine.Calls())
+	_, _, err = Register(ctx, yamlPath)
+	assert.EqualError(t, err, "kether object deploy-test is already deployed, use kether apply to update it or kether undeploy to remove it first")
+	assert.Empty(t, fakeEngine.Calls()[calls:])
+	state, _, err := getStateOfName(ctx, "deploy-test")
+	assert.Nil(t, err)
+	assert.Equal(t, DEPLOYED, state)
+
+	// 锁已经释放，apply 可以继续处理该对象
+	action, err := Apply(ctx, ketherObject, ketherObjectState)
+	assert.Nil(t, err)
+	assert.Equal(t, PlanActionNoop, action)
+}
+
+// filterCalls 返回方法名属于 methods 的调用
+func filterCalls(calls []string, methods ...string) []string {
+	filtered := make([]string, 0)
+	for _, call := range calls {
+		for _, method := range methods {
+			if call == method {
+				filtered = append(filtered, call)
+			}
+		}
+	}
+	return filtered
+}
@@ -24,20 +24,62 @@ package object
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/MonteCarloClub/kether/container"
 	"github.com/MonteCarloClub/kether/flag"
 	"github.com/MonteCarloClub/kether/log"
+	"github.com/MonteCarloClub/kether/registry"
 )
 
 func Deploy(ctx context.Context, ketherObject *KetherObject, ketherObjectState *KetherObjectState) error {
-	var err error
-	imageName := ketherObject.GetImageName()
-	containerConfig, hostConfig := ketherObject.GetContainerAndHostConfig()
+	dryRun := ctx.Value(flag.ContextKey).(flag.ContextValType).DryRun
+	release, err := ketherObjectState.lock(ctx)
+	if err != nil {
+		log.Error("fail to lock kether object", "name", ketherObject.Name, "err", err)
+		return err
+	}
+	defer release()
+
+	id, err := deploy(ctx, ketherObject, ketherObjectState, ketherObject.GetContainerName())
+	if err != nil || dryRun {
+		return err
+	}
+	return setDeployed(ctx, ketherObject, ketherObjectState, id)
+}
+
+// deploy 拉取镜像，以 containerName 创建并运行容器，等待后台运行的容器就绪，返回容器 ID；
+// 失败时对象被标记为部署失败，已经创建的容器的 ID 仍然返回，以便调用方清理
+func deploy(ctx context.Context, ketherObject *KetherObject, ketherObjectState *KetherObjectState, containerName string) (string, error) {
+	contextVal := ctx.Value(flag.ContextKey).(flag.ContextValType)
+	dryRun, pinDigest, requireDigest := contextVal.DryRun, contextVal.PinDigest, contextVal.RequireDigest
+	imageName, err := ketherObject.GetImageName(ctx)
+	if err != nil {
+		log.Error("fail to get image name", "name", ketherObject.Name, "err", err)
+		if !dryRun {
+			ketherObjectState.SetState(ctx, FAIL_TO_DEPLOY, fmt.Sprintf("fail to get image name: %v", err))
+		}
+		return "", err
+	}
+	if requireDigest && !isPinnedByDigest(imageName) {
+		err = fmt.Errorf("image %v is not pinned by digest", imageName)
+		log.Error("image refused by digest policy", "name", ketherObject.Name, "err", err)
+		if !dryRun {
+			ketherObjectState.SetState(ctx, FAIL_TO_DEPLOY, err.Error())
+		}
+		return "", err
+	}
+	containerConfig, hostConfig, err := ketherObject.GetContainerAndHostConfig(ctx)
+	if err != nil {
+		log.Error("fail to get container and host config", "name", ketherObject.Name, "err", err)
+		if !dryRun {
+			ketherObjectState.SetState(ctx, FAIL_TO_DEPLOY, fmt.Sprintf("fail to get container and host config: %v", err))
+		}
+		return "", err
+	}
 	networkingConfig := ketherObject.GetNetworkingConfig()
-	containerName := ketherObject.GetContainerName()
 
-	if ctx.Value(flag.ContextKey).(flag.ContextValType).DryRun {
+	if dryRun {
 		log.Info("image name gotten", "imageName", imageName)
 		if containerConfig != nil {
 			log.Info("container config gotten", "containerConfig", containerConfig)
@@ -52,50 +94,143 @@ func Deploy(ctx context.Context, ketherObject *KetherObject, ketherObjectState *
 			log.Info("container name gotten", "containerName", containerName)
 		}
 		log.Info("deploying kether object in dry run mode will not change any state")
-		return nil
+		return "", nil
 	}
 
 	if !ketherObject.Requirement.LocalImage {
+		err = ketherObjectState.SetState(ctx, PULLING, imageName)
+		if err != nil {
+			return "", err
+		}
 		log.Info("docker image will be pulled from remote repository", "imageName", imageName)
 		err = container.PullDockerImage(ctx, imageName)
 		if err != nil {
-			log.Error("fail to pull docker image", "imageName", imageName, "err", err)
-			ketherObjectState.SetState(ctx, FAIL_TO_DEPLOY)
-			return err
+			// 镜像仓库不可用时使用本地镜像缓存中的镜像，与选择镜像时的检查一致
+			_, ok, inspectErr := container.InspectDockerImage(ctx, imageName)
+			if inspectErr != nil || !ok {
+				log.Error("fail to pull docker image", "imageName", imageName, "err", err)
+				ketherObjectState.SetState(ctx, FAIL_TO_DEPLOY, fmt.Sprintf("fail to pull docker image: %v", err))
+				return "", err
+			}
+			log.Warn("fail to pull docker image, local image cache used", "imageName", imageName, "err", err)
+		} else {
+			log.Info("docker image pulled")
+		}
+	}
+
+	ketherObject.imageDigest, ketherObject.imageId, err = getImageDigest(ctx, imageName)
+	if err != nil {
+		log.Error("fail to resolve image digest", "imageName", imageName, "err", err)
+		ketherObjectState.SetState(ctx, FAIL_TO_DEPLOY, fmt.Sprintf("fail to resolve image digest: %v", err))
+		return "", err
+	}
+	log.Info("image digest resolved", "imageName", imageName, "imageDigest", ketherObject.imageDigest, "imageId", ketherObject.imageId)
+	if pinDigest {
+		if ketherObject.imageDigest != "" {
+			containerConfig.Image = ketherObject.imageDigest
+			containerConfig.Labels[ImageLabel] = imageName
+		} else {
+			log.Warn("image without repo digest cannot be pinned", "imageName", imageName)
 		}
-		log.Info("docker image pulled")
 	}
 
-	id, err := container.CreateDockerContainer(ctx, containerConfig, hostConfig, networkingConfig, ketherObject.Name)
+	err = ketherObject.ensureMounts(ctx)
+	if err != nil {
+		log.Error("fail to ensure mounts", "name", ketherObject.Name, "err", err)
+		ketherObjectState.SetState(ctx, FAIL_TO_DEPLOY, fmt.Sprintf("fail to ensure mounts: %v", err))
+		return "", err
+	}
+	err = ketherObject.ensureNetworks(ctx)
+	if err != nil {
+		log.Error("fail to ensure networks", "name", ketherObject.Name, "err", err)
+		ketherObjectState.SetState(ctx, FAIL_TO_DEPLOY, fmt.Sprintf("fail to ensure networks: %v", err))
+		return "", err
+	}
+
+	err = ketherObjectState.SetState(ctx, CREATING, containerName)
+	if err != nil {
+		return "", err
+	}
+	id, err := container.CreateDockerContainer(ctx, containerConfig, hostConfig, networkingConfig, containerName)
+	if err != nil {
+		log.Error("fail to create docker container", "id", id, "err", err)
+		ketherObjectState.SetState(ctx, FAIL_TO_DEPLOY, fmt.Sprintf("fail to create docker container: %v", err))
+		return "", err
+	}
 	if id == "" {
 		err = fmt.Errorf("empty container id")
 		log.Error("fail to create docker container, empty id", "err", err)
-		ketherObjectState.SetState(ctx, FAIL_TO_DEPLOY)
-		return err
+		ketherObjectState.SetState(ctx, FAIL_TO_DEPLOY, fmt.Sprintf("fail to create docker container: %v", err))
+		return "", err
 	}
+	log.Info("container created")
+	err = ketherObject.connectNetworks(ctx, id)
 	if err != nil {
-		log.Error("fail to create docker container", "id", id, "err", err)
-		ketherObjectState.SetState(ctx, FAIL_TO_DEPLOY)
-		return err
+		log.Error("fail to connect docker container to networks", "id", id, "err", err)
+		ketherObjectState.SetState(ctx, FAIL_TO_DEPLOY, fmt.Sprintf("fail to connect docker container to networks: %v", err))
+		return id, err
 	}
-	log.Info("container created")
 
+	err = ketherObjectState.SetState(ctx, STARTING, id)
+	if err != nil {
+		return id, err
+	}
 	if ketherObject.Requirement.Detach {
 		err = container.RunDockerContainerInBackground(ctx, id)
+		if err == nil {
+			err = ketherObject.WaitForReadiness(ctx, id)
+		}
 	} else {
-		err = container.RunDockerContainer(ctx, id)
+		var statusCode int64
+		statusCode, err = container.RunDockerContainer(ctx, id)
+		if err == nil && statusCode != 0 {
+			err = fmt.Errorf("container exited with status code %v", statusCode)
+		}
 	}
 	if err != nil {
 		log.Error("fail to run docker container in {foreground|background}", "err", err)
-		ketherObjectState.SetState(ctx, FAIL_TO_DEPLOY)
-		return err
+		ketherObjectState.SetState(ctx, FAIL_TO_DEPLOY, fmt.Sprintf("fail to run docker container: %v", err))
+		return id, err
 	}
 	log.Info("container run in {foreground|background}")
-	err = ketherObjectState.SetState(ctx, DEPLOYED)
+	return id, nil
+}
+
+// setDeployed 把对象标记为已部署，并在注册表中记录配置哈希、容器 ID、实际运行的镜像、实际绑定的主机端口和对象的修订
+func setDeployed(ctx context.Context, ketherObject *KetherObject, ketherObjectState *KetherObjectState, id string) error {
+	err := ketherObjectState.SetState(ctx, DEPLOYED, "")
 	if err != nil {
 		log.Error("fail to set state of kether object", "name", ketherObjectState.Name, "state", ketherObjectState.State, "err", err)
 		return err
 	}
 	log.Info("state of kether object set", "name", ketherObjectState.Name, "state", ketherObjectState.State)
-	return nil
+
+	configHash, err := ketherObject.GetConfigHash(ctx)
+	if err != nil {
+		log.Error("fail to get config hash of kether object", "name", ketherObject.Name, "err", err)
+		return err
+	}
+	portBindings, err := ketherObject.getActualPortBindings(ctx, id)
+	if err != nil {
+		log.Error("fail to get port bindings of kether object", "name", ketherObject.Name, "err", err)
+		return err
+	}
+	err = registry.SetDeploymentOfName(ctx, ketherObject.Name, registry.Deployment{
+		ConfigHash:  configHash,
+		ContainerID: id,
+		Image:       ketherObject.imageName,
+		ImageDigest: ketherObject.imageDigest,
+		ImageID:     ketherObject.imageId,
+		Ports:       portBindings,
+		Timestamp:   time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	// 释放对象之前预留、当前容器不再使用的主机端口
+	err = registry.ReleasePortsOfName(ctx, ketherObject.Name, ketherObject.portReservations)
+	if err != nil {
+		return err
+	}
+	return recordRevision(ctx, ketherObject, configHash)
 }
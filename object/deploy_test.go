@@ -0,0 +1,280 @@
+/*
+Copyright (c) 2022 Zhang Zhanpeng <zhangregister@outlook.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package object
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"testing"
+
+	"github.com/MonteCarloClub/kether/container"
+	"github.com/MonteCarloClub/kether/flag"
+	"github.com/MonteCarloClub/kether/log"
+	"github.com/MonteCarloClub/kether/registry"
+	"github.com/stretchr/testify/assert"
+)
+
+func init() {
+	log.InitLogger()
+}
+
+const (
+	testRepository = "ghcr.io/daocloud/dao-2048"
+	testImageName  = "ghcr.io/daocloud/dao-2048:1.1.0-alpha.6"
+)
+
+func getTestKetherObject(detach bool, localImage bool) (*KetherObject, *KetherObjectState) {
+	ketherObjectEntity := &KetherObjectEntity{
+		Name: "deploy-test",
+		Kind: "deploy",
+		Predicate: ResourceDescriptionEntity{
+			DockerImageRepository: testRepository,
+		},
+		Priority: ResourceDescriptionEntity{
+			DockerImageTag: "1.1.0-alpha.6",
+		},
+		Requirement: RunDescriptionEntity{
+			LocalImage: localImage,
+			Detach:     detach,
+		},
+	}
+	return ketherObjectEntity.GetKetherObject(), ketherObjectEntity.GetKetherObjectState()
+}
+
+// newTestEnv 以假的 Docker 引擎和内存注册表作为缺省后端，testImageName 在本地镜像缓存中，返回假的引擎和上下文
+func newTestEnv(t *testing.T) (*container.FakeEngine, context.Context) {
+	t.Helper()
+	fakeEngine := container.NewFakeEngine()
+	fakeEngine.LocalImages[testImageName] = true
+	container.DefaultEngine = fakeEngine
+	container.ResetImageAvailabilityCache()
+	registry.DefaultStore = registry.NewMemoryStore()
+	return fakeEngine, context.WithValue(context.Background(), flag.ContextKey, flag.ContextValType{})
+}
+
+func TestDeploy(t *testing.T) {
+	testCases := []struct {
+		name            string
+		detach          bool
+		localImage      bool
+		dryRun          bool
+		pinDigest       bool
+		requireDigest   bool
+		images          []string
+		cachedImages    []string
+		errors          map[string]error
+		exitCode        *int
+		expectErr       bool
+		expectCalls     []string
+		expectState     KetherObjectStateType
+		expectContainer string
+		expectImage     string
+	}{
+		{
+			name:            "detach",
+			detach:          true,
+			images:          []string{testImageName},
+			expectCalls:     []string{"InspectImage", "InspectDistribution", "PullImage", "InspectImage", "CreateContainer", "StartContainer", "InspectContainer"},
+			expectState:     DEPLOYED,
+			expectContainer: "running",
+			expectImage:     testImageName,
+		},
+		{
+			name:            "foreground",
+			images:          []string{testImageName},
+			exitCode:        new(int),
+			expectCalls:     []string{"InspectImage", "InspectDistribution", "PullImage", "InspectImage", "CreateContainer", "StartContainer", "InspectContainer", "GetContainerLogs", "WaitContainer"},
+			expectState:     DEPLOYED,
+			expectContainer: "exited",
+			expectImage:     testImageName,
+		},
+		{
+			name:            "foreground non-zero exit",
+			images:          []string{testImageName},
+			exitCode:        func(exitCode int) *int { return &exitCode }(3),
+			expectErr:       true,
+			expectCalls:     []string{"InspectImage", "InspectDistribution", "PullImage", "InspectImage", "CreateContainer", "StartContainer", "InspectContainer", "GetContainerLogs", "WaitContainer"},
+			expectState:     FAIL_TO_DEPLOY,
+			expectContainer: "exited",
+			expectImage:     testImageName,
+		},
+		{
+			name:            "local image",
+			detach:          true,
+			localImage:      true,
+			images:          []string{testImageName},
+			expectCalls:     []string{"InspectImage", "InspectImage", "CreateContainer", "StartContainer", "InspectContainer"},
+			expectState:     DEPLOYED,
+			expectContainer: "running",
+			expectImage:     testImageName,
+		},
+		{
+			name:            "fall back to latest tag",
+			detach:          true,
+			images:          []string{testRepository},
+			expectCalls:     []string{"InspectImage", "InspectDistribution", "InspectImage", "InspectDistribution", "PullImage", "InspectImage", "CreateContainer", "StartContainer", "InspectContainer"},
+			expectState:     DEPLOYED,
+			expectContainer: "running",
+			expectImage:     testRepository,
+		},
+		{
+			name:        "no available image",
+			detach:      true,
+			expectErr:   true,
+			expectCalls: []string{"InspectImage", "InspectDistribution", "InspectImage", "InspectDistribution"},
+			expectState: FAIL_TO_DEPLOY,
+		},
+		{
+			name:            "local image cache",
+			detach:          true,
+			cachedImages:    []string{testImageName},
+			errors:          map[string]error{"PullImage": fmt.Errorf("connection refused")},
+			expectCalls:     []string{"InspectImage", "PullImage", "InspectImage", "InspectImage", "CreateContainer", "StartContainer", "InspectContainer"},
+			expectState:     DEPLOYED,
+			expectContainer: "running",
+			expectImage:     testImageName,
+		},
+		{
+			name:        "pull failure",
+			detach:      true,
+			images:      []string{testImageName},
+			errors:      map[string]error{"PullImage": fmt.Errorf("toomanyrequests")},
+			expectErr:   true,
+			expectCalls: []string{"InspectImage", "InspectDistribution", "PullImage", "InspectImage"},
+			expectState: FAIL_TO_DEPLOY,
+		},
+		{
+			name:        "create failure",
+			detach:      true,
+			images:      []string{testImageName},
+			errors:      map[string]error{"CreateContainer": fmt.Errorf("conflict")},
+			expectErr:   true,
+			expectCalls: []string{"InspectImage", "InspectDistribution", "PullImage", "InspectImage", "CreateContainer"},
+			expectState: FAIL_TO_DEPLOY,
+		},
+		{
+			name:            "start failure",
+			detach:          true,
+			images:          []string{testImageName},
+			errors:          map[string]error{"StartContainer": fmt.Errorf("port is already allocated")},
+			expectErr:       true,
+			expectCalls:     []string{"InspectImage", "InspectDistribution", "PullImage", "InspectImage", "CreateContainer", "StartContainer"},
+			expectState:     FAIL_TO_DEPLOY,
+			expectContainer: "created",
+			expectImage:     testImageName,
+		},
+		{
+			name:            "pin digest",
+			detach:          true,
+			pinDigest:       true,
+			images:          []string{testImageName},
+			expectCalls:     []string{"InspectImage", "InspectDistribution", "PullImage", "InspectImage", "CreateContainer", "StartContainer", "InspectContainer"},
+			expectState:     DEPLOYED,
+			expectContainer: "running",
+			expectImage:     fmt.Sprintf("%v@sha256:%x", testRepository, sha256.Sum256([]byte(testImageName))),
+		},
+		{
+			name:          "digest required",
+			detach:        true,
+			requireDigest: true,
+			images:        []string{testImageName},
+			expectErr:     true,
+			expectCalls:   []string{"InspectImage", "InspectDistribution"},
+			expectState:   FAIL_TO_DEPLOY,
+		},
+		{
+			name:        "dry run",
+			detach:      true,
+			dryRun:      true,
+			images:      []string{testImageName},
+			expectCalls: []string{"InspectImage", "InspectDistribution"},
+			expectState: REGISTERED,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			fakeEngine := container.NewFakeEngine()
+			for method, err := range testCase.errors {
+				fakeEngine.Errors[method] = err
+			}
+			ketherObject, ketherObjectState := getTestKetherObject(testCase.detach, testCase.localImage)
+			if testCase.exitCode != nil {
+				fakeEngine.ExitCodes[ketherObject.Name] = *testCase.exitCode
+			}
+			for _, imageName := range testCase.images {
+				if testCase.localImage {
+					fakeEngine.LocalImages[imageName] = true
+				} else {
+					fakeEngine.RemoteImages[imageName] = true
+				}
+			}
+			for _, imageName := range testCase.cachedImages {
+				fakeEngine.LocalImages[imageName] = true
+			}
+			container.DefaultEngine = fakeEngine
+			container.ResetImageAvailabilityCache()
+			registry.DefaultStore = registry.NewMemoryStore()
+
+			ctx := context.WithValue(context.Background(), flag.ContextKey, flag.ContextValType{
+				DryRun:        testCase.dryRun,
+				PinDigest:     testCase.pinDigest,
+				RequireDigest: testCase.requireDigest,
+			})
+			assert.Nil(t, ketherObjectState.SetState(ctx, REGISTERING, ""))
+			assert.Nil(t, ketherObjectState.SetState(ctx, REGISTERED, ""))
+
+			err := Deploy(ctx, ketherObject, ketherObjectState)
+			if testCase.expectErr {
+				assert.NotNil(t, err)
+			} else {
+				assert.Nil(t, err)
+			}
+			assert.Equal(t, testCase.expectCalls, fakeEngine.Calls())
+			assert.Equal(t, testCase.expectState, ketherObjectState.State)
+
+			value, ok, err := registry.GetStateOfName(ctx, ketherObject.Name)
+			assert.Nil(t, err)
+			assert.True(t, ok)
+			state, err := ParseKetherObjectStateType(value)
+			assert.Nil(t, err)
+			assert.Equal(t, testCase.expectState, state)
+
+			status, containerConfig, _, ok := fakeEngine.GetContainer(ketherObject.GetContainerName())
+			assert.Equal(t, testCase.expectContainer != "", ok)
+			assert.Equal(t, testCase.expectContainer, status)
+			if ok {
+				assert.Equal(t, testCase.expectImage, containerConfig.Image)
+			}
+		})
+	}
+}
+
+func TestIsPinnedByDigest(t *testing.T) {
+	digest := fmt.Sprintf("sha256:%x", sha256.Sum256([]byte(testImageName)))
+	assert.True(t, isPinnedByDigest(testRepository+"@"+digest))
+	assert.True(t, isPinnedByDigest(testImageName+"@"+digest))
+	assert.False(t, isPinnedByDigest(testImageName))
+	assert.False(t, isPinnedByDigest(testRepository))
+	assert.False(t, isPinnedByDigest(digest))
+}
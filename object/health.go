@@ -0,0 +1,301 @@
+/*
+Copyright (c) 2022 Zhang Zhanpeng <zhangregister@outlook.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package object
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	kethercontainer "github.com/MonteCarloClub/kether/container"
+	"github.com/MonteCarloClub/kether/log"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+)
+
+const (
+	defaultReadinessTimeout = 60 * time.Second
+	defaultProbeInterval    = time.Second
+	defaultProbeTimeout     = 5 * time.Second
+)
+
+// HealthcheckTestEntity 是 Docker 健康检查命令，字符串由容器的 shell 执行，列表可以 CMD、CMD-SHELL 或 NONE 开头，否则视为 CMD 的参数
+type HealthcheckTestEntity []string
+
+func (healthcheckTestEntity *HealthcheckTestEntity) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var test string
+	if err := unmarshal(&test); err == nil {
+		*healthcheckTestEntity = []string{"CMD-SHELL", test}
+		return nil
+	}
+
+	var testSlice []string
+	if err := unmarshal(&testSlice); err != nil {
+		return fmt.Errorf("expect a string or a list of strings")
+	}
+	if len(testSlice) > 0 && testSlice[0] != "CMD" && testSlice[0] != "CMD-SHELL" && testSlice[0] != "NONE" {
+		testSlice = append([]string{"CMD"}, testSlice...)
+	}
+	*healthcheckTestEntity = testSlice
+	return nil
+}
+
+// TcpProbeEntity 在 kether 所在主机上连接 address 检查就绪
+type TcpProbeEntity struct {
+	Address string `yaml:"address"`
+}
+
+// HttpProbeEntity 在 kether 所在主机上 GET url 检查就绪，缺省期望 2xx 或 3xx 的状态码
+type HttpProbeEntity struct {
+	Url          string `yaml:"url"`
+	ExpectStatus int    `yaml:"expect_status"`
+}
+
+// ExecProbeEntity 在容器内执行 command 检查就绪，退出码为 0 表示就绪
+type ExecProbeEntity struct {
+	Command CommandEntity `yaml:"command"`
+}
+
+type HealthcheckEntity struct {
+	// Docker 原生的 HEALTHCHECK 配置
+	Test        HealthcheckTestEntity `yaml:"test"`
+	Interval    string                `yaml:"interval"`
+	Timeout     string                `yaml:"timeout"`
+	StartPeriod string                `yaml:"start_period"`
+	Retries     int                   `yaml:"retries"`
+	Disable     bool                  `yaml:"disable"`
+
+	// kether 侧的就绪探针，所有探针都成功时对象就绪
+	Tcp              *TcpProbeEntity  `yaml:"tcp"`
+	Http             *HttpProbeEntity `yaml:"http"`
+	Exec             *ExecProbeEntity `yaml:"exec"`
+	ReadinessTimeout string           `yaml:"readiness_timeout"`
+	ProbeInterval    string           `yaml:"probe_interval"`
+}
+
+// Healthcheck 描述 Kether 对象的健康检查和就绪条件
+type Healthcheck HealthcheckEntity
+
+// readiness 是解析后的就绪条件
+type readiness struct {
+	Timeout  time.Duration
+	Interval time.Duration
+}
+
+func parseDurationField(fieldErrors *FieldErrors, field string, value string, defaultValue time.Duration) time.Duration {
+	if value == "" {
+		return defaultValue
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration <= 0 {
+		fieldErrors.add(field, "%q is not a positive duration like 30s", value)
+		return defaultValue
+	}
+	return duration
+}
+
+// getHealthConfig 解析健康检查配置，返回 Docker 原生的健康检查配置和 kether 侧的就绪条件
+func getHealthConfig(healthcheckEntity *HealthcheckEntity) (*container.HealthConfig, *readiness, FieldErrors) {
+	fieldErrors := make(FieldErrors, 0)
+	readiness := &readiness{
+		Timeout:  defaultReadinessTimeout,
+		Interval: defaultProbeInterval,
+	}
+	if healthcheckEntity == nil {
+		return nil, readiness, fieldErrors
+	}
+
+	var healthConfig *container.HealthConfig
+	if healthcheckEntity.Disable {
+		healthConfig = &container.HealthConfig{
+			Test: []string{"NONE"},
+		}
+	} else if len(healthcheckEntity.Test) > 0 || healthcheckEntity.Interval != "" || healthcheckEntity.Timeout != "" || healthcheckEntity.StartPeriod != "" || healthcheckEntity.Retries != 0 {
+		healthConfig = &container.HealthConfig{
+			Test:        healthcheckEntity.Test,
+			Interval:    parseDurationField(&fieldErrors, "healthcheck.interval", healthcheckEntity.Interval, 0),
+			Timeout:     parseDurationField(&fieldErrors, "healthcheck.timeout", healthcheckEntity.Timeout, 0),
+			StartPeriod: parseDurationField(&fieldErrors, "healthcheck.start_period", healthcheckEntity.StartPeriod, 0),
+			Retries:     healthcheckEntity.Retries,
+		}
+		if len(healthConfig.Test) == 1 && healthConfig.Test[0] != "NONE" {
+			fieldErrors.add("healthcheck.test", "%v requires a command", healthConfig.Test[0])
+		}
+		if healthConfig.Retries < 0 {
+			fieldErrors.add("healthcheck.retries", "%v is negative", healthConfig.Retries)
+		}
+	}
+
+	if healthcheckEntity.Tcp != nil {
+		_, _, err := net.SplitHostPort(healthcheckEntity.Tcp.Address)
+		if err != nil {
+			fieldErrors.add("healthcheck.tcp.address", "%v", err)
+		}
+	}
+	if healthcheckEntity.Http != nil {
+		u, err := url.Parse(healthcheckEntity.Http.Url)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			fieldErrors.add("healthcheck.http.url", "%q is not a valid http(s) url", healthcheckEntity.Http.Url)
+		}
+		if expectStatus := healthcheckEntity.Http.ExpectStatus; expectStatus != 0 && (expectStatus < 100 || expectStatus > 599) {
+			fieldErrors.add("healthcheck.http.expect_status", "%v is not a valid http status code", expectStatus)
+		}
+	}
+	if healthcheckEntity.Exec != nil && len(healthcheckEntity.Exec.Command) == 0 {
+		fieldErrors.add("healthcheck.exec.command", "empty command")
+	}
+	readiness.Timeout = parseDurationField(&fieldErrors, "healthcheck.readiness_timeout", healthcheckEntity.ReadinessTimeout, defaultReadinessTimeout)
+	readiness.Interval = parseDurationField(&fieldErrors, "healthcheck.probe_interval", healthcheckEntity.ProbeInterval, defaultProbeInterval)
+	return healthConfig, readiness, fieldErrors
+}
+
+func probeTcp(ctx context.Context, tcpProbe *TcpProbeEntity) error {
+	dialer := &net.Dialer{
+		Timeout: defaultProbeTimeout,
+	}
+	conn, err := dialer.DialContext(ctx, "tcp", tcpProbe.Address)
+	if err != nil {
+		return fmt.Errorf("tcp probe: %v", err)
+	}
+	return conn.Close()
+}
+
+func probeHttp(ctx context.Context, httpProbe *HttpProbeEntity) error {
+	ctx, cancel := context.WithTimeout(ctx, defaultProbeTimeout)
+	defer cancel()
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, httpProbe.Url, nil)
+	if err != nil {
+		return fmt.Errorf("http probe: %v", err)
+	}
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return fmt.Errorf("http probe: %v", err)
+	}
+	response.Body.Close()
+	if httpProbe.ExpectStatus != 0 {
+		if response.StatusCode != httpProbe.ExpectStatus {
+			return fmt.Errorf("http probe: status code %v, expect %v", response.StatusCode, httpProbe.ExpectStatus)
+		}
+	} else if response.StatusCode < 200 || response.StatusCode >= 400 {
+		return fmt.Errorf("http probe: status code %v", response.StatusCode)
+	}
+	return nil
+}
+
+func probeExec(ctx context.Context, id string, execProbe *ExecProbeEntity) error {
+	exitCode, output, err := kethercontainer.ExecInDockerContainer(ctx, id, execProbe.Command)
+	if err != nil {
+		return fmt.Errorf("exec probe: %v", err)
+	}
+	if exitCode != 0 {
+		return fmt.Errorf("exec probe: exit code %v, output %q", exitCode, strings.TrimSpace(output))
+	}
+	return nil
+}
+
+// checkDockerHealth 检查容器是否在运行，容器配置了 Docker 健康检查时检查其是否健康；容器已退出时返回 exited == true
+func checkDockerHealth(ctx context.Context, id string) (exited bool, err error) {
+	containerJSON, ok, err := kethercontainer.InspectDockerContainer(ctx, id)
+	if err != nil {
+		return false, err
+	}
+	if !ok || containerJSON.State == nil {
+		return true, fmt.Errorf("container %v not found", id)
+	}
+	state := containerJSON.State
+	if !state.Running {
+		return true, fmt.Errorf("container exited with status code %v", state.ExitCode)
+	}
+	if state.Health != nil && state.Health.Status != types.Healthy {
+		err = fmt.Errorf("docker health status is %v", state.Health.Status)
+		if len(state.Health.Log) > 0 {
+			lastResult := state.Health.Log[len(state.Health.Log)-1]
+			err = fmt.Errorf("%v, last check exited with %v: %v", err, lastResult.ExitCode, strings.TrimSpace(lastResult.Output))
+		}
+		return false, err
+	}
+	return false, nil
+}
+
+// checkReadiness 依次检查 Docker 健康状态和 kether 侧的探针，返回第一个错误
+func (ketherObject *KetherObject) checkReadiness(ctx context.Context, id string) (exited bool, err error) {
+	exited, err = checkDockerHealth(ctx, id)
+	if err != nil {
+		return exited, err
+	}
+	healthcheck := ketherObject.Healthcheck
+	if healthcheck == nil {
+		return false, nil
+	}
+	if healthcheck.Tcp != nil {
+		if err = probeTcp(ctx, healthcheck.Tcp); err != nil {
+			return false, err
+		}
+	}
+	if healthcheck.Http != nil {
+		if err = probeHttp(ctx, healthcheck.Http); err != nil {
+			return false, err
+		}
+	}
+	if healthcheck.Exec != nil {
+		if err = probeExec(ctx, id, healthcheck.Exec); err != nil {
+			return false, err
+		}
+	}
+	return false, nil
+}
+
+// WaitForReadiness 等待容器就绪，超时或容器退出时返回探针的最后一个错误
+func (ketherObject *KetherObject) WaitForReadiness(ctx context.Context, id string) error {
+	_, readiness, fieldErrors := getHealthConfig((*HealthcheckEntity)(ketherObject.Healthcheck))
+	if len(fieldErrors) > 0 {
+		return fieldErrors
+	}
+
+	timer := time.NewTimer(readiness.Timeout)
+	defer timer.Stop()
+	ticker := time.NewTicker(readiness.Interval)
+	defer ticker.Stop()
+	for {
+		exited, err := ketherObject.checkReadiness(ctx, id)
+		if err == nil {
+			log.Info("kether object ready", "name", ketherObject.Name)
+			return nil
+		}
+		if exited {
+			return err
+		}
+		log.Info("kether object not ready yet", "name", ketherObject.Name, "reason", err)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+			return fmt.Errorf("not ready in %v: %v", readiness.Timeout, err)
+		case <-ticker.C:
+		}
+	}
+}
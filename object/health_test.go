@@ -0,0 +1,167 @@
+/*
+Copyright (c) 2022 Zhang Zhanpeng <zhangregister@outlook.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package object
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
+)
+
+func TestHealthcheckEntity(t *testing.T) {
+	yamlBytes := []byte(`
+test: curl -f http://localhost:8545
+interval: 10s
+timeout: 3s
+start_period: 30s
+retries: 3
+tcp:
+  address: 127.0.0.1:30303
+http:
+  url: http://127.0.0.1:8545
+exec:
+  command: geth attach --exec eth.blockNumber
+readiness_timeout: 2m
+`)
+	healthcheckEntity := &HealthcheckEntity{}
+	assert.Nil(t, yaml.Unmarshal(yamlBytes, healthcheckEntity))
+	healthConfig, readiness, fieldErrors := getHealthConfig(healthcheckEntity)
+	assert.Empty(t, fieldErrors)
+	assert.Equal(t, []string{"CMD-SHELL", "curl -f http://localhost:8545"}, healthConfig.Test)
+	assert.Equal(t, 3, healthConfig.Retries)
+	assert.Equal(t, "2m0s", readiness.Timeout.String())
+	assert.Equal(t, defaultProbeInterval, readiness.Interval)
+
+	healthcheckEntity = &HealthcheckEntity{}
+	assert.Nil(t, yaml.Unmarshal([]byte(`
+test: [pg_isready]
+interval: soon
+tcp:
+  address: 8545
+http:
+  url: localhost:8545
+exec:
+  command: []
+`), healthcheckEntity))
+	healthConfig, _, fieldErrors = getHealthConfig(healthcheckEntity)
+	assert.Equal(t, []string{"CMD", "pg_isready"}, healthConfig.Test)
+	fields := make([]string, 0)
+	for _, fieldError := range fieldErrors {
+		fields = append(fields, fieldError.Field)
+	}
+	assert.Equal(t, []string{"healthcheck.interval", "healthcheck.tcp.address", "healthcheck.http.url", "healthcheck.exec.command"}, fields)
+}
+
+func TestWaitForReadiness(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer listener.Close()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/ready" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	testCases := []struct {
+		name           string
+		healthcheck    *Healthcheck
+		healthStatus   string
+		execExitCode   int
+		exitCode       *int
+		expectErr      bool
+		expectDeployed bool
+	}{
+		{
+			name:           "no healthcheck",
+			expectDeployed: true,
+		},
+		{
+			name: "all probes pass",
+			healthcheck: &Healthcheck{
+				Tcp:  &TcpProbeEntity{Address: listener.Addr().String()},
+				Http: &HttpProbeEntity{Url: server.URL + "/ready"},
+				Exec: &ExecProbeEntity{Command: CommandEntity{"true"}},
+			},
+			healthStatus:   "healthy",
+			expectDeployed: true,
+		},
+		{
+			name:         "docker health starting",
+			healthcheck:  &Healthcheck{ReadinessTimeout: "50ms", ProbeInterval: "10ms"},
+			healthStatus: "starting",
+			expectErr:    true,
+		},
+		{
+			name: "http probe fails",
+			healthcheck: &Healthcheck{
+				Http:             &HttpProbeEntity{Url: server.URL + "/not-ready"},
+				ReadinessTimeout: "50ms",
+				ProbeInterval:    "10ms",
+			},
+			expectErr: true,
+		},
+		{
+			name: "exec probe fails",
+			healthcheck: &Healthcheck{
+				Exec:             &ExecProbeEntity{Command: CommandEntity{"false"}},
+				ReadinessTimeout: "50ms",
+				ProbeInterval:    "10ms",
+			},
+			execExitCode: 1,
+			expectErr:    true,
+		},
+		{
+			name:        "container exits",
+			healthcheck: &Healthcheck{ReadinessTimeout: "1h"},
+			exitCode:    func(exitCode int) *int { return &exitCode }(1),
+			expectErr:   true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			fakeEngine, ctx := newTestEnv(t)
+			ketherObject, ketherObjectState := getTestKetherObject(true, true)
+			ketherObject.Healthcheck = testCase.healthcheck
+			if testCase.healthStatus != "" {
+				fakeEngine.HealthStatuses[ketherObject.Name] = testCase.healthStatus
+			}
+			fakeEngine.ExecExitCodes[ketherObject.Name] = testCase.execExitCode
+			if testCase.exitCode != nil {
+				fakeEngine.ExitCodes[ketherObject.Name] = *testCase.exitCode
+			}
+			assert.Nil(t, registerKetherObject(ctx, ketherObjectState))
+			err := Deploy(ctx, ketherObject, ketherObjectState)
+			assert.Equal(t, testCase.expectErr, err != nil, err)
+			if testCase.expectDeployed {
+				assert.Equal(t, DEPLOYED, ketherObjectState.State)
+			} else {
+				assert.Equal(t, FAIL_TO_DEPLOY, ketherObjectState.State)
+			}
+		})
+	}
+}
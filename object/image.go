@@ -0,0 +1,68 @@
+/*
+Copyright (c) 2022 Zhang Zhanpeng <zhangregister@outlook.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package object
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/MonteCarloClub/kether/container"
+	"github.com/docker/distribution/reference"
+)
+
+const (
+	// ImageLabel 是以摘要创建的容器上记录原始镜像名的标签
+	ImageLabel = "kether.image"
+)
+
+// isPinnedByDigest 判断镜像名是否以摘要固定，如 ethereum/client-go@sha256:... 或 ethereum/client-go:v1.10.17@sha256:...
+func isPinnedByDigest(imageName string) bool {
+	named, err := reference.ParseNormalizedNamed(imageName)
+	if err != nil {
+		return false
+	}
+	_, ok := named.(reference.Canonical)
+	return ok
+}
+
+// getImageDigest 返回本地镜像的仓库摘要引用和镜像 ID，仓库摘要引用如 ethereum/client-go@sha256:...；
+// 只在本地存在的镜像没有仓库摘要，返回空字符串
+func getImageDigest(ctx context.Context, imageName string) (string, string, error) {
+	imageInspect, ok, err := container.InspectDockerImage(ctx, imageName)
+	if err != nil {
+		return "", "", err
+	}
+	if !ok {
+		return "", "", fmt.Errorf("image %v not found in local image cache", imageName)
+	}
+	named, err := reference.ParseNormalizedNamed(imageName)
+	if err != nil {
+		return "", "", err
+	}
+	for _, repoDigest := range imageInspect.RepoDigests {
+		digested, err := reference.ParseNormalizedNamed(repoDigest)
+		if err == nil && digested.Name() == named.Name() {
+			return repoDigest, imageInspect.ID, nil
+		}
+	}
+	return "", imageInspect.ID, nil
+}
@@ -0,0 +1,227 @@
+/*
+Copyright (c) 2022 Zhang Zhanpeng <zhangregister@outlook.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package object
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+var fieldTokenRegexp = regexp.MustCompile(`^([^\[\]]*)(?:\[(\d+)\])?$`)
+
+// yamlDocuments 是 YAML 文件的节点树，all 是所有文档的根节点，objects 是未被跳过的文档的根节点，与 parseKetherObjectEntities 一致
+type yamlDocuments struct {
+	all     []*yaml.Node
+	objects []*yaml.Node
+}
+
+func parseYamlDocuments(yamlBytes []byte) *yamlDocuments {
+	documents := &yamlDocuments{
+		all:     make([]*yaml.Node, 0),
+		objects: make([]*yaml.Node, 0),
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(yamlBytes))
+	for {
+		node := &yaml.Node{}
+		if err := decoder.Decode(node); err != nil {
+			break
+		}
+		if len(node.Content) == 0 {
+			continue
+		}
+		root := node.Content[0]
+		documents.all = append(documents.all, root)
+
+		document := struct {
+			Name     string        `yaml:"name"`
+			Kind     string        `yaml:"kind"`
+			Objects  []interface{} `yaml:"objects"`
+			Networks []interface{} `yaml:"networks"`
+		}{}
+		root.Decode(&document)
+		if !isEmptyDocument(document.Name, document.Kind, len(document.Objects), len(document.Networks)) {
+			documents.objects = append(documents.objects, root)
+		}
+	}
+	return documents
+}
+
+// locateFieldErrors 补全字段错误的位置：有字段路径的错误沿路径查找节点，找不到时定位到最深的祖先；
+// 只有行号的错误（来自 yaml 包）查找该行的键，补全列号和字段路径
+func locateFieldErrors(yamlBytes []byte, fieldErrors FieldErrors) {
+	documents := parseYamlDocuments(yamlBytes)
+	for _, fieldError := range fieldErrors {
+		if fieldError.Line > 0 {
+			if fieldError.Column == 0 {
+				documents.locateLine(fieldError)
+			}
+			continue
+		}
+		if fieldError.Field == "" {
+			continue
+		}
+		node := documents.locateField(fieldError.Field)
+		if node != nil {
+			fieldError.Line, fieldError.Column = node.Line, node.Column
+		}
+	}
+}
+
+func (documents *yamlDocuments) locateField(field string) *yaml.Node {
+	if len(documents.objects) == 0 {
+		return nil
+	}
+	node := documents.objects[0]
+	tokens := strings.Split(field, ".")
+	if strings.HasPrefix(tokens[0], "documents[") {
+		match := fieldTokenRegexp.FindStringSubmatch(tokens[0])
+		if match == nil || match[2] == "" {
+			return nil
+		}
+		index, _ := strconv.Atoi(match[2])
+		if index >= len(documents.objects) {
+			return nil
+		}
+		node = documents.objects[index]
+		tokens = tokens[1:]
+	}
+
+	for _, token := range tokens {
+		match := fieldTokenRegexp.FindStringSubmatch(token)
+		if match == nil {
+			break
+		}
+		value := getMappingValue(node, match[1])
+		if value == nil {
+			break
+		}
+		node = value
+		if match[2] == "" {
+			continue
+		}
+		index, _ := strconv.Atoi(match[2])
+		item := getItem(node, index)
+		if item == nil {
+			break
+		}
+		node = item
+	}
+	return node
+}
+
+func getMappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// getItem 返回列表的第 index 项；映射按键排序后取第 index 个键，与 EnvironmentEntity 的转换一致
+func getItem(node *yaml.Node, index int) *yaml.Node {
+	switch node.Kind {
+	case yaml.SequenceNode:
+		if index < len(node.Content) {
+			return node.Content[index]
+		}
+	case yaml.MappingNode:
+		keys := make([]*yaml.Node, 0, len(node.Content)/2)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			keys = append(keys, node.Content[i])
+		}
+		sort.SliceStable(keys, func(i, j int) bool {
+			return keys[i].Value < keys[j].Value
+		})
+		if index < len(keys) {
+			return keys[index]
+		}
+	}
+	return nil
+}
+
+func (documents *yamlDocuments) locateLine(fieldError *FieldError) {
+	name := ""
+	if strings.HasPrefix(fieldError.Message, "unknown field ") {
+		name = strings.TrimPrefix(fieldError.Message, "unknown field ")
+	}
+	for _, root := range documents.all {
+		field := ""
+		for i, object := range documents.objects {
+			if object == root {
+				field = strings.TrimSuffix(getDocumentPrefix(i, len(documents.objects)), ".")
+			}
+		}
+		node, nodeField := findNodeOnLine(root, field, fieldError.Line, name)
+		if node != nil {
+			fieldError.Column = node.Column
+			if fieldError.Field == "" {
+				fieldError.Field = nodeField
+			}
+			return
+		}
+	}
+}
+
+func joinField(field string, key string) string {
+	if field == "" {
+		return key
+	}
+	return field + "." + key
+}
+
+// findNodeOnLine 深度优先地查找第 line 行的键或列表项，name 非空时只查找名为 name 的键，返回节点及其字段路径
+func findNodeOnLine(node *yaml.Node, field string, line int, name string) (*yaml.Node, string) {
+	switch node.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			keyField := joinField(field, key.Value)
+			if key.Line == line && (name == "" || key.Value == name) {
+				return key, keyField
+			}
+			if found, foundField := findNodeOnLine(value, keyField, line, name); found != nil {
+				return found, foundField
+			}
+		}
+	case yaml.SequenceNode:
+		for i, item := range node.Content {
+			itemField := fmt.Sprintf("%v[%v]", field, i)
+			if name == "" && item.Kind == yaml.ScalarNode && item.Line == line {
+				return item, itemField
+			}
+			if found, foundField := findNodeOnLine(item, itemField, line, name); found != nil {
+				return found, foundField
+			}
+		}
+	}
+	return nil, ""
+}
@@ -0,0 +1,96 @@
+/*
+Copyright (c) 2022 Zhang Zhanpeng <zhangregister@outlook.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package object
+
+import (
+	"context"
+	"time"
+
+	"github.com/MonteCarloClub/kether/flag"
+	"github.com/MonteCarloClub/kether/log"
+	"github.com/MonteCarloClub/kether/registry"
+)
+
+const (
+	lockTTL           = 15 * time.Second
+	lockRenewInterval = lockTTL / 3
+)
+
+// Lock 获取 Kether 对象的租约锁并在后台续约，等待锁的最长时间由 ctx 中的 WaitLock 指定；
+// 已经持有锁或处于 dry run 模式时什么也不做
+func (ketherObjectState *KetherObjectState) Lock(ctx context.Context) error {
+	contextVal := ctx.Value(flag.ContextKey).(flag.ContextValType)
+	if contextVal.DryRun || ketherObjectState.lease != nil {
+		return nil
+	}
+	lease, err := registry.LockOfName(ctx, ketherObjectState.Name, registry.GetLockHolder(), lockTTL, contextVal.WaitLock)
+	if err != nil {
+		return err
+	}
+	ketherObjectState.lease = lease
+	ketherObjectState.stopRenewing = make(chan struct{})
+	go renewLock(lease, ketherObjectState.stopRenewing)
+	return nil
+}
+
+// renewLock 定期续约直到 stop 被关闭，租约丢失时停止续约，之后持有者的写入会被拒绝
+func renewLock(lease *registry.Lease, stop <-chan struct{}) {
+	ticker := time.NewTicker(lockRenewInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			err := registry.RenewLockOfName(context.Background(), lease, lockTTL)
+			if err == registry.ErrLeaseLost {
+				log.Error("lock of kether object lost", "name", lease.Name, "token", lease.Token)
+				return
+			}
+		}
+	}
+}
+
+// Unlock 停止续约并释放 Kether 对象的租约锁
+func (ketherObjectState *KetherObjectState) Unlock(ctx context.Context) {
+	if ketherObjectState.lease == nil {
+		return
+	}
+	close(ketherObjectState.stopRenewing)
+	registry.UnlockOfName(ctx, ketherObjectState.lease)
+	ketherObjectState.lease = nil
+	ketherObjectState.stopRenewing = nil
+}
+
+// lock 在未持有锁时获取租约锁，返回的 release 只释放本次获取的锁
+func (ketherObjectState *KetherObjectState) lock(ctx context.Context) (func(), error) {
+	if ketherObjectState.lease != nil {
+		return func() {}, nil
+	}
+	err := ketherObjectState.Lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return func() {
+		ketherObjectState.Unlock(ctx)
+	}, nil
+}
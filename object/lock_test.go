@@ -0,0 +1,70 @@
+/*
+Copyright (c) 2022 Zhang Zhanpeng <zhangregister@outlook.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package object
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/MonteCarloClub/kether/flag"
+	"github.com/MonteCarloClub/kether/registry"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLock(t *testing.T) {
+	fakeEngine, ctx := newTestEnv(t)
+
+	ketherObject, ketherObjectState := getTestKetherObject(true, true)
+	assert.Nil(t, registerKetherObject(ctx, ketherObjectState))
+	ketherObjectState.Unlock(ctx)
+
+	lease, err := registry.LockOfName(ctx, ketherObject.Name, "bob@elsewhere:1", time.Minute, 0)
+	assert.Nil(t, err)
+
+	// 其他持有者持有锁时部署失败，不会修改状态或创建容器
+	err = Deploy(ctx, ketherObject, ketherObjectState)
+	var lockHeldError *registry.LockHeldError
+	assert.True(t, errors.As(err, &lockHeldError))
+	assert.EqualError(t, err, "kether object deploy-test is being modified by bob@elsewhere:1")
+	assert.Empty(t, fakeEngine.Calls())
+	assert.Equal(t, REGISTERED, ketherObjectState.State)
+
+	// 未持有锁的写入被拒绝
+	err = ketherObjectState.SetState(ctx, FAIL_TO_DEPLOY, "")
+	assert.True(t, errors.As(err, &lockHeldError))
+
+	// 等待锁的持有者释放锁后继续部署
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		registry.UnlockOfName(ctx, lease)
+	}()
+	waitCtx := context.WithValue(context.Background(), flag.ContextKey, flag.ContextValType{WaitLock: 5 * time.Second})
+	assert.Nil(t, Deploy(waitCtx, ketherObject, ketherObjectState))
+	assert.Equal(t, DEPLOYED, ketherObjectState.State)
+
+	// 部署结束后释放锁
+	lease, err = registry.LockOfName(ctx, ketherObject.Name, "bob@elsewhere:1", time.Minute, 0)
+	assert.Nil(t, err)
+	assert.Nil(t, registry.UnlockOfName(ctx, lease))
+}
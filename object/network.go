@@ -0,0 +1,287 @@
+/*
+Copyright (c) 2022 Zhang Zhanpeng <zhangregister@outlook.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package object
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"reflect"
+	"sort"
+	"strings"
+
+	kethercontainer "github.com/MonteCarloClub/kether/container"
+	"github.com/MonteCarloClub/kether/log"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/errdefs"
+)
+
+// NetworkEntity 是 network_list 中的一个网络，可写成网络名字符串或映射；name:gateway 形式的字符串仍被接受，
+// 其中的网关只被检查，容器的网关由网络的 IPAM 配置决定
+type NetworkEntity struct {
+	Name        string   `yaml:"name"`
+	IPv4Address string   `yaml:"ipv4_address"`
+	IPv6Address string   `yaml:"ipv6_address"`
+	Aliases     []string `yaml:"aliases"`
+	Links       []string `yaml:"links"`
+
+	gateway string
+}
+
+func (networkEntity *NetworkEntity) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var networkGatewayPair string
+	if err := unmarshal(&networkGatewayPair); err == nil {
+		networkSlice := strings.SplitN(networkGatewayPair, ":", 2)
+		*networkEntity = NetworkEntity{
+			Name: networkSlice[0],
+		}
+		if len(networkSlice) == 2 {
+			networkEntity.gateway = networkSlice[1]
+		}
+		return nil
+	}
+
+	type plainNetworkEntity NetworkEntity
+	return unmarshal((*plainNetworkEntity)(networkEntity))
+}
+
+// isSameNetwork 判断两个网络的配置是否相同，同一对象中重复的相同网络被去重
+func isSameNetwork(networkEntity1 NetworkEntity, networkEntity2 NetworkEntity) bool {
+	return formatNetwork(networkEntity1) == formatNetwork(networkEntity2)
+}
+
+// formatNetwork 以 name ipv4=... ipv6=... aliases=... links=... 的形式描述网络，用于比较和输出
+func formatNetwork(networkEntity NetworkEntity) string {
+	fields := []string{networkEntity.Name}
+	if networkEntity.IPv4Address != "" {
+		fields = append(fields, "ipv4="+networkEntity.IPv4Address)
+	}
+	if networkEntity.IPv6Address != "" {
+		fields = append(fields, "ipv6="+networkEntity.IPv6Address)
+	}
+	if len(networkEntity.Aliases) > 0 {
+		fields = append(fields, "aliases="+joinSorted(networkEntity.Aliases))
+	}
+	if len(networkEntity.Links) > 0 {
+		fields = append(fields, "links="+joinSorted(networkEntity.Links))
+	}
+	return strings.Join(fields, " ")
+}
+
+func joinSorted(values []string) string {
+	sortedValues := getUniqueNames(values)
+	sort.Strings(sortedValues)
+	return strings.Join(sortedValues, ",")
+}
+
+// NetworkDeclarationEntity 是 YAML 中声明的网络，部署时不存在的网络按声明创建
+type NetworkDeclarationEntity struct {
+	Name    string            `yaml:"name"`
+	Driver  string            `yaml:"driver"`
+	Subnet  string            `yaml:"subnet"`
+	Gateway string            `yaml:"gateway"`
+	Labels  map[string]string `yaml:"labels"`
+}
+
+// NetworkDeclaration 是声明的网络
+type NetworkDeclaration NetworkDeclarationEntity
+
+// getNetworks 返回去重后的网络，同名的网络只保留第一个
+func (ketherObject *KetherObject) getNetworks() []NetworkEntity {
+	networks := make([]NetworkEntity, 0, len(ketherObject.Requirement.NetworkList))
+	seen := make(map[string]bool, len(ketherObject.Requirement.NetworkList))
+	for _, networkEntity := range ketherObject.Requirement.NetworkList {
+		if networkEntity.Name == "" || seen[networkEntity.Name] {
+			continue
+		}
+		seen[networkEntity.Name] = true
+		networks = append(networks, networkEntity)
+	}
+	return networks
+}
+
+func getEndpointSettings(networkEntity NetworkEntity) *network.EndpointSettings {
+	endpointSettings := &network.EndpointSettings{
+		Aliases: networkEntity.Aliases,
+		Links:   networkEntity.Links,
+	}
+	if networkEntity.IPv4Address != "" || networkEntity.IPv6Address != "" {
+		endpointSettings.IPAMConfig = &network.EndpointIPAMConfig{
+			IPv4Address: networkEntity.IPv4Address,
+			IPv6Address: networkEntity.IPv6Address,
+		}
+	}
+	return endpointSettings
+}
+
+// getNetworkMode 返回容器的网络模式，即创建容器时连接的第一个网络，没有指定网络时为空，由 Docker 连接缺省的 bridge 网络
+func (ketherObject *KetherObject) getNetworkMode() string {
+	networks := ketherObject.getNetworks()
+	if len(networks) == 0 {
+		return ""
+	}
+	return networks[0].Name
+}
+
+// GetNetworkingConfig 返回创建容器时连接的第一个网络，Docker API 1.44 之前创建容器时只能指定一个网络，
+// 其余网络在容器创建后由 connectNetworks 连接
+func (ketherObject *KetherObject) GetNetworkingConfig() *network.NetworkingConfig {
+	networks := ketherObject.getNetworks()
+	if len(networks) == 0 {
+		log.Info("empty network list")
+		return nil
+	}
+	return &network.NetworkingConfig{
+		EndpointsConfig: map[string]*network.EndpointSettings{
+			networks[0].Name: getEndpointSettings(networks[0]),
+		},
+	}
+}
+
+func (ketherObject *KetherObject) getNetworkDeclaration(networkName string) *NetworkDeclaration {
+	for _, networkDeclaration := range ketherObject.Networks {
+		if networkDeclaration.Name == networkName {
+			return networkDeclaration
+		}
+	}
+	return nil
+}
+
+func getNetworkCreateOptions(networkDeclaration *NetworkDeclaration) types.NetworkCreate {
+	options := types.NetworkCreate{
+		CheckDuplicate: true,
+		Driver:         networkDeclaration.Driver,
+		Labels:         networkDeclaration.Labels,
+	}
+	if networkDeclaration.Subnet != "" {
+		options.IPAM = &network.IPAM{
+			Config: []network.IPAMConfig{{
+				Subnet:  networkDeclaration.Subnet,
+				Gateway: networkDeclaration.Gateway,
+			}},
+		}
+		if ip, _, err := net.ParseCIDR(networkDeclaration.Subnet); err == nil && ip.To4() == nil {
+			options.EnableIPv6 = true
+		}
+	}
+	return options
+}
+
+// ensureNetworks 确认对象连接的网络都存在，不存在的网络按 YAML 中的声明创建，没有声明时返回错误；
+// 已经存在的网络与声明的子网不一致时只给出警告
+func (ketherObject *KetherObject) ensureNetworks(ctx context.Context) error {
+	for _, networkEntity := range ketherObject.getNetworks() {
+		networkDeclaration := ketherObject.getNetworkDeclaration(networkEntity.Name)
+		networkResource, ok, err := kethercontainer.InspectDockerNetwork(ctx, networkEntity.Name)
+		if err != nil {
+			return err
+		}
+		if ok {
+			if networkDeclaration != nil && networkDeclaration.Subnet != "" && !hasSubnet(networkResource, networkDeclaration.Subnet) {
+				log.Warn("existing network differs from its declaration", "networkName", networkEntity.Name, "subnet", networkDeclaration.Subnet, "ipam", networkResource.IPAM.Config)
+			}
+			continue
+		}
+		if networkDeclaration == nil {
+			return fmt.Errorf("network %v not found, create it or declare it in networks", networkEntity.Name)
+		}
+		_, err = kethercontainer.CreateDockerNetwork(ctx, networkEntity.Name, getNetworkCreateOptions(networkDeclaration))
+		// 并行部署的对象可能同时创建同一网络
+		if errdefs.IsConflict(err) {
+			log.Info("network created by another kether object", "networkName", networkEntity.Name)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func hasSubnet(networkResource types.NetworkResource, subnet string) bool {
+	for _, ipamConfig := range networkResource.IPAM.Config {
+		if ipamConfig.Subnet == subnet {
+			return true
+		}
+	}
+	return false
+}
+
+// connectNetworks 把容器连接到第一个网络以外的网络
+func (ketherObject *KetherObject) connectNetworks(ctx context.Context, id string) error {
+	networks := ketherObject.getNetworks()
+	for i := 1; i < len(networks); i++ {
+		err := kethercontainer.ConnectDockerNetwork(ctx, networks[i].Name, id, getEndpointSettings(networks[i]))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// linkNetworkDeclarations 检查栈中声明的网络，同名的网络只能以相同的配置声明；把声明的网络关联到连接它的对象，
+// 并检查对象的静态地址是否在声明的子网内
+func (stack *Stack) linkNetworkDeclarations(networkDeclarationFields []networkDeclarationField, fieldPrefixes []string) FieldErrors {
+	fieldErrors := make(FieldErrors, 0)
+	networkDeclarations := make(map[string]*NetworkDeclaration, len(networkDeclarationFields))
+	for _, networkDeclarationField := range networkDeclarationFields {
+		networkDeclarationEntity := networkDeclarationField.networkDeclarationEntity
+		if err := checkNetworkDeclaration(networkDeclarationEntity); err != nil {
+			fieldErrors.add(networkDeclarationField.field, "%v", err)
+			continue
+		}
+		if networkDeclaration, ok := networkDeclarations[networkDeclarationEntity.Name]; ok {
+			if !reflect.DeepEqual(*networkDeclaration, NetworkDeclaration(networkDeclarationEntity)) {
+				fieldErrors.add(networkDeclarationField.field, "network %v is declared more than once with different settings", networkDeclarationEntity.Name)
+			}
+			continue
+		}
+		networkDeclaration := NetworkDeclaration(networkDeclarationEntity)
+		networkDeclarations[networkDeclarationEntity.Name] = &networkDeclaration
+	}
+
+	for i, ketherObject := range stack.KetherObjects {
+		ketherObject.Networks = make([]*NetworkDeclaration, 0)
+		for j, networkEntity := range ketherObject.Requirement.NetworkList {
+			networkDeclaration, ok := networkDeclarations[networkEntity.Name]
+			if !ok {
+				continue
+			}
+			if ketherObject.getNetworkDeclaration(networkEntity.Name) == nil {
+				ketherObject.Networks = append(ketherObject.Networks, networkDeclaration)
+			}
+			if networkDeclaration.Subnet == "" {
+				continue
+			}
+			_, subnet, _ := net.ParseCIDR(networkDeclaration.Subnet)
+			for field, address := range map[string]string{"ipv4_address": networkEntity.IPv4Address, "ipv6_address": networkEntity.IPv6Address} {
+				ip := net.ParseIP(address)
+				// 与子网地址族不同的地址由网络的其他子网分配
+				if ip != nil && (ip.To4() == nil) == (subnet.IP.To4() == nil) && !subnet.Contains(ip) {
+					fieldErrors.add(fmt.Sprintf("%vrequirement.network_list[%v].%v", fieldPrefixes[i], j, field), "%v is not in subnet %v of network %v", address, networkDeclaration.Subnet, networkEntity.Name)
+				}
+			}
+		}
+	}
+	return fieldErrors
+}
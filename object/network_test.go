@@ -0,0 +1,163 @@
+/*
+Copyright (c) 2022 Zhang Zhanpeng <zhangregister@outlook.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package object
+
+import (
+	"testing"
+
+	"github.com/MonteCarloClub/kether/container"
+	"github.com/stretchr/testify/assert"
+)
+
+const testNetworkStackYaml = `
+name: testnet
+kind: stack
+networks:
+  - name: chain-net
+    driver: bridge
+    subnet: 172.28.0.0/16
+    gateway: 172.28.0.1
+objects:
+  - name: bootnode
+    predicate:
+      repository: ethereum/client-go
+    requirement:
+      network_list:
+        - name: chain-net
+          ipv4_address: 172.28.0.10
+          aliases: [boot]
+        - rpc-net
+  - name: validator
+    predicate:
+      repository: ethereum/client-go
+    requirement:
+      network_list:
+        - chain-net
+    networks:
+      - name: rpc-net
+`
+
+func TestParseNetworks(t *testing.T) {
+	stack, err := ParseStack(writeTestYaml(t, testNetworkStackYaml))
+	assert.Nil(t, err)
+	bootnode, validator := stack.KetherObjects[0], stack.KetherObjects[1]
+	assert.Equal(t, []NetworkEntity{{Name: "chain-net", IPv4Address: "172.28.0.10", Aliases: []string{"boot"}}, {Name: "rpc-net"}}, bootnode.Requirement.NetworkList)
+	// 对象中声明的网络对栈中的其他对象同样可见
+	assert.Len(t, bootnode.Networks, 2)
+	assert.Equal(t, "172.28.0.0/16", bootnode.getNetworkDeclaration("chain-net").Subnet)
+	assert.NotNil(t, bootnode.getNetworkDeclaration("rpc-net"))
+	assert.Len(t, validator.Networks, 1)
+
+	fieldErrors, err := ValidateStack(writeTestYaml(t, testNetworkStackYaml+`
+  - name: explorer
+    predicate:
+      repository: ethereum/client-go
+    requirement:
+      network_list:
+        - name: chain-net
+          ipv4_address: 172.29.0.10
+    networks:
+      - name: chain-net
+        subnet: 172.29.0.0/16
+`))
+	assert.Nil(t, err)
+	problems := make([]string, 0, len(fieldErrors))
+	for _, fieldError := range fieldErrors {
+		problems = append(problems, fieldError.Field)
+	}
+	assert.Equal(t, []string{"objects[2].requirement.network_list[0].ipv4_address", "objects[2].networks[0]"}, problems)
+}
+
+func TestValidateExclusiveNetworks(t *testing.T) {
+	fieldErrors, err := ValidateStack(writeTestYaml(t, `
+name: testnet
+kind: stack
+objects:
+  - name: bootnode
+    predicate:
+      repository: ethereum/client-go
+    requirement:
+      publish_list:
+        - 30303:30303
+      network_list:
+        - host
+  - name: validator
+    predicate:
+      repository: ethereum/client-go
+    requirement:
+      network_list:
+        - chain-net
+        - none
+  - name: explorer
+    predicate:
+      repository: ethereum/client-go
+    requirement:
+      network_list:
+        - host
+        - host
+`))
+	assert.Nil(t, err)
+	problems := make([]string, 0, len(fieldErrors))
+	for _, fieldError := range fieldErrors {
+		problems = append(problems, fieldError.Field)
+	}
+	assert.Equal(t, []string{"objects[0].requirement.publish_list", "objects[1].requirement.network_list[1]"}, problems)
+}
+
+func TestDeployNetworks(t *testing.T) {
+	fakeEngine, ctx := newTestEnv(t)
+
+	ketherObject, ketherObjectState := getTestKetherObject(true, true)
+	ketherObject.Requirement.NetworkList = []NetworkEntity{
+		{Name: "chain-net", IPv4Address: "172.28.0.10", Aliases: []string{"boot"}},
+		{Name: "bridge"},
+		{Name: "chain-net"},
+	}
+	_, err := Apply(ctx, ketherObject, ketherObjectState)
+	assert.NotNil(t, err)
+	assert.Equal(t, FAIL_TO_DEPLOY, ketherObjectState.State)
+	assert.Empty(t, filterCalls(fakeEngine.Calls(), "CreateContainer"))
+
+	ketherObject, ketherObjectState = getTestKetherObject(true, true)
+	ketherObject.Requirement.NetworkList = []NetworkEntity{
+		{Name: "chain-net", IPv4Address: "172.28.0.10", Aliases: []string{"boot"}},
+		{Name: "bridge"},
+		{Name: "chain-net"},
+	}
+	ketherObject.Networks = []*NetworkDeclaration{{Name: "chain-net", Driver: "bridge", Subnet: "172.28.0.0/16"}}
+	_, err = Apply(ctx, ketherObject, ketherObjectState)
+	assert.Nil(t, err)
+	networkResource, ok := fakeEngine.Networks["chain-net"]
+	assert.True(t, ok)
+	assert.Equal(t, "bridge", networkResource.Driver)
+	assert.Equal(t, "172.28.0.0/16", networkResource.IPAM.Config[0].Subnet)
+
+	containerJSON, ok, err := container.InspectDockerContainer(ctx, ketherObject.GetContainerName())
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "chain-net", string(containerJSON.HostConfig.NetworkMode))
+	assert.Len(t, containerJSON.NetworkSettings.Networks, 2)
+	assert.Equal(t, "172.28.0.10", containerJSON.NetworkSettings.Networks["chain-net"].IPAMConfig.IPv4Address)
+	assert.Equal(t, []string{"boot"}, containerJSON.NetworkSettings.Networks["chain-net"].Aliases)
+	assert.NotNil(t, containerJSON.NetworkSettings.Networks["bridge"])
+	assert.Len(t, filterCalls(fakeEngine.Calls(), "ConnectNetwork"), 1)
+}
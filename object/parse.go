@@ -22,14 +22,166 @@ THE SOFTWARE.
 package object
 
 import (
+	"bytes"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
 
 	"github.com/MonteCarloClub/kether/log"
 	"gopkg.in/yaml.v2"
 )
 
-func ParseYaml(yamlPath string) (*KetherObject, *KetherObjectState, error) {
+const (
+	KindDeploy = "deploy"
+	KindStack  = "stack"
+)
+
+// stackDocumentEntity 是 YAML 文件中的一个文档，kind 为 stack 时 objects 字段列出栈中的 Kether 对象，否则文档本身是一个 Kether 对象；
+// 两种文档都可以用 networks 字段声明网络
+type stackDocumentEntity struct {
+	KetherObjectEntity `yaml:",inline"`
+	Objects            []KetherObjectEntity `yaml:"objects"`
+}
+
+var (
+	yamlErrorRegexp    = regexp.MustCompile(`^(?:yaml: )?line (\d+): (.*)$`)
+	unknownFieldRegexp = regexp.MustCompile(`^field (\S+) not found in type \S+$`)
+)
+
+// getYamlFieldError 把 yaml 包的 "line N: message" 形式的错误转换为字段错误，字段路径由 locateFieldErrors 补全
+func getYamlFieldError(message string) *FieldError {
+	match := yamlErrorRegexp.FindStringSubmatch(message)
+	if match == nil {
+		return &FieldError{Message: message}
+	}
+	line, _ := strconv.Atoi(match[1])
+	message = match[2]
+	if unknownFieldMatch := unknownFieldRegexp.FindStringSubmatch(message); unknownFieldMatch != nil {
+		message = fmt.Sprintf("unknown field %v", unknownFieldMatch[1])
+	}
+	return &FieldError{
+		Message: message,
+		Line:    line,
+	}
+}
+
+// networkDeclarationField 是声明的网络及其字段路径
+type networkDeclarationField struct {
+	networkDeclarationEntity NetworkDeclarationEntity
+	field                    string
+}
+
+// parseKetherObjectEntities 解析 YAML 文件中的所有 Kether 对象和声明的网络，支持多文档 YAML 和 stack 类型的文档，
+// 返回的字段路径前缀用于指出出错的对象。strict 为 true 时未知字段也是错误，字段类型错误不中断解析，以 FieldErrors 返回
+func parseKetherObjectEntities(yamlBytes []byte, strict bool) ([]KetherObjectEntity, []string, []networkDeclarationField, error) {
+	documents := make([]stackDocumentEntity, 0)
+	fieldErrors := make(FieldErrors, 0)
+	decoder := yaml.NewDecoder(bytes.NewReader(yamlBytes))
+	decoder.SetStrict(strict)
+	for {
+		document := stackDocumentEntity{}
+		err := decoder.Decode(&document)
+		if err == io.EOF {
+			break
+		}
+		if typeError, ok := err.(*yaml.TypeError); ok {
+			for _, message := range typeError.Errors {
+				fieldErrors = append(fieldErrors, getYamlFieldError(message))
+			}
+		} else if err != nil {
+			return nil, nil, nil, err
+		}
+		if isEmptyDocument(document.Name, document.Kind, len(document.Objects), len(document.Networks)) {
+			continue
+		}
+		documents = append(documents, document)
+	}
+
+	ketherObjectEntities := make([]KetherObjectEntity, 0)
+	fieldPrefixes := make([]string, 0)
+	networkDeclarationFields := make([]networkDeclarationField, 0)
+	addNetworkDeclarations := func(networkDeclarationEntities []NetworkDeclarationEntity, prefix string) {
+		for k, networkDeclarationEntity := range networkDeclarationEntities {
+			networkDeclarationFields = append(networkDeclarationFields, networkDeclarationField{networkDeclarationEntity, fmt.Sprintf("%vnetworks[%v]", prefix, k)})
+		}
+	}
+	for i, document := range documents {
+		documentPrefix := getDocumentPrefix(i, len(documents))
+		addNetworkDeclarations(document.Networks, documentPrefix)
+		if document.Kind != KindStack {
+			if len(document.Objects) > 0 {
+				fieldErrors.add(documentPrefix+"objects", "objects are only allowed in documents of kind %v", KindStack)
+			}
+			ketherObjectEntities = append(ketherObjectEntities, document.KetherObjectEntity)
+			fieldPrefixes = append(fieldPrefixes, documentPrefix)
+			continue
+		}
+		for j, ketherObjectEntity := range document.Objects {
+			objectPrefix := fmt.Sprintf("%vobjects[%v].", documentPrefix, j)
+			ketherObjectEntities = append(ketherObjectEntities, ketherObjectEntity)
+			fieldPrefixes = append(fieldPrefixes, objectPrefix)
+			addNetworkDeclarations(ketherObjectEntity.Networks, objectPrefix)
+		}
+	}
+	if len(fieldErrors) > 0 {
+		return ketherObjectEntities, fieldPrefixes, networkDeclarationFields, fieldErrors
+	}
+	return ketherObjectEntities, fieldPrefixes, networkDeclarationFields, nil
+}
+
+// isEmptyDocument 判断文档是否为空，空文档被跳过，如文件末尾的 ---
+func isEmptyDocument(name string, kind string, objectCount int, networkCount int) bool {
+	return name == "" && kind == "" && objectCount == 0 && networkCount == 0
+}
+
+func getDocumentPrefix(index int, documentCount int) string {
+	if documentCount > 1 {
+		return fmt.Sprintf("documents[%v].", index)
+	}
+	return ""
+}
+
+// parseStack 解析并检查 YAML 文件中的 Kether 对象，尽可能多地收集字段错误，以 FieldErrors 返回
+func parseStack(yamlPath string, yamlBytes []byte, strict bool) (*Stack, error) {
+	ketherObjectEntities, fieldPrefixes, networkDeclarationFields, err := parseKetherObjectEntities(yamlBytes, strict)
+	fieldErrors, ok := err.(FieldErrors)
+	if err != nil && !ok {
+		return nil, err
+	}
+	if len(ketherObjectEntities) == 0 && len(fieldErrors) == 0 {
+		return nil, fmt.Errorf("no kether object found in %v", yamlPath)
+	}
+
+	for i := range ketherObjectEntities {
+		err = ketherObjectEntities[i].Validate()
+		if err != nil {
+			fieldErrors = append(fieldErrors, prefixFieldErrors(err.(FieldErrors), fieldPrefixes[i])...)
+		}
+	}
+
+	stack := NewStack()
+	for i := range ketherObjectEntities {
+		ketherObject := ketherObjectEntities[i].GetKetherObject()
+		ketherObject.Dir = filepath.Dir(yamlPath)
+		stack.add(ketherObject, ketherObjectEntities[i].GetKetherObjectState())
+	}
+	err = stack.validate(fieldPrefixes)
+	if err != nil {
+		fieldErrors = append(fieldErrors, err.(FieldErrors)...)
+	}
+	fieldErrors = append(fieldErrors, stack.linkNetworkDeclarations(networkDeclarationFields, fieldPrefixes)...)
+	if len(fieldErrors) > 0 {
+		return nil, fieldErrors
+	}
+	return stack, nil
+}
+
+// ParseStack 解析 YAML 文件中的所有 Kether 对象，检查对象间的依赖关系
+func ParseStack(yamlPath string) (*Stack, error) {
 	ext := filepath.Ext(yamlPath)
 	if ext != ".yaml" && ext != ".yml" {
 		log.Warn("illegal yaml file extension", "yamlPath", yamlPath)
@@ -38,17 +190,55 @@ func ParseYaml(yamlPath string) (*KetherObject, *KetherObjectState, error) {
 	yamlBytes, err := ioutil.ReadFile(yamlPath)
 	if err != nil {
 		log.Error("fail to read yaml file", "yamlPath", yamlPath, "err", err)
-		return nil, nil, err
+		return nil, err
 	}
 
-	ketherObjectEntity := &KetherObjectEntity{}
-	err = yaml.Unmarshal(yamlBytes, &ketherObjectEntity)
+	stack, err := parseStack(yamlPath, yamlBytes, false)
 	if err != nil {
-		log.Error("fail to unmarshal yaml", yamlBytes, string(yamlBytes), "err", err)
-		return nil, nil, err
+		log.Error("invalid kether objects", "yamlPath", yamlPath, "err", err)
+		return nil, err
 	}
+	return stack, nil
+}
 
-	ketherObject := ketherObjectEntity.GetKetherObject()
-	ketherObjectState := ketherObjectEntity.GetKetherObjectState()
-	return ketherObject, ketherObjectState, nil
+// ValidateStack 严格地解析和检查 YAML 文件，未知字段也是错误，返回按位置排序的所有字段错误；
+// 只有文件无法读取时返回 error
+func ValidateStack(yamlPath string) (FieldErrors, error) {
+	yamlBytes, err := ioutil.ReadFile(yamlPath)
+	if err != nil {
+		log.Error("fail to read yaml file", "yamlPath", yamlPath, "err", err)
+		return nil, err
+	}
+
+	_, err = parseStack(yamlPath, yamlBytes, true)
+	if err == nil {
+		return nil, nil
+	}
+	fieldErrors, ok := err.(FieldErrors)
+	if !ok {
+		fieldErrors = FieldErrors{getYamlFieldError(err.Error())}
+	}
+	locateFieldErrors(yamlBytes, fieldErrors)
+	sort.SliceStable(fieldErrors, func(i, j int) bool {
+		if fieldErrors[i].Line != fieldErrors[j].Line {
+			return fieldErrors[i].Line < fieldErrors[j].Line
+		}
+		return fieldErrors[i].Column < fieldErrors[j].Column
+	})
+	return fieldErrors, nil
+}
+
+// ParseYaml 解析只包含一个 Kether 对象的 YAML 文件
+func ParseYaml(yamlPath string) (*KetherObject, *KetherObjectState, error) {
+	stack, err := ParseStack(yamlPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(stack.KetherObjects) != 1 {
+		err = fmt.Errorf("%v kether objects found in %v, expect exactly 1", len(stack.KetherObjects), yamlPath)
+		log.Error("unexpected number of kether objects", "yamlPath", yamlPath, "err", err)
+		return nil, nil, err
+	}
+	ketherObject := stack.KetherObjects[0]
+	return ketherObject, stack.KetherObjectStates[ketherObject.Name], nil
 }
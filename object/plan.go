@@ -0,0 +1,384 @@
+/*
+Copyright (c) 2022 Zhang Zhanpeng <zhangregister@outlook.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package object
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	kethercontainer "github.com/MonteCarloClub/kether/container"
+	"github.com/MonteCarloClub/kether/log"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+)
+
+const (
+	PlanActionCreate   = "create"
+	PlanActionRecreate = "recreate"
+	PlanActionNoop     = "no-op"
+
+	// anyHostPort 表示由 kether 选择主机端口，与任意实际绑定的主机端口一致
+	anyHostPort = "any"
+)
+
+// FieldDiff 是期望配置与容器实际配置的一处差异，Desired 或 Actual 为空表示该项只存在于一方
+type FieldDiff struct {
+	Field   string `json:"field" yaml:"field"`
+	Desired string `json:"desired,omitempty" yaml:"desired,omitempty"`
+	Actual  string `json:"actual,omitempty" yaml:"actual,omitempty"`
+}
+
+// Plan 是部署 Kether 对象时 kether 将执行的动作，以及期望配置与同名容器的差异
+type Plan struct {
+	Name        string       `json:"name" yaml:"name"`
+	Action      string       `json:"action" yaml:"action"`
+	ContainerID string       `json:"container_id,omitempty" yaml:"container_id,omitempty"`
+	Diffs       []*FieldDiff `json:"diffs" yaml:"diffs"`
+}
+
+// containerSpec 是用于比较的容器配置，除镜像和状态外的配置项都以名称索引
+type containerSpec struct {
+	Image    string
+	Status   string
+	Env      map[string]string
+	Ports    map[string]string
+	Mounts   map[string]string
+	Networks map[string]string
+	Limits   map[string]string
+}
+
+func newContainerSpec() *containerSpec {
+	return &containerSpec{
+		Env:      make(map[string]string),
+		Ports:    make(map[string]string),
+		Mounts:   make(map[string]string),
+		Networks: make(map[string]string),
+		Limits:   make(map[string]string),
+	}
+}
+
+func getEnvKey(env string) string {
+	return strings.SplitN(env, "=", 2)[0]
+}
+
+// getPortKey 返回 port/protocol 形式的容器端口，缺省协议为 tcp
+func getPortKey(containerPort string) string {
+	if !strings.Contains(containerPort, "/") {
+		return containerPort + "/tcp"
+	}
+	return containerPort
+}
+
+func getRestartPolicyString(restartPolicy container.RestartPolicy) string {
+	if restartPolicy.Name == "" {
+		return "no"
+	}
+	if restartPolicy.MaximumRetryCount > 0 {
+		return fmt.Sprintf("%v:%v", restartPolicy.Name, restartPolicy.MaximumRetryCount)
+	}
+	return restartPolicy.Name
+}
+
+// setLimits 记录资源限制，取值为 0 的限制表示不限制，不记录；memory_swap 和 shm_size 在未指定时由 Docker 决定，只在 desired 中指定时比较
+func (spec *containerSpec) setLimits(resources container.Resources, restartPolicy container.RestartPolicy, shmSize int64, desired *containerSpec) {
+	setLimit := func(key string, value int64) {
+		if value != 0 {
+			spec.Limits[key] = strconv.FormatInt(value, 10)
+		}
+	}
+	if resources.NanoCPUs != 0 {
+		spec.Limits["cpus"] = strconv.FormatFloat(float64(resources.NanoCPUs)/1e9, 'f', -1, 64)
+	}
+	setLimit("cpu_shares", resources.CPUShares)
+	if resources.CpusetCpus != "" {
+		spec.Limits["cpuset"] = resources.CpusetCpus
+	}
+	setLimit("memory", resources.Memory)
+	if resources.PidsLimit != nil {
+		setLimit("pids_limit", *resources.PidsLimit)
+	}
+	ulimits := make([]string, 0, len(resources.Ulimits))
+	for _, ulimit := range resources.Ulimits {
+		ulimits = append(ulimits, ulimit.String())
+	}
+	if len(ulimits) > 0 {
+		sort.Strings(ulimits)
+		spec.Limits["ulimits"] = strings.Join(ulimits, ",")
+	}
+	spec.Limits["restart_policy"] = getRestartPolicyString(restartPolicy)
+	if desired == nil || desired.Limits["memory_swap"] != "" {
+		setLimit("memory_swap", resources.MemorySwap)
+	}
+	if desired == nil || desired.Limits["shm_size"] != "" {
+		setLimit("shm_size", shmSize)
+	}
+}
+
+// getDesiredContainerSpec 由 YAML 计算期望的容器配置，端口取 publish_list 中指定的主机端口，而不是部署时实际选择的端口
+func (ketherObject *KetherObject) getDesiredContainerSpec(ctx context.Context) (*containerSpec, error) {
+	spec := newContainerSpec()
+	imageName, err := ketherObject.GetImageName(ctx)
+	if err != nil {
+		return nil, err
+	}
+	spec.Image = imageName
+	if ketherObject.Requirement.Detach {
+		spec.Status = "running"
+	}
+
+	env, err := ketherObject.getEnv()
+	if err != nil {
+		return nil, err
+	}
+	// 依赖的对象还没有部署时，引用其端口的变量保持原样
+	env, _ = resolvePeerPorts(ctx, env, false)
+	for _, envEntry := range env {
+		spec.Env[getEnvKey(envEntry)] = envEntry
+	}
+
+	hostPorts := make(map[string][]string)
+	for _, publishEntity := range ketherObject.Requirement.PublishList {
+		portMappings, err := parsePublish(publishEntity.Port)
+		if err != nil {
+			continue
+		}
+		for _, portMapping := range portMappings {
+			key := string(portMapping.Port)
+			portBinding := portMapping.Binding
+			// 按 remap 或 random 策略部署的容器可能绑定了其他主机端口
+			if publishEntity.getPolicy() != PortPolicyStrict {
+				portBinding.HostPort = ""
+			}
+			hostPorts[key] = append(hostPorts[key], formatHostBinding(portBinding))
+		}
+	}
+	for key, ports := range hostPorts {
+		sort.Strings(ports)
+		spec.Ports[key] = strings.Join(getUniqueNames(ports), ",")
+	}
+
+	mounts, binds, err := ketherObject.getMounts()
+	if err != nil {
+		return nil, err
+	}
+	for _, dockerMount := range mounts {
+		spec.Mounts[dockerMount.Target] = formatMount(dockerMount)
+	}
+	for _, bind := range binds {
+		spec.Mounts[parseVolume(bind).Target] = formatBind(bind)
+	}
+	for _, networkEntity := range ketherObject.getNetworks() {
+		spec.Networks[networkEntity.Name] = formatNetwork(networkEntity)
+	}
+
+	resources, fieldErrors := getHostResources(RunDescriptionEntity(*ketherObject.Requirement))
+	if len(fieldErrors) > 0 {
+		return nil, fieldErrors
+	}
+	spec.setLimits(resources.Resources, resources.RestartPolicy, resources.ShmSize, nil)
+	return spec, nil
+}
+
+// getActualContainerSpec 由容器详情计算实际的容器配置，镜像自带的环境变量不计入
+func getActualContainerSpec(ctx context.Context, containerJSON types.ContainerJSON, desired *containerSpec) (*containerSpec, error) {
+	spec := newContainerSpec()
+	if containerJSON.State != nil {
+		spec.Status = containerJSON.State.Status
+	}
+	if desired.Status == "" {
+		spec.Status = ""
+	}
+
+	imageEnv := make(map[string]bool)
+	if containerJSON.Config != nil {
+		spec.Image = containerJSON.Config.Image
+		// 以摘要创建的容器与 YAML 中的镜像名比较
+		if imageName := containerJSON.Config.Labels[ImageLabel]; imageName != "" {
+			spec.Image = imageName
+		}
+		if containerJSON.Image != "" {
+			imageInspect, ok, err := kethercontainer.InspectDockerImage(ctx, containerJSON.Image)
+			if err != nil {
+				return nil, err
+			}
+			if ok && imageInspect.Config != nil {
+				for _, envEntry := range imageInspect.Config.Env {
+					imageEnv[envEntry] = true
+				}
+			}
+		}
+		for _, envEntry := range containerJSON.Config.Env {
+			if !imageEnv[envEntry] {
+				spec.Env[getEnvKey(envEntry)] = envEntry
+			}
+		}
+	}
+
+	if containerJSON.ContainerJSONBase != nil && containerJSON.HostConfig != nil {
+		hostConfig := containerJSON.HostConfig
+		for containerPort, portBindings := range hostConfig.PortBindings {
+			ports := make([]string, 0, len(portBindings))
+			for _, portBinding := range portBindings {
+				ports = append(ports, formatHostBinding(portBinding))
+			}
+			sort.Strings(ports)
+			spec.Ports[getPortKey(string(containerPort))] = strings.Join(ports, ",")
+		}
+		for _, dockerMount := range hostConfig.Mounts {
+			spec.Mounts[dockerMount.Target] = formatMount(dockerMount)
+		}
+		for _, bind := range hostConfig.Binds {
+			spec.Mounts[parseVolume(bind).Target] = formatBind(bind)
+		}
+		spec.setLimits(hostConfig.Resources, hostConfig.RestartPolicy, hostConfig.ShmSize, desired)
+	}
+
+	if containerJSON.NetworkSettings != nil {
+		for networkName, endpointSettings := range containerJSON.NetworkSettings.Networks {
+			// 没有指定网络的容器连接到缺省的 bridge 网络
+			if len(desired.Networks) == 0 && networkName == "bridge" {
+				continue
+			}
+			spec.Networks[networkName] = formatNetwork(getActualNetwork(containerJSON.ID, networkName, endpointSettings))
+		}
+	}
+	return spec, nil
+}
+
+// getActualNetwork 由容器在网络中的端点计算实际的网络配置，Docker 自动添加的容器短 ID 别名不计入
+func getActualNetwork(id string, networkName string, endpointSettings *network.EndpointSettings) NetworkEntity {
+	networkEntity := NetworkEntity{
+		Name: networkName,
+	}
+	if endpointSettings == nil {
+		return networkEntity
+	}
+	if endpointSettings.IPAMConfig != nil {
+		networkEntity.IPv4Address = endpointSettings.IPAMConfig.IPv4Address
+		networkEntity.IPv6Address = endpointSettings.IPAMConfig.IPv6Address
+	}
+	for _, alias := range endpointSettings.Aliases {
+		if len(id) < 12 || alias != id[:12] {
+			networkEntity.Aliases = append(networkEntity.Aliases, alias)
+		}
+	}
+	networkEntity.Links = endpointSettings.Links
+	return networkEntity
+}
+
+func (plan *Plan) add(field string, desired string, actual string) {
+	if desired != actual {
+		plan.Diffs = append(plan.Diffs, &FieldDiff{
+			Field:   field,
+			Desired: desired,
+			Actual:  actual,
+		})
+	}
+}
+
+// addMap 按名称顺序比较两组配置项，matches 判断两组都有的配置项取值是否一致，为 nil 时要求相等
+func (plan *Plan) addMap(field string, desired map[string]string, actual map[string]string, matches func(desired string, actual string) bool) {
+	keys := make([]string, 0, len(desired)+len(actual))
+	for key := range desired {
+		keys = append(keys, key)
+	}
+	for key := range actual {
+		if _, ok := desired[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		desiredValue, desiredOk := desired[key]
+		actualValue, actualOk := actual[key]
+		if matches != nil && desiredOk && actualOk && matches(desiredValue, actualValue) {
+			continue
+		}
+		plan.add(fmt.Sprintf("%v.%v", field, key), desiredValue, actualValue)
+	}
+}
+
+func (plan *Plan) diff(desired *containerSpec, actual *containerSpec) {
+	plan.add("image", desired.Image, actual.Image)
+	plan.add("status", desired.Status, actual.Status)
+	plan.addMap("env", desired.Env, actual.Env, nil)
+	plan.addMap("ports", desired.Ports, actual.Ports, matchHostBindings)
+	plan.addMap("mounts", desired.Mounts, actual.Mounts, nil)
+	plan.addMap("networks", desired.Networks, actual.Networks, nil)
+	plan.addMap("limits", desired.Limits, actual.Limits, nil)
+}
+
+// GetPlan 比较 Kether 对象的期望配置与同名容器的实际配置：容器不存在时创建，存在差异时重建，否则不做改动
+func GetPlan(ctx context.Context, ketherObject *KetherObject) (*Plan, error) {
+	plan := &Plan{
+		Name:  ketherObject.Name,
+		Diffs: make([]*FieldDiff, 0),
+	}
+	desired, err := ketherObject.getDesiredContainerSpec(ctx)
+	if err != nil {
+		log.Error("fail to get desired container config", "name", ketherObject.Name, "err", err)
+		return nil, err
+	}
+
+	containerJSON, ok, err := kethercontainer.InspectDockerContainer(ctx, ketherObject.GetContainerName())
+	if err != nil {
+		log.Error("fail to inspect container of kether object", "name", ketherObject.Name, "err", err)
+		return nil, err
+	}
+	actual := newContainerSpec()
+	if ok {
+		plan.ContainerID = containerJSON.ID
+		actual, err = getActualContainerSpec(ctx, containerJSON, desired)
+		if err != nil {
+			log.Error("fail to get actual container config", "name", ketherObject.Name, "err", err)
+			return nil, err
+		}
+	}
+	plan.diff(desired, actual)
+
+	switch {
+	case !ok:
+		plan.Action = PlanActionCreate
+	case len(plan.Diffs) > 0:
+		plan.Action = PlanActionRecreate
+	default:
+		plan.Action = PlanActionNoop
+	}
+	return plan, nil
+}
+
+// GetStackPlans 按栈中对象的顺序返回每个对象的计划
+func GetStackPlans(ctx context.Context, stack *Stack) ([]*Plan, error) {
+	plans := make([]*Plan, 0, len(stack.KetherObjects))
+	for _, ketherObject := range stack.KetherObjects {
+		plan, err := GetPlan(ctx, ketherObject)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %v", ketherObject.Name, err)
+		}
+		plans = append(plans, plan)
+	}
+	return plans, nil
+}
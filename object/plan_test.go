@@ -0,0 +1,78 @@
+/*
+Copyright (c) 2022 Zhang Zhanpeng <zhangregister@outlook.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package object
+
+import (
+	"testing"
+
+	"github.com/MonteCarloClub/kether/container"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetPlan(t *testing.T) {
+	_, ctx := newTestEnv(t)
+
+	ketherObject, ketherObjectState := getTestKetherObject(true, true)
+	ketherObject.Requirement.Env = []string{"NETWORK_ID=1337"}
+	ketherObject.Requirement.PublishList = []PublishEntity{{Port: "8080:80"}, {Port: ":8545"}}
+	ketherObject.Requirement.NetworkList = []NetworkEntity{{Name: "kether-net"}}
+	ketherObject.Networks = []*NetworkDeclaration{{Name: "kether-net", Driver: "bridge", Subnet: "172.28.0.0/16"}}
+	ketherObject.Requirement.Memory = "512m"
+
+	plan, err := GetPlan(ctx, ketherObject)
+	assert.Nil(t, err)
+	assert.Equal(t, PlanActionCreate, plan.Action)
+	assert.Contains(t, plan.Diffs, &FieldDiff{Field: "image", Desired: testImageName})
+	assert.Contains(t, plan.Diffs, &FieldDiff{Field: "ports.8545/tcp", Desired: anyHostPort})
+
+	assert.Nil(t, registerKetherObject(ctx, ketherObjectState))
+	assert.Nil(t, Deploy(ctx, ketherObject, ketherObjectState))
+	ketherObjectState.Unlock(ctx)
+	plan, err = GetPlan(ctx, ketherObject)
+	assert.Nil(t, err)
+	assert.Equal(t, PlanActionNoop, plan.Action)
+	assert.Empty(t, plan.Diffs)
+	assert.NotEmpty(t, plan.ContainerID)
+
+	ketherObject.Requirement.Env = []string{"NETWORK_ID=1", "VERBOSITY=3"}
+	ketherObject.Requirement.PublishList = []PublishEntity{{Port: "8081:80"}, {Port: ":8545"}}
+	ketherObject.Requirement.Memory = ""
+	plan, err = GetPlan(ctx, ketherObject)
+	assert.Nil(t, err)
+	assert.Equal(t, PlanActionRecreate, plan.Action)
+	assert.Equal(t, []*FieldDiff{
+		{Field: "env.NETWORK_ID", Desired: "NETWORK_ID=1", Actual: "NETWORK_ID=1337"},
+		{Field: "env.VERBOSITY", Desired: "VERBOSITY=3"},
+		{Field: "ports.80/tcp", Desired: "8081", Actual: "8080"},
+		{Field: "limits.memory", Actual: "536870912"},
+	}, plan.Diffs)
+
+	_, err = container.StopDockerContainer(ctx, ketherObject.GetContainerName())
+	assert.Nil(t, err)
+	ketherObject.Requirement.Env = []string{"NETWORK_ID=1337"}
+	ketherObject.Requirement.PublishList = []PublishEntity{{Port: "8080:80"}, {Port: ":8545"}}
+	ketherObject.Requirement.Memory = "512m"
+	plan, err = GetPlan(ctx, ketherObject)
+	assert.Nil(t, err)
+	assert.Equal(t, PlanActionRecreate, plan.Action)
+	assert.Equal(t, []*FieldDiff{{Field: "status", Desired: "running", Actual: "exited"}}, plan.Diffs)
+}
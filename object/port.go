@@ -0,0 +1,476 @@
+/*
+Copyright (c) 2022 Zhang Zhanpeng <zhangregister@outlook.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package object
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/MonteCarloClub/kether/container"
+	"github.com/MonteCarloClub/kether/flag"
+	"github.com/MonteCarloClub/kether/log"
+	"github.com/MonteCarloClub/kether/machine"
+	"github.com/MonteCarloClub/kether/registry"
+	"github.com/docker/go-connections/nat"
+)
+
+const (
+	// PortPolicyStrict 是缺省的端口策略，主机端口被占用时部署失败，并给出占用端口的对象、容器或进程
+	PortPolicyStrict = "strict"
+	// PortPolicyRemap 在主机端口被占用时从 registry.PortRanges 中分配其他端口
+	PortPolicyRemap = "remap"
+	// PortPolicyRandom 在主机端口被占用时由 Docker 选择随机的主机端口
+	PortPolicyRandom = "random"
+)
+
+// PublishEntity 是 publish_list 中的一个端口映射，可写成 `docker run -p` 形式的字符串或映射，
+// policy 指定主机端口被占用时的处理方式，缺省为 strict
+type PublishEntity struct {
+	Port   string `yaml:"port"`
+	Policy string `yaml:"policy"`
+}
+
+func (publishEntity *PublishEntity) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var portSpec string
+	if err := unmarshal(&portSpec); err == nil {
+		*publishEntity = PublishEntity{
+			Port: portSpec,
+		}
+		return nil
+	}
+
+	type plainPublishEntity PublishEntity
+	return unmarshal((*plainPublishEntity)(publishEntity))
+}
+
+func (publishEntity PublishEntity) getPolicy() string {
+	if publishEntity.Policy == "" {
+		return PortPolicyStrict
+	}
+	return publishEntity.Policy
+}
+
+// checkPortPolicy 检查端口策略是否为 strict、remap 或 random
+func checkPortPolicy(policy string) error {
+	switch policy {
+	case "", PortPolicyStrict, PortPolicyRemap, PortPolicyRandom:
+		return nil
+	default:
+		return fmt.Errorf("unknown port policy %q, expect one of %v, %v and %v", policy, PortPolicyStrict, PortPolicyRemap, PortPolicyRandom)
+	}
+}
+
+// parsePublish 解析 `docker run -p` 形式的端口映射 [host_ip:][host_port[-host_port]:]container_port[-container_port][/protocol]，
+// 端口范围展开为逐个端口的映射；主机端口为空时由 Docker 选择随机端口，主机端口是范围而容器端口不是时由 Docker 在范围内选择
+func parsePublish(portSpec string) ([]nat.PortMapping, error) {
+	portMappings, err := nat.ParsePortSpec(portSpec)
+	if err != nil {
+		return nil, fmt.Errorf("%q should be in the form of [host_ip:][host_port:]container_port[/protocol]: %v", portSpec, err)
+	}
+	for _, portMapping := range portMappings {
+		if portMapping.Port.Int() == 0 {
+			return nil, fmt.Errorf("invalid container port in %q: 0 is not a port number between 1 and 65535", portSpec)
+		}
+		if portMapping.Binding.HostPort == "" {
+			continue
+		}
+		startHostPort, _, err := nat.ParsePortRange(portMapping.Binding.HostPort)
+		if err != nil || startHostPort == 0 {
+			return nil, fmt.Errorf("invalid host port in %q: %v is not a port number between 1 and 65535", portSpec, portMapping.Binding.HostPort)
+		}
+	}
+	return portMappings, nil
+}
+
+// isHostPortRange 判断主机端口是否为由 Docker 在其中选择端口的范围
+func isHostPortRange(hostPort string) bool {
+	return strings.Contains(hostPort, "-")
+}
+
+// isAnyHostIP 判断主机 IP 是否表示主机的所有地址
+func isAnyHostIP(hostIP string) bool {
+	return hostIP == "" || hostIP == "0.0.0.0" || hostIP == "::"
+}
+
+// formatHostBinding 以 [host_ip:]host_port 的形式描述主机端口，由 Docker 选择的端口记为 anyHostPort
+func formatHostBinding(portBinding nat.PortBinding) string {
+	hostPort := portBinding.HostPort
+	if hostPort == "" {
+		hostPort = anyHostPort
+	}
+	if isAnyHostIP(portBinding.HostIP) {
+		return hostPort
+	}
+	return net.JoinHostPort(portBinding.HostIP, hostPort)
+}
+
+// matchHostBindings 判断实际绑定的主机端口是否满足期望：指定的端口必须一致，anyHostPort 与同一主机 IP 上的任意端口一致
+func matchHostBindings(desired string, actual string) bool {
+	desiredBindings, actualBindings := strings.Split(desired, ","), strings.Split(actual, ",")
+	if len(desiredBindings) != len(actualBindings) {
+		return false
+	}
+	remaining := make(map[string]int, len(actualBindings))
+	for _, actualBinding := range actualBindings {
+		remaining[actualBinding]++
+	}
+	wildcards := make([]string, 0)
+	for _, desiredBinding := range desiredBindings {
+		if strings.HasSuffix(desiredBinding, anyHostPort) {
+			wildcards = append(wildcards, strings.TrimSuffix(desiredBinding, anyHostPort))
+			continue
+		}
+		if remaining[desiredBinding] == 0 {
+			return false
+		}
+		remaining[desiredBinding]--
+	}
+	for _, hostIPPrefix := range wildcards {
+		matched := false
+		for actualBinding, count := range remaining {
+			if count > 0 && strings.HasPrefix(actualBinding, hostIPPrefix) && (hostIPPrefix != "" || !strings.Contains(actualBinding, ":")) {
+				remaining[actualBinding]--
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// getPortBindings 由 publish_list 计算容器暴露的端口和端口绑定；指定的主机端口在注册表中预留，
+// 被其他对象预留或被主机上的其他进程占用时按端口策略处理
+func (ketherObject *KetherObject) getPortBindings(ctx context.Context) (nat.PortSet, nat.PortMap, error) {
+	exposedPorts := make(nat.PortSet)
+	portBindings := make(nat.PortMap)
+	ketherObject.portReservations = nil
+	host := ""
+	assignments := &hostPortAssignments{
+		requested: make(map[string]bool),
+		assigned:  make(map[string][]nat.PortMapping),
+	}
+	for _, publishEntity := range ketherObject.Requirement.PublishList {
+		portMappings, err := parsePublish(publishEntity.Port)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, portMapping := range portMappings {
+			if hostPort := portMapping.Binding.HostPort; hostPort != "" && !isHostPortRange(hostPort) {
+				assignments.requested[getHostPortKey(hostPort, portMapping.Port.Proto())] = true
+			}
+		}
+	}
+	for _, publishEntity := range ketherObject.Requirement.PublishList {
+		portMappings, err := parsePublish(publishEntity.Port)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, portMapping := range portMappings {
+			exposedPorts[portMapping.Port] = struct{}{}
+			portBinding := portMapping.Binding
+			if isDuplicatePortBinding(portBindings[portMapping.Port], portBinding) {
+				continue
+			}
+			if portBinding.HostPort != "" && !isHostPortRange(portBinding.HostPort) {
+				if host == "" {
+					host = container.GetDockerHostName(ctx)
+				}
+				portBinding.HostPort, err = ketherObject.reserveHostPort(ctx, host, portMapping, publishEntity.getPolicy(), assignments)
+				if err != nil {
+					return nil, nil, err
+				}
+				if portBinding.HostPort != "" {
+					assignments.add(nat.PortMapping{Port: portMapping.Port, Binding: portBinding})
+				}
+			}
+			portBindings[portMapping.Port] = append(portBindings[portMapping.Port], portBinding)
+		}
+	}
+	return exposedPorts, portBindings, nil
+}
+
+// reserveHostPort 在主机上为对象预留指定的主机端口，端口不可用时按策略失败、分配其他端口或交由 Docker 选择；
+// 试运行时只检查本机端口，不修改注册表
+func (ketherObject *KetherObject) reserveHostPort(ctx context.Context, host string, portMapping nat.PortMapping, policy string, assignments *hostPortAssignments) (string, error) {
+	hostPort, protocol := portMapping.Binding.HostPort, portMapping.Port.Proto()
+	port, err := strconv.Atoi(hostPort)
+	if err != nil {
+		return "", err
+	}
+	isAvailable := func(port int) bool {
+		// 远程引擎的端口无法在本机探测，只依赖注册表中的预留
+		return container.IsRemoteDockerEngine() || machine.IsHostPortAvailable(port, protocol)
+	}
+	contextVal, _ := ctx.Value(flag.ContextKey).(flag.ContextValType)
+	if contextVal.DryRun {
+		if isAvailable(port) {
+			return hostPort, nil
+		}
+		if policy == PortPolicyStrict {
+			holder, _ := ketherObject.getHostPortHolder(ctx, port, protocol, isAvailable)
+			return "", getHostPortInUseError(host, port, protocol, holder)
+		}
+		log.Warn("specified host port unavailable, another port will be used", "host", host, "port", port, "protocol", protocol, "policy", policy)
+		return hostPort, nil
+	}
+
+	holder := ""
+	if assignedPortMapping, ok := assignments.getConflict(portMapping); ok {
+		// 端口已经分配给本对象的其他容器端口，如重新映射到的端口
+		holder = fmt.Sprintf("container port %v of kether object %v", assignedPortMapping.Port, ketherObject.Name)
+	} else {
+		current, ok, err := registry.ReservePortOfName(ctx, ketherObject.Name, host, port, protocol)
+		if err != nil {
+			return "", err
+		}
+		if !ok {
+			holder = fmt.Sprintf("kether object %v", current.Name)
+		} else {
+			holder, err = ketherObject.getHostPortHolder(ctx, port, protocol, isAvailable)
+			if err != nil {
+				return "", err
+			}
+			if holder == "" {
+				ketherObject.portReservations = append(ketherObject.portReservations, current)
+				return hostPort, nil
+			}
+			// 端口被其他容器或进程占用，不再为本对象保留
+			err = registry.ReleasePortOfName(ctx, ketherObject.Name, host, port, protocol)
+			if err != nil {
+				return "", err
+			}
+		}
+	}
+
+	switch policy {
+	case PortPolicyRemap:
+		altPort, err := registry.AllocatePortOfName(ctx, ketherObject.Name, host, protocol, isAvailable, assignments.getExcludedPorts(protocol))
+		if err != nil {
+			log.Error("fail to allocate alternate host port", "host", host, "unavailable host port", port, "protocol", protocol, "err", err)
+			return "", err
+		}
+		log.Warn("specified host port unavailable, host port remapped", "specified host port", port, "alternate host port", altPort, "holder", holder)
+		ketherObject.portReservations = append(ketherObject.portReservations, registry.PortReservation{Host: host, Port: altPort, Protocol: protocol, Name: ketherObject.Name})
+		return strconv.Itoa(altPort), nil
+	case PortPolicyRandom:
+		log.Warn("specified host port unavailable, random host port chosen by docker", "specified host port", port, "holder", holder)
+		return "", nil
+	default:
+		return "", getHostPortInUseError(host, port, protocol, holder)
+	}
+}
+
+// hostPortAssignments 记录一次计算端口绑定时 publish_list 指定的主机端口和已经分配的端口映射，以 host_port/protocol 索引
+type hostPortAssignments struct {
+	requested map[string]bool
+	assigned  map[string][]nat.PortMapping
+}
+
+func getHostPortKey(hostPort string, protocol string) string {
+	return fmt.Sprintf("%v/%v", hostPort, protocol)
+}
+
+func (assignments *hostPortAssignments) add(portMapping nat.PortMapping) {
+	key := getHostPortKey(portMapping.Binding.HostPort, portMapping.Port.Proto())
+	assignments.assigned[key] = append(assignments.assigned[key], portMapping)
+}
+
+// getConflict 返回已经分配了同一主机地址和端口的其他容器端口
+func (assignments *hostPortAssignments) getConflict(portMapping nat.PortMapping) (nat.PortMapping, bool) {
+	key := getHostPortKey(portMapping.Binding.HostPort, portMapping.Port.Proto())
+	assignedPortMapping, ok := getOverlappingPortMapping(assignments.assigned[key], portMapping)
+	return assignedPortMapping, ok && assignedPortMapping.Port != portMapping.Port
+}
+
+// getExcludedPorts 返回重新映射时不能分配的端口：已经分配的端口和 publish_list 中指定的主机端口
+func (assignments *hostPortAssignments) getExcludedPorts(protocol string) map[int]bool {
+	exclude := make(map[int]bool)
+	for key := range assignments.requested {
+		if port := nat.Port(key); port.Proto() == protocol {
+			exclude[port.Int()] = true
+		}
+	}
+	for key := range assignments.assigned {
+		if port := nat.Port(key); port.Proto() == protocol {
+			exclude[port.Int()] = true
+		}
+	}
+	return exclude
+}
+
+func getHostPortInUseError(host string, port int, protocol string, holder string) error {
+	err := fmt.Errorf("host port %v/%v on %v is used by %v, free it or set the policy of the port to %v or %v", port, protocol, host, holder, PortPolicyRemap, PortPolicyRandom)
+	log.Error("specified host port unavailable", "host", host, "port", port, "protocol", protocol, "holder", holder, "err", err)
+	return err
+}
+
+// getHostPortHolder 描述占用主机端口的其他容器或本机进程，端口可用时返回空字符串；
+// 远程引擎上的端口只能通过容器发布的端口发现
+func (ketherObject *KetherObject) getHostPortHolder(ctx context.Context, port int, protocol string, isAvailable func(port int) bool) (string, error) {
+	containerName, err := container.FindDockerContainerOfHostPort(ctx, port, protocol)
+	if err != nil {
+		return "", err
+	}
+	if containerName != "" && containerName != ketherObject.GetContainerName() {
+		return fmt.Sprintf("container %v", containerName), nil
+	}
+	if isAvailable(port) {
+		return "", nil
+	}
+	if process, ok := machine.GetHostPortProcess(port, protocol); ok {
+		return fmt.Sprintf("process %v", process), nil
+	}
+	return "another process", nil
+}
+
+// getActualPortBindings 查询容器实际绑定的主机端口，并在注册表中预留由 Docker 选择的端口，使其他对象不再使用
+func (ketherObject *KetherObject) getActualPortBindings(ctx context.Context, id string) ([]registry.PortBinding, error) {
+	if len(ketherObject.Requirement.PublishList) == 0 {
+		return nil, nil
+	}
+	containerJSON, ok, err := container.InspectDockerContainer(ctx, id)
+	if err != nil || !ok || containerJSON.NetworkSettings == nil {
+		return nil, err
+	}
+	actualPortBindings := make([]registry.PortBinding, 0)
+	reserved := make(map[string]bool, len(ketherObject.portReservations))
+	for _, portReservation := range ketherObject.portReservations {
+		reserved[fmt.Sprintf("%v/%v", portReservation.Port, portReservation.Protocol)] = true
+	}
+	host := ""
+	for port, portBindings := range containerJSON.NetworkSettings.Ports {
+		for _, portBinding := range portBindings {
+			actualPortBindings = append(actualPortBindings, registry.PortBinding{
+				ContainerPort: string(port),
+				HostIP:        portBinding.HostIP,
+				HostPort:      portBinding.HostPort,
+			})
+			hostPort, err := strconv.Atoi(portBinding.HostPort)
+			if err != nil || reserved[fmt.Sprintf("%v/%v", hostPort, port.Proto())] {
+				continue
+			}
+			if host == "" {
+				host = container.GetDockerHostName(ctx)
+			}
+			current, ok, err := registry.ReservePortOfName(ctx, ketherObject.Name, host, hostPort, port.Proto())
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				ketherObject.portReservations = append(ketherObject.portReservations, current)
+			}
+			reserved[fmt.Sprintf("%v/%v", hostPort, port.Proto())] = true
+		}
+	}
+	sort.Slice(actualPortBindings, func(i, j int) bool {
+		if actualPortBindings[i].ContainerPort != actualPortBindings[j].ContainerPort {
+			return actualPortBindings[i].ContainerPort < actualPortBindings[j].ContainerPort
+		}
+		return actualPortBindings[i].HostIP < actualPortBindings[j].HostIP
+	})
+	return actualPortBindings, nil
+}
+
+// formatPortBinding 以 `docker ps` 的形式描述端口绑定，如 0.0.0.0:8080->80/tcp
+func formatPortBinding(portBinding registry.PortBinding) string {
+	hostIP := portBinding.HostIP
+	if hostIP == "" {
+		hostIP = "0.0.0.0"
+	}
+	return fmt.Sprintf("%v->%v", net.JoinHostPort(hostIP, portBinding.HostPort), portBinding.ContainerPort)
+}
+
+// resolvePeerPorts 解析环境变量值中的 {{ port "name" "8545/tcp" }}，替换为对象 name 最近一次部署时容器端口实际绑定的主机端口，
+// 协议缺省为 tcp。strict 为 false 时无法解析的值保持原样，用于计划
+func resolvePeerPorts(ctx context.Context, env []string, strict bool) ([]string, error) {
+	resolvedEnv := make([]string, 0, len(env))
+	for _, envEntry := range env {
+		if !strings.Contains(envEntry, "{{") {
+			resolvedEnv = append(resolvedEnv, envEntry)
+			continue
+		}
+		resolvedEntry, err := resolvePeerPort(ctx, envEntry)
+		if err != nil {
+			if strict {
+				return nil, fmt.Errorf("fail to resolve %q: %v", envEntry, err)
+			}
+			resolvedEntry = envEntry
+		}
+		resolvedEnv = append(resolvedEnv, resolvedEntry)
+	}
+	return resolvedEnv, nil
+}
+
+func resolvePeerPort(ctx context.Context, envEntry string) (string, error) {
+	envTemplate, err := template.New("env").Option("missingkey=error").Funcs(template.FuncMap{
+		"port": func(name string, containerPort string) (string, error) {
+			if !strings.Contains(containerPort, "/") {
+				containerPort += "/tcp"
+			}
+			portBinding, ok, err := registry.GetPublishedPortOfName(ctx, name, containerPort)
+			if err != nil {
+				return "", err
+			}
+			if !ok {
+				return "", fmt.Errorf("kether object %v has not published container port %v", name, containerPort)
+			}
+			return portBinding.HostPort, nil
+		},
+	}).Parse(envEntry)
+	if err != nil {
+		return "", err
+	}
+	builder := &strings.Builder{}
+	err = envTemplate.Execute(builder, nil)
+	if err != nil {
+		return "", err
+	}
+	return builder.String(), nil
+}
+
+// checkPeerPorts 检查环境变量值中的模板语法，引用的对象在部署时才解析
+func checkPeerPorts(envEntry string) error {
+	if !strings.Contains(envEntry, "{{") {
+		return nil
+	}
+	_, err := template.New("env").Funcs(template.FuncMap{
+		"port": func(string, string) string { return "" },
+	}).Parse(envEntry)
+	return err
+}
+
+func isDuplicatePortBinding(portBindings []nat.PortBinding, portBinding nat.PortBinding) bool {
+	for _, existingPortBinding := range portBindings {
+		if existingPortBinding == portBinding {
+			return true
+		}
+	}
+	return false
+}
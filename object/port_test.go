@@ -0,0 +1,330 @@
+/*
+Copyright (c) 2022 Zhang Zhanpeng <zhangregister@outlook.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package object
+
+import (
+	"testing"
+
+	"github.com/MonteCarloClub/kether/registry"
+	"github.com/docker/go-connections/nat"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParsePublish(t *testing.T) {
+	testCases := []struct {
+		name             string
+		portSpec         string
+		expectedMappings []nat.PortMapping
+	}{
+		{
+			name:     "host port",
+			portSpec: "8080:80",
+			expectedMappings: []nat.PortMapping{
+				{Port: "80/tcp", Binding: nat.PortBinding{HostPort: "8080"}},
+			},
+		},
+		{
+			name:     "container port only",
+			portSpec: "8545",
+			expectedMappings: []nat.PortMapping{
+				{Port: "8545/tcp", Binding: nat.PortBinding{}},
+			},
+		},
+		{
+			name:     "empty host port",
+			portSpec: ":8545",
+			expectedMappings: []nat.PortMapping{
+				{Port: "8545/tcp", Binding: nat.PortBinding{}},
+			},
+		},
+		{
+			name:     "udp",
+			portSpec: "30303:30303/udp",
+			expectedMappings: []nat.PortMapping{
+				{Port: "30303/udp", Binding: nat.PortBinding{HostPort: "30303"}},
+			},
+		},
+		{
+			name:     "sctp",
+			portSpec: "9000:9000/sctp",
+			expectedMappings: []nat.PortMapping{
+				{Port: "9000/sctp", Binding: nat.PortBinding{HostPort: "9000"}},
+			},
+		},
+		{
+			name:     "host ip",
+			portSpec: "127.0.0.1:8545:8545",
+			expectedMappings: []nat.PortMapping{
+				{Port: "8545/tcp", Binding: nat.PortBinding{HostIP: "127.0.0.1", HostPort: "8545"}},
+			},
+		},
+		{
+			name:     "host ip without host port",
+			portSpec: "127.0.0.1::8545",
+			expectedMappings: []nat.PortMapping{
+				{Port: "8545/tcp", Binding: nat.PortBinding{HostIP: "127.0.0.1"}},
+			},
+		},
+		{
+			name:     "ipv6 host ip",
+			portSpec: "[::1]:8545:8545/tcp",
+			expectedMappings: []nat.PortMapping{
+				{Port: "8545/tcp", Binding: nat.PortBinding{HostIP: "::1", HostPort: "8545"}},
+			},
+		},
+		{
+			name:     "ranges",
+			portSpec: "9000-9002:8000-8002/udp",
+			expectedMappings: []nat.PortMapping{
+				{Port: "8000/udp", Binding: nat.PortBinding{HostPort: "9000"}},
+				{Port: "8001/udp", Binding: nat.PortBinding{HostPort: "9001"}},
+				{Port: "8002/udp", Binding: nat.PortBinding{HostPort: "9002"}},
+			},
+		},
+		{
+			name:     "container port range only",
+			portSpec: "9000-9001",
+			expectedMappings: []nat.PortMapping{
+				{Port: "9000/tcp", Binding: nat.PortBinding{}},
+				{Port: "9001/tcp", Binding: nat.PortBinding{}},
+			},
+		},
+		{
+			name:     "host port range for one container port",
+			portSpec: "8000-8010:80",
+			expectedMappings: []nat.PortMapping{
+				{Port: "80/tcp", Binding: nat.PortBinding{HostPort: "8000-8010"}},
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			portMappings, err := parsePublish(testCase.portSpec)
+			assert.Nil(t, err)
+			assert.Equal(t, testCase.expectedMappings, portMappings)
+		})
+	}
+}
+
+func TestGetPortBindings(t *testing.T) {
+	_, ctx := newTestEnv(t)
+
+	ketherObject, _ := getTestKetherObject(true, true)
+	ketherObject.Requirement.PublishList = []PublishEntity{{Port: "127.0.0.1::8545"}, {Port: "8546"}, {Port: "30303-30304:30303-30304/udp"},
+		{Port: "30303-30304:30303-30304/udp"}, {Port: "45000-45010:9090"}}
+	exposedPorts, portBindings, err := ketherObject.getPortBindings(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, nat.PortSet{"8545/tcp": {}, "8546/tcp": {}, "30303/udp": {}, "30304/udp": {}, "9090/tcp": {}}, exposedPorts)
+	assert.Equal(t, []nat.PortBinding{{HostIP: "127.0.0.1"}}, portBindings["8545/tcp"])
+	assert.Equal(t, []nat.PortBinding{{}}, portBindings["8546/tcp"])
+	assert.Len(t, portBindings["30303/udp"], 1)
+	assert.Len(t, portBindings["30304/udp"], 1)
+	assert.Equal(t, []nat.PortBinding{{HostPort: "45000-45010"}}, portBindings["9090/tcp"])
+}
+
+func TestReserveHostPorts(t *testing.T) {
+	_, ctx := newTestEnv(t)
+
+	_, ok, err := registry.ReservePortOfName(ctx, "explorer", "fake-host", 47001, "tcp")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	ketherObject, _ := getTestKetherObject(true, true)
+
+	// 缺省的 strict 策略在端口被占用时失败，并给出占用端口的对象
+	ketherObject.Requirement.PublishList = []PublishEntity{{Port: "47001:80"}}
+	_, _, err = ketherObject.getPortBindings(ctx)
+	assert.EqualError(t, err, "host port 47001/tcp on fake-host is used by kether object explorer, free it or set the policy of the port to remap or random")
+
+	// remap 策略换成 registry.PortRanges 中的端口
+	ketherObject.Requirement.PublishList = []PublishEntity{{Port: "47001:80", Policy: PortPolicyRemap}, {Port: "47002:53/udp"}}
+	_, portBindings, err := ketherObject.getPortBindings(ctx)
+	assert.Nil(t, err)
+	assert.NotEqual(t, "47001", portBindings["80/tcp"][0].HostPort)
+	assert.Equal(t, "47002", portBindings["53/udp"][0].HostPort)
+	assert.Len(t, ketherObject.portReservations, 2)
+	portReservations, err := registry.ListPortReservations(ctx)
+	assert.Nil(t, err)
+	assert.Len(t, portReservations, 3)
+
+	// 同一对象再次部署时沿用已经预留的端口
+	altHostPort := portBindings["80/tcp"][0].HostPort
+	_, portBindings, err = ketherObject.getPortBindings(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, altHostPort, portBindings["80/tcp"][0].HostPort)
+	assert.Equal(t, "47002", portBindings["53/udp"][0].HostPort)
+	portReservations, err = registry.ListPortReservations(ctx)
+	assert.Nil(t, err)
+	assert.Len(t, portReservations, 3)
+
+	// 多个重新映射的端口分配到不同的主机端口
+	_, ok, err = registry.ReservePortOfName(ctx, "explorer", "fake-host", 47004, "tcp")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	ketherObject.Requirement.PublishList = []PublishEntity{{Port: "47001:80", Policy: PortPolicyRemap}, {Port: "47002:53/udp"}, {Port: "47004:81", Policy: PortPolicyRemap}}
+	_, portBindings, err = ketherObject.getPortBindings(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, altHostPort, portBindings["80/tcp"][0].HostPort)
+	assert.NotEqual(t, altHostPort, portBindings["81/tcp"][0].HostPort)
+	assert.NotEqual(t, "47004", portBindings["81/tcp"][0].HostPort)
+	assert.Len(t, ketherObject.portReservations, 3)
+	ketherObject.Requirement.PublishList = []PublishEntity{{Port: "47001:80", Policy: PortPolicyRemap}, {Port: "47002:53/udp"}}
+	_, _, err = ketherObject.getPortBindings(ctx)
+	assert.Nil(t, err)
+	assert.Nil(t, registry.ReleasePortsOfName(ctx, ketherObject.Name, ketherObject.portReservations))
+	assert.Nil(t, registry.ReleasePortOfName(ctx, "explorer", "fake-host", 47004, "tcp"))
+
+	assert.Nil(t, registry.ReleasePortsOfName(ctx, ketherObject.Name, ketherObject.portReservations[1:]))
+	portReservations, err = registry.ListPortReservations(ctx)
+	assert.Nil(t, err)
+	assert.Len(t, portReservations, 2)
+
+	// random 策略交由 Docker 选择主机端口
+	ketherObject.Requirement.PublishList = []PublishEntity{{Port: "127.0.0.1:47001:80", Policy: PortPolicyRandom}}
+	_, portBindings, err = ketherObject.getPortBindings(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, []nat.PortBinding{{HostIP: "127.0.0.1"}}, portBindings["80/tcp"])
+	assert.Empty(t, ketherObject.portReservations)
+
+	defaultPortRanges := registry.PortRanges
+	registry.PortRanges = []registry.PortRange{{Start: 47100, End: 47103}}
+	defer func() {
+		registry.PortRanges = defaultPortRanges
+	}()
+	assert.Nil(t, registry.ReleasePortsOfName(ctx, ketherObject.Name, nil))
+	_, ok, err = registry.ReservePortOfName(ctx, "explorer", "fake-host", 47002, "tcp")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	// 多个重新映射的端口互不冲突，也不使用对象指定的 strict 端口
+	ketherObject.Requirement.PublishList = []PublishEntity{{Port: "47001:80", Policy: PortPolicyRemap}, {Port: "47002:81", Policy: PortPolicyRemap}, {Port: "47100:82"}}
+	_, portBindings, err = ketherObject.getPortBindings(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, "47101", portBindings["80/tcp"][0].HostPort)
+	assert.Equal(t, "47102", portBindings["81/tcp"][0].HostPort)
+	assert.Equal(t, "47100", portBindings["82/tcp"][0].HostPort)
+
+	// 对象已经使用的端口对其他容器端口同样不可用，未经校验的重复端口按策略处理
+	ketherObject.Requirement.PublishList = []PublishEntity{{Port: "47100:82"}, {Port: "47100:83", Policy: PortPolicyRemap}, {Port: "47001:80", Policy: PortPolicyRemap}}
+	_, portBindings, err = ketherObject.getPortBindings(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, "47100", portBindings["82/tcp"][0].HostPort)
+	assert.Equal(t, "47101", portBindings["83/tcp"][0].HostPort)
+	assert.Equal(t, "47102", portBindings["80/tcp"][0].HostPort)
+	ketherObject.Requirement.PublishList = []PublishEntity{{Port: "47100:82"}, {Port: "47100:83"}}
+	_, _, err = ketherObject.getPortBindings(ctx)
+	assert.EqualError(t, err, "host port 47100/tcp on fake-host is used by container port 82/tcp of kether object deploy-test, free it or set the policy of the port to remap or random")
+
+	// 可分配的端口用尽时 remap 失败
+	ketherObject.Requirement.PublishList = []PublishEntity{{Port: "47100:82"}, {Port: "47001:80", Policy: PortPolicyRemap}, {Port: "47002:81", Policy: PortPolicyRemap},
+		{Port: "47002:84", Policy: PortPolicyRemap}, {Port: "47002:85", Policy: PortPolicyRemap}}
+	_, _, err = ketherObject.getPortBindings(ctx)
+	assert.NotNil(t, err)
+}
+
+func TestDeployPortBindings(t *testing.T) {
+	_, ctx := newTestEnv(t)
+
+	bootnode, bootnodeState := getTestKetherObject(true, true)
+	bootnode.Name, bootnodeState.Name = "bootnode", "bootnode"
+	bootnode.Requirement.PublishList = []PublishEntity{{Port: "47003:8545"}, {Port: "30303/udp"}}
+	_, err := Apply(ctx, bootnode, bootnodeState)
+	assert.Nil(t, err)
+
+	ketherObjectStatus, err := GetStatus(ctx, "bootnode")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"0.0.0.0:32768->30303/udp", "0.0.0.0:47003->8545/tcp"}, ketherObjectStatus.Ports)
+	portReservations, err := registry.ListPortReservations(ctx)
+	assert.Nil(t, err)
+	assert.Len(t, portReservations, 2)
+
+	// 依赖的对象可以由注册表中记录的实际端口发现 bootnode 的端口
+	portBinding, ok, err := registry.GetPublishedPortOfName(ctx, "bootnode", "30303/udp")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "32768", portBinding.HostPort)
+	env, err := resolvePeerPorts(ctx, []string{`BOOTNODE={{ port "bootnode" "30303/udp" }}`, `RPC=http://127.0.0.1:{{ port "bootnode" "8545" }}`}, true)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"BOOTNODE=32768", "RPC=http://127.0.0.1:47003"}, env)
+	_, err = resolvePeerPorts(ctx, []string{`RPC={{ port "explorer" "4000" }}`}, true)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "kether object explorer has not published container port 4000/tcp")
+	env, err = resolvePeerPorts(ctx, []string{`RPC={{ port "explorer" "4000" }}`}, false)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{`RPC={{ port "explorer" "4000" }}`}, env)
+	assert.NotNil(t, checkPeerPorts(`RPC={{ port "explorer" `))
+
+	// 端口被其他容器占用时，strict 策略给出占用端口的容器
+	assert.Nil(t, registry.ReleasePortsOfName(ctx, "bootnode", nil))
+	validator, validatorState := getTestKetherObject(true, true)
+	validator.Name, validatorState.Name = "validator", "validator"
+	validator.Requirement.PublishList = []PublishEntity{{Port: "32768:30303/udp"}}
+	_, _, err = validator.getPortBindings(ctx)
+	assert.EqualError(t, err, "host port 32768/udp on fake-host is used by container "+bootnode.GetContainerName()+", free it or set the policy of the port to remap or random")
+
+	assert.Nil(t, Undeploy(ctx, bootnode, bootnodeState, false))
+	portReservations, err = registry.ListPortReservations(ctx)
+	assert.Nil(t, err)
+	assert.Empty(t, portReservations)
+}
+
+func TestApplyPeerPortChange(t *testing.T) {
+	fakeEngine, ctx := newTestEnv(t)
+
+	bootnode, bootnodeState := getTestKetherObject(true, true)
+	bootnode.Name, bootnodeState.Name = "bootnode", "bootnode"
+	bootnode.Requirement.PublishList = []PublishEntity{{Port: "30303/udp"}}
+	_, err := Apply(ctx, bootnode, bootnodeState)
+	assert.Nil(t, err)
+	validator, validatorState := getTestKetherObject(true, true)
+	validator.Name, validatorState.Name = "validator", "validator"
+	validator.Requirement.Env = []string{`BOOTNODE={{ port "bootnode" "30303/udp" }}`}
+	action, err := Apply(ctx, validator, validatorState)
+	assert.Nil(t, err)
+	assert.Equal(t, PlanActionCreate, action)
+	action, err = Apply(ctx, validator, validatorState)
+	assert.Nil(t, err)
+	assert.Equal(t, PlanActionNoop, action)
+
+	// bootnode 重建后 Docker 选择了新的主机端口，依赖它的对象随之重建
+	bootnode.Requirement.Env = []string{"VERBOSITY=4"}
+	_, err = Apply(ctx, bootnode, bootnodeState)
+	assert.Nil(t, err)
+	action, err = Apply(ctx, validator, validatorState)
+	assert.Nil(t, err)
+	assert.Equal(t, PlanActionRecreate, action)
+	portBinding, _, err := registry.GetPublishedPortOfName(ctx, "bootnode", "30303/udp")
+	assert.Nil(t, err)
+	_, containerConfig, _, ok := fakeEngine.GetContainer(validator.GetContainerName())
+	assert.True(t, ok)
+	assert.Equal(t, []string{"BOOTNODE=" + portBinding.HostPort}, containerConfig.Env)
+}
+
+func TestMatchHostBindings(t *testing.T) {
+	assert.True(t, matchHostBindings("8080", "8080"))
+	assert.True(t, matchHostBindings(anyHostPort, "32768"))
+	assert.True(t, matchHostBindings("8080,any", "32768,8080"))
+	assert.True(t, matchHostBindings("127.0.0.1:any", "127.0.0.1:32768"))
+	assert.False(t, matchHostBindings("8080", "8081"))
+	assert.False(t, matchHostBindings("127.0.0.1:any", "32768"))
+	assert.False(t, matchHostBindings(anyHostPort, "127.0.0.1:32768"))
+	assert.False(t, matchHostBindings("8080,any", "8080"))
+}
@@ -29,23 +29,44 @@ import (
 	"github.com/MonteCarloClub/kether/log"
 )
 
+// Register 解析只包含一个 Kether 对象的 YAML 文件并注册对象，返回时仍持有对象的租约锁，
+// 以便随后的部署不被其他进程打断，调用方负责调用 Unlock 释放
 func Register(ctx context.Context, yamlPath string) (*KetherObject, *KetherObjectState, error) {
-	var err error
 	ketherObject, ketherObjectState, err := ParseYaml(yamlPath)
-	if ketherObject == nil {
-		log.Error("fail to get kether object from yaml file", "yamlPath", yamlPath)
-		err = fmt.Errorf("empty ketherObject")
-	} else if ketherObjectState == nil {
-		log.Error("fail to get kether object state from yaml file", "yamlPath", yamlPath)
-		err = fmt.Errorf("empty ketherObjectState")
-	} else if err != nil {
-		log.Error("fail to parse yaml file", "err", err)
+	if err != nil {
+		log.Error("fail to parse yaml file", "yamlPath", yamlPath, "err", err)
+		return nil, nil, err
 	}
-	ketherObjectState.SetState(ctx, REGISTERED)
 
-	if ctx.Value(flag.ContextKey).(flag.ContextValType).DryRun {
-		log.Info("registering kether object in dry run mode will not change any state")
+	err = registerKetherObject(ctx, ketherObjectState)
+	if err != nil {
+		log.Error("fail to register kether object", "name", ketherObject.Name, "err", err)
+		ketherObjectState.Unlock(ctx)
+		return nil, nil, err
 	}
+	return ketherObject, ketherObjectState, nil
+}
 
-	return ketherObject, ketherObjectState, err
+func registerKetherObject(ctx context.Context, ketherObjectState *KetherObjectState) error {
+	if ctx.Value(flag.ContextKey).(flag.ContextValType).DryRun {
+		log.Info("registering kether object in dry run mode will not change any state", "name", ketherObjectState.Name)
+		return nil
+	}
+	err := ketherObjectState.Lock(ctx)
+	if err != nil {
+		return err
+	}
+	// 已部署的对象不能重新注册，否则在状态转换中以 DEPLOYED -> REGISTERING 非法失败
+	state, _, err := getStateOfName(ctx, ketherObjectState.Name)
+	if err != nil {
+		return err
+	}
+	if state == DEPLOYED {
+		return fmt.Errorf("kether object %v is already deployed, use kether apply to update it or kether undeploy to remove it first", ketherObjectState.Name)
+	}
+	err = ketherObjectState.SetState(ctx, REGISTERING, "")
+	if err != nil {
+		return err
+	}
+	return ketherObjectState.SetState(ctx, REGISTERED, "")
 }
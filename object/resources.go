@@ -0,0 +1,136 @@
+/*
+Copyright (c) 2022 Zhang Zhanpeng <zhangregister@outlook.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package object
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/go-units"
+)
+
+// hostResources 是由 YAML 中资源限制和重启策略字段解析出的主机配置
+type hostResources struct {
+	Resources     container.Resources
+	RestartPolicy container.RestartPolicy
+	ShmSize       int64
+}
+
+// parseRestartPolicy 解析 no、on-failure[:N]、always 和 unless-stopped 形式的重启策略
+func parseRestartPolicy(restartPolicy string) (container.RestartPolicy, error) {
+	parts := strings.SplitN(restartPolicy, ":", 2)
+	policy := container.RestartPolicy{
+		Name: parts[0],
+	}
+	switch policy.Name {
+	case "", "no", "always", "unless-stopped":
+		if len(parts) == 2 {
+			return policy, fmt.Errorf("maximum retry count is only allowed for on-failure")
+		}
+	case "on-failure":
+		if len(parts) == 2 {
+			maximumRetryCount, err := strconv.Atoi(parts[1])
+			if err != nil || maximumRetryCount < 0 {
+				return policy, fmt.Errorf("invalid maximum retry count %q", parts[1])
+			}
+			policy.MaximumRetryCount = maximumRetryCount
+		}
+	default:
+		return policy, fmt.Errorf("unknown restart policy %q, expect one of no, on-failure[:N], always and unless-stopped", policy.Name)
+	}
+	return policy, nil
+}
+
+// getHostResources 解析资源限制和重启策略，大小可使用 512m、2g 等带单位的写法
+func getHostResources(requirement RunDescriptionEntity) (*hostResources, FieldErrors) {
+	fieldErrors := make(FieldErrors, 0)
+	hostResources := &hostResources{}
+	resources := &hostResources.Resources
+
+	if requirement.Cpus != "" {
+		cpus, err := strconv.ParseFloat(requirement.Cpus, 64)
+		if err != nil || cpus <= 0 {
+			fieldErrors.add("requirement.cpus", "%q is not a positive number", requirement.Cpus)
+		} else {
+			resources.NanoCPUs = int64(cpus * 1e9)
+		}
+	}
+	if requirement.CpuShares < 0 {
+		fieldErrors.add("requirement.cpu_shares", "%v is negative", requirement.CpuShares)
+	}
+	resources.CPUShares = requirement.CpuShares
+	resources.CpusetCpus = requirement.Cpuset
+
+	if requirement.Memory != "" {
+		memory, err := units.RAMInBytes(requirement.Memory)
+		if err != nil {
+			fieldErrors.add("requirement.memory", "%v", err)
+		} else {
+			resources.Memory = memory
+		}
+	}
+	if requirement.MemorySwap == "-1" {
+		resources.MemorySwap = -1
+	} else if requirement.MemorySwap != "" {
+		memorySwap, err := units.RAMInBytes(requirement.MemorySwap)
+		if err != nil {
+			fieldErrors.add("requirement.memory_swap", "%v", err)
+		} else if resources.Memory == 0 {
+			fieldErrors.add("requirement.memory_swap", "memory_swap requires memory to be set")
+		} else if memorySwap < resources.Memory {
+			fieldErrors.add("requirement.memory_swap", "memory_swap %v should not be less than memory %v", requirement.MemorySwap, requirement.Memory)
+		} else {
+			resources.MemorySwap = memorySwap
+		}
+	}
+
+	if requirement.PidsLimit != 0 {
+		pidsLimit := requirement.PidsLimit
+		resources.PidsLimit = &pidsLimit
+	}
+	for i, ulimit := range requirement.Ulimits {
+		parsedUlimit, err := units.ParseUlimit(ulimit)
+		if err != nil {
+			fieldErrors.add(fmt.Sprintf("requirement.ulimits[%v]", i), "%v", err)
+			continue
+		}
+		resources.Ulimits = append(resources.Ulimits, parsedUlimit)
+	}
+
+	if requirement.ShmSize != "" {
+		shmSize, err := units.RAMInBytes(requirement.ShmSize)
+		if err != nil || shmSize <= 0 {
+			fieldErrors.add("requirement.shm_size", "%q is not a positive size", requirement.ShmSize)
+		} else {
+			hostResources.ShmSize = shmSize
+		}
+	}
+
+	restartPolicy, err := parseRestartPolicy(requirement.RestartPolicy)
+	if err != nil {
+		fieldErrors.add("requirement.restart_policy", "%v", err)
+	}
+	hostResources.RestartPolicy = restartPolicy
+	return hostResources, fieldErrors
+}
@@ -0,0 +1,184 @@
+/*
+Copyright (c) 2022 Zhang Zhanpeng <zhangregister@outlook.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package object
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/MonteCarloClub/kether/flag"
+	"github.com/MonteCarloClub/kether/log"
+	"github.com/MonteCarloClub/kether/registry"
+)
+
+// KetherObjectRevision 是 Kether 对象的一个修订
+type KetherObjectRevision struct {
+	Revision    int       `json:"revision" yaml:"revision"`
+	Timestamp   time.Time `json:"timestamp" yaml:"timestamp"`
+	Image       string    `json:"image" yaml:"image"`
+	ImageDigest string    `json:"image_digest,omitempty" yaml:"image_digest,omitempty"`
+	ImageID     string    `json:"image_id" yaml:"image_id"`
+	ConfigHash  string    `json:"config_hash" yaml:"config_hash"`
+	RollbackOf  int       `json:"rollback_of,omitempty" yaml:"rollback_of,omitempty"`
+}
+
+// getResolvedSpec 返回解析后的完整对象，环境变量文件被展开为环境变量，回滚时不再依赖 YAML 文件和环境变量文件
+func (ketherObject *KetherObject) getResolvedSpec() ([]byte, error) {
+	env, err := ketherObject.getEnv()
+	if err != nil {
+		return nil, err
+	}
+	requirement := *ketherObject.Requirement
+	requirement.Env = env
+	requirement.EnvFile = nil
+	resolvedKetherObject := *ketherObject
+	resolvedKetherObject.Requirement = &requirement
+	return json.Marshal(resolvedKetherObject)
+}
+
+// isSameSpec 判断两个 JSON 编码的对象是否相同，忽略空白
+func isSameSpec(spec1 []byte, spec2 []byte) bool {
+	var buffer1, buffer2 bytes.Buffer
+	if json.Compact(&buffer1, spec1) != nil || json.Compact(&buffer2, spec2) != nil {
+		return false
+	}
+	return bytes.Equal(buffer1.Bytes(), buffer2.Bytes())
+}
+
+// recordRevision 在注册表中追加对象的修订，与最近一个修订相同时不追加，例如卸载后以相同的配置重新部署
+func recordRevision(ctx context.Context, ketherObject *KetherObject, configHash string) error {
+	spec, err := ketherObject.getResolvedSpec()
+	if err != nil {
+		log.Error("fail to get resolved spec of kether object", "name", ketherObject.Name, "err", err)
+		return err
+	}
+	revisions, err := registry.GetRevisionsOfName(ctx, ketherObject.Name)
+	if err != nil {
+		return err
+	}
+	if len(revisions) > 0 {
+		latestRevision := revisions[len(revisions)-1]
+		if latestRevision.ImageID == ketherObject.imageId && isSameSpec(latestRevision.Spec, spec) {
+			log.Info("kether object deployed at latest revision", "name", ketherObject.Name, "revision", latestRevision.Revision)
+			return nil
+		}
+	}
+	number, err := registry.AppendRevisionOfName(ctx, ketherObject.Name, registry.Revision{
+		Spec:        spec,
+		ConfigHash:  configHash,
+		Image:       ketherObject.imageName,
+		ImageDigest: ketherObject.imageDigest,
+		ImageID:     ketherObject.imageId,
+		RollbackOf:  ketherObject.rollbackOf,
+		Timestamp:   time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	log.Info("revision of kether object recorded", "name", ketherObject.Name, "revision", number, "imageDigest", ketherObject.imageDigest)
+	return nil
+}
+
+// GetRevisions 按编号顺序返回 Kether 对象的修订
+func GetRevisions(ctx context.Context, name string) ([]*KetherObjectRevision, error) {
+	revisions, err := registry.GetRevisionsOfName(ctx, name)
+	if err != nil {
+		log.Error("fail to get revisions of kether object", "name", name, "err", err)
+		return nil, err
+	}
+	ketherObjectRevisions := make([]*KetherObjectRevision, 0, len(revisions))
+	for _, revision := range revisions {
+		ketherObjectRevisions = append(ketherObjectRevisions, &KetherObjectRevision{
+			Revision:    revision.Revision,
+			Timestamp:   revision.Timestamp,
+			Image:       revision.Image,
+			ImageDigest: revision.ImageDigest,
+			ImageID:     revision.ImageID,
+			ConfigHash:  revision.ConfigHash,
+			RollbackOf:  revision.RollbackOf,
+		})
+	}
+	return ketherObjectRevisions, nil
+}
+
+// getRollbackRevision 返回回滚的目标修订，toRevision 为 0 时是最近一个修订的前一个修订
+func getRollbackRevision(revisions []registry.Revision, name string, toRevision int) (registry.Revision, error) {
+	if toRevision == 0 {
+		if len(revisions) < 2 {
+			return registry.Revision{}, fmt.Errorf("no previous revision of kether object %v", name)
+		}
+		return revisions[len(revisions)-2], nil
+	}
+	for _, revision := range revisions {
+		if revision.Revision == toRevision {
+			return revision, nil
+		}
+	}
+	return registry.Revision{}, fmt.Errorf("revision %v of kether object %v not found", toRevision, name)
+}
+
+// Rollback 以修订中记录的对象和镜像摘要重新部署对象，与 apply 一样替换现有的容器，回滚本身被记录为新的修订；
+// toRevision 为 0 时回滚到前一个修订。返回 apply 的动作
+func Rollback(ctx context.Context, name string, toRevision int) (string, error) {
+	revisions, err := registry.GetRevisionsOfName(ctx, name)
+	if err != nil {
+		return "", err
+	}
+	revision, err := getRollbackRevision(revisions, name, toRevision)
+	if err != nil {
+		log.Error("fail to get revision to roll back to", "name", name, "err", err)
+		return "", err
+	}
+
+	ketherObject := &KetherObject{}
+	err = json.Unmarshal(revision.Spec, ketherObject)
+	if err != nil {
+		err = fmt.Errorf("invalid spec in revision %v of kether object %v: %v", revision.Revision, name, err)
+		log.Error("fail to decode revision of kether object", "err", err)
+		return "", err
+	}
+	if ketherObject.Name != name || ketherObject.Requirement == nil {
+		err = fmt.Errorf("revision %v does not describe kether object %v", revision.Revision, name)
+		log.Error("fail to decode revision of kether object", "err", err)
+		return "", err
+	}
+	// 按摘要部署修订中的镜像，即使 tag 已经指向其他镜像；只在本地存在的镜像没有仓库摘要，仍按镜像名部署，
+	// 此时与部署一样受摘要策略约束
+	ketherObject.imageName = revision.Image
+	if revision.ImageDigest != "" {
+		ketherObject.imageName = revision.ImageDigest
+	} else if ctx.Value(flag.ContextKey).(flag.ContextValType).RequireDigest {
+		err = fmt.Errorf("image %v of revision %v is not pinned by digest", revision.Image, revision.Revision)
+		log.Error("rollback refused by digest policy", "name", name, "err", err)
+		return "", err
+	} else {
+		log.Warn("image of revision is not pinned by digest", "name", name, "revision", revision.Revision, "imageName", revision.Image)
+	}
+	ketherObject.rollbackOf = revision.Revision
+	log.Info("kether object will be rolled back", "name", name, "revision", revision.Revision, "imageName", ketherObject.imageName)
+
+	_, ketherObjectState := GetKetherObjectOfName(name)
+	return Apply(ctx, ketherObject, ketherObjectState)
+}
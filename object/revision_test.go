@@ -0,0 +1,121 @@
+/*
+Copyright (c) 2022 Zhang Zhanpeng <zhangregister@outlook.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package object
+
+import (
+	"context"
+	"testing"
+
+	"github.com/MonteCarloClub/kether/flag"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRollback(t *testing.T) {
+	fakeEngine, ctx := newTestEnv(t)
+	delete(fakeEngine.LocalImages, testImageName)
+	fakeEngine.RemoteImages[testImageName] = true
+	fakeEngine.RemoteImages[testRepository+":1.1.0-alpha.7"] = true
+
+	_, err := Rollback(ctx, "deploy-test", 0)
+	assert.NotNil(t, err)
+
+	ketherObject, ketherObjectState := getTestKetherObject(true, false)
+	ketherObject.Requirement.Env = []string{"NETWORK_ID=1337"}
+	_, err = Apply(ctx, ketherObject, ketherObjectState)
+	assert.Nil(t, err)
+	_, err = Apply(ctx, ketherObject, ketherObjectState)
+	assert.Nil(t, err)
+	_, err = Rollback(ctx, "deploy-test", 0)
+	assert.NotNil(t, err)
+
+	ketherObject, ketherObjectState = getTestKetherObject(true, false)
+	ketherObject.Priority.DockerImageTag = "1.1.0-alpha.7"
+	ketherObject.Requirement.Env = []string{"NETWORK_ID=1337"}
+	_, err = Apply(ctx, ketherObject, ketherObjectState)
+	assert.Nil(t, err)
+	revisions, err := GetRevisions(ctx, "deploy-test")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(revisions))
+	assert.Equal(t, testImageName, revisions[0].Image)
+	assert.Contains(t, revisions[0].ImageDigest, testRepository+"@sha256:")
+	assert.Equal(t, testRepository+":1.1.0-alpha.7", revisions[1].Image)
+
+	action, err := Rollback(ctx, "deploy-test", 0)
+	assert.Nil(t, err)
+	assert.Equal(t, PlanActionRecreate, action)
+	status, containerConfig, _, ok := fakeEngine.GetContainer(ketherObject.GetContainerName())
+	assert.True(t, ok)
+	assert.Equal(t, "running", status)
+	assert.Equal(t, revisions[0].ImageDigest, containerConfig.Image)
+	assert.Equal(t, []string{"NETWORK_ID=1337"}, containerConfig.Env)
+	state, _, err := getStateOfName(ctx, "deploy-test")
+	assert.Nil(t, err)
+	assert.Equal(t, DEPLOYED, state)
+
+	revisions, err = GetRevisions(ctx, "deploy-test")
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(revisions))
+	assert.Equal(t, 1, revisions[2].RollbackOf)
+	assert.Equal(t, revisions[0].ImageDigest, revisions[2].ImageDigest)
+
+	_, err = Rollback(ctx, "deploy-test", 9)
+	assert.NotNil(t, err)
+	action, err = Rollback(ctx, "deploy-test", 2)
+	assert.Nil(t, err)
+	assert.Equal(t, PlanActionRecreate, action)
+	_, containerConfig, _, _ = fakeEngine.GetContainer(ketherObject.GetContainerName())
+	assert.Equal(t, revisions[1].ImageDigest, containerConfig.Image)
+	revisions, err = GetRevisions(ctx, "deploy-test")
+	assert.Nil(t, err)
+	assert.Equal(t, 4, len(revisions))
+	assert.Equal(t, 2, revisions[3].RollbackOf)
+}
+
+func TestRollbackRequireDigest(t *testing.T) {
+	fakeEngine, ctx := newTestEnv(t)
+
+	// 只在本地存在的镜像没有仓库摘要
+	ketherObject, ketherObjectState := getTestKetherObject(true, true)
+	ketherObject.Requirement.Env = []string{"NETWORK_ID=1337"}
+	_, err := Apply(ctx, ketherObject, ketherObjectState)
+	assert.Nil(t, err)
+	ketherObject.Requirement.Env = []string{"NETWORK_ID=1"}
+	_, err = Apply(ctx, ketherObject, ketherObjectState)
+	assert.Nil(t, err)
+	revisions, err := GetRevisions(ctx, "deploy-test")
+	assert.Nil(t, err)
+	assert.Empty(t, revisions[0].ImageDigest)
+
+	requireDigestCtx := context.WithValue(context.Background(), flag.ContextKey, flag.ContextValType{RequireDigest: true})
+	calls := len(fakeEngine.Calls())
+	_, err = Rollback(requireDigestCtx, "deploy-test", 1)
+	assert.NotNil(t, err)
+	assert.Empty(t, fakeEngine.Calls()[calls:])
+	_, containerConfig, _, _ := fakeEngine.GetContainer(ketherObject.GetContainerName())
+	assert.Equal(t, []string{"NETWORK_ID=1"}, containerConfig.Env)
+
+	action, err := Rollback(ctx, "deploy-test", 1)
+	assert.Nil(t, err)
+	assert.Equal(t, PlanActionRecreate, action)
+	_, containerConfig, _, _ = fakeEngine.GetContainer(ketherObject.GetContainerName())
+	assert.Equal(t, []string{"NETWORK_ID=1337"}, containerConfig.Env)
+}
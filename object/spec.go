@@ -0,0 +1,207 @@
+/*
+Copyright (c) 2022 Zhang Zhanpeng <zhangregister@outlook.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package object
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/MonteCarloClub/kether/log"
+)
+
+// CommandEntity 是 YAML 中的命令，可写成字符串或字符串列表，字符串按 shell 的规则切分
+type CommandEntity []string
+
+func (commandEntity *CommandEntity) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var command string
+	if err := unmarshal(&command); err == nil {
+		commandSlice, err := splitCommand(command)
+		if err != nil {
+			return err
+		}
+		*commandEntity = commandSlice
+		return nil
+	}
+
+	var commandSlice []string
+	if err := unmarshal(&commandSlice); err != nil {
+		return fmt.Errorf("expect a string or a list of strings")
+	}
+	*commandEntity = commandSlice
+	return nil
+}
+
+// splitCommand 按空白切分命令，支持单引号、双引号和反斜杠转义
+func splitCommand(command string) ([]string, error) {
+	words := make([]string, 0)
+	var word strings.Builder
+	inWord := false
+	var quote rune
+	escaped := false
+	for _, r := range command {
+		switch {
+		case escaped:
+			word.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped = true
+			inWord = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inWord = true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if escaped || quote != 0 {
+		return nil, fmt.Errorf("unterminated quote or escape in command %q", command)
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
+// EnvironmentEntity 是 YAML 中的环境变量，可写成 KEY=VALUE 列表或 KEY: VALUE 映射
+type EnvironmentEntity []string
+
+func (environmentEntity *EnvironmentEntity) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var envSlice []string
+	if err := unmarshal(&envSlice); err == nil {
+		*environmentEntity = envSlice
+		return nil
+	}
+
+	var envMap map[string]*string
+	if err := unmarshal(&envMap); err != nil {
+		return fmt.Errorf("expect a list of KEY=VALUE or a mapping of KEY: VALUE")
+	}
+	keys := make([]string, 0, len(envMap))
+	for key := range envMap {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	envSlice = make([]string, 0, len(envMap))
+	for _, key := range keys {
+		// 没有值的变量在部署时由 getEnv 从主机环境继承
+		if envMap[key] == nil {
+			envSlice = append(envSlice, key)
+		} else {
+			envSlice = append(envSlice, fmt.Sprintf("%v=%v", key, *envMap[key]))
+		}
+	}
+	*environmentEntity = envSlice
+	return nil
+}
+
+func checkEnv(env string) error {
+	key := strings.SplitN(env, "=", 2)[0]
+	if key == "" {
+		return fmt.Errorf("empty variable name in %q", env)
+	}
+	if strings.ContainsAny(key, " \t\n") {
+		return fmt.Errorf("variable name %q contains whitespace", key)
+	}
+	return nil
+}
+
+// resolvePath 把相对路径解析为相对 YAML 文件所在目录的路径
+func (ketherObject *KetherObject) resolvePath(path string) string {
+	if filepath.IsAbs(path) || ketherObject.Dir == "" {
+		return path
+	}
+	return filepath.Join(ketherObject.Dir, path)
+}
+
+// readEnvFile 读取环境变量文件，忽略空行和 # 开头的注释行
+func readEnvFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	env := make([]string, 0)
+	scanner := bufio.NewScanner(f)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if err := checkEnv(line); err != nil {
+			return nil, fmt.Errorf("line %v: %v", lineNumber, err)
+		}
+		env = append(env, line)
+	}
+	return env, scanner.Err()
+}
+
+// getEnv 合并环境变量文件和 env 字段，env 字段中的同名变量优先；没有值的变量取 kether 进程环境中的值，
+// 与 `docker run -e KEY` 一致，Docker Engine API 不会解析这样的变量，因此在这里解析，主机环境中没有的变量被忽略
+func (ketherObject *KetherObject) getEnv() ([]string, error) {
+	env := make([]string, 0)
+	for i, envFile := range ketherObject.Requirement.EnvFile {
+		fileEnv, err := readEnvFile(ketherObject.resolvePath(envFile))
+		if err != nil {
+			return nil, &FieldError{
+				Field:   fmt.Sprintf("requirement.env_file[%v]", i),
+				Message: err.Error(),
+			}
+		}
+		env = append(env, fileEnv...)
+	}
+	env = append(env, ketherObject.Requirement.Env...)
+	resolvedEnv := make([]string, 0, len(env))
+	for _, envEntry := range env {
+		if strings.Contains(envEntry, "=") {
+			resolvedEnv = append(resolvedEnv, envEntry)
+			continue
+		}
+		value, ok := os.LookupEnv(envEntry)
+		if !ok {
+			log.Warn("variable not set in host environment, ignored", "name", ketherObject.Name, "key", envEntry)
+			continue
+		}
+		resolvedEnv = append(resolvedEnv, fmt.Sprintf("%v=%v", envEntry, value))
+	}
+	if len(resolvedEnv) == 0 {
+		return nil, nil
+	}
+	return resolvedEnv, nil
+}
@@ -0,0 +1,198 @@
+/*
+Copyright (c) 2022 Zhang Zhanpeng <zhangregister@outlook.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package object
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/strslice"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
+)
+
+func TestSplitCommand(t *testing.T) {
+	testCases := []struct {
+		command   string
+		expect    []string
+		expectErr bool
+	}{
+		{command: "geth --dev", expect: []string{"geth", "--dev"}},
+		{command: "  geth   --http  ", expect: []string{"geth", "--http"}},
+		{command: `sh -c "echo hello world"`, expect: []string{"sh", "-c", "echo hello world"}},
+		{command: `echo 'a "b"' c\ d`, expect: []string{"echo", `a "b"`, "c d"}},
+		{command: `echo ""`, expect: []string{"echo", ""}},
+		{command: `echo "unterminated`, expectErr: true},
+	}
+	for _, testCase := range testCases {
+		words, err := splitCommand(testCase.command)
+		if testCase.expectErr {
+			assert.NotNil(t, err, testCase.command)
+			continue
+		}
+		assert.Nil(t, err, testCase.command)
+		assert.Equal(t, testCase.expect, words, testCase.command)
+	}
+}
+
+func TestContainerSpec(t *testing.T) {
+	dir := t.TempDir()
+	err := ioutil.WriteFile(filepath.Join(dir, "node.env"), []byte("# comment\nNETWORK_ID=1337\nVERBOSITY=3\n"), 0644)
+	assert.Nil(t, err)
+
+	yamlBytes := []byte(`
+name: spec-test
+kind: deploy
+predicate:
+  repository: ethereum/client-go
+requirement:
+  local_image: true
+  command: --dev --http "--http.api=eth,net"
+  entrypoint: [geth]
+  env:
+    VERBOSITY: 4
+    KETHER_TEST_HOME:
+    KETHER_TEST_UNSET:
+  env_file:
+    - node.env
+  working_dir: /data
+  user: 1000:1000
+  labels:
+    chain: dev
+  hostname: node-0
+  tty: true
+  stdin_open: true
+`)
+	ketherObjectEntity := &KetherObjectEntity{}
+	assert.Nil(t, yaml.Unmarshal(yamlBytes, ketherObjectEntity))
+	assert.Nil(t, ketherObjectEntity.Validate())
+
+	fakeEngine, ctx := newTestEnv(t)
+	fakeEngine.LocalImages["ethereum/client-go"] = true
+
+	// 没有值的变量取主机环境中的值，主机环境中没有的变量被忽略
+	os.Setenv("KETHER_TEST_HOME", "/home/kether")
+	defer os.Unsetenv("KETHER_TEST_HOME")
+	os.Unsetenv("KETHER_TEST_UNSET")
+	ketherObject := ketherObjectEntity.GetKetherObject()
+	ketherObject.Dir = dir
+	containerConfig, _, err := ketherObject.GetContainerAndHostConfig(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, strslice.StrSlice{"--dev", "--http", "--http.api=eth,net"}, containerConfig.Cmd)
+	assert.Equal(t, strslice.StrSlice{"geth"}, containerConfig.Entrypoint)
+	assert.Equal(t, []string{"NETWORK_ID=1337", "VERBOSITY=3", "KETHER_TEST_HOME=/home/kether", "VERBOSITY=4"}, containerConfig.Env)
+	assert.Equal(t, "/data", containerConfig.WorkingDir)
+	assert.Equal(t, "1000:1000", containerConfig.User)
+	assert.Equal(t, "dev", containerConfig.Labels["chain"])
+	assert.Len(t, containerConfig.Labels[ConfigHashLabel], 64)
+	assert.Equal(t, map[string]string{"chain": "dev"}, ketherObject.Requirement.Labels)
+	assert.Equal(t, "node-0", containerConfig.Hostname)
+	assert.True(t, containerConfig.Tty)
+	assert.True(t, containerConfig.OpenStdin)
+}
+
+func TestValidateContainerSpec(t *testing.T) {
+	yamlBytes := []byte(`
+name: spec-test
+predicate:
+  repository: ethereum/client-go
+requirement:
+  env:
+    - =1
+    - GOOD=1
+  working_dir: data
+  hostname: -node
+`)
+	ketherObjectEntity := &KetherObjectEntity{}
+	assert.Nil(t, yaml.Unmarshal(yamlBytes, ketherObjectEntity))
+	err := ketherObjectEntity.Validate()
+	fieldErrors, ok := err.(FieldErrors)
+	assert.True(t, ok)
+	fields := make([]string, 0)
+	for _, fieldError := range fieldErrors {
+		fields = append(fields, fieldError.Field)
+	}
+	assert.Equal(t, []string{"requirement.env[0]", "requirement.working_dir", "requirement.hostname"}, fields)
+}
+
+func TestHostResources(t *testing.T) {
+	yamlBytes := []byte(`
+name: resources-test
+predicate:
+  repository: ethereum/client-go
+requirement:
+  cpus: 1.5
+  cpu_shares: 512
+  cpuset: 0-1
+  memory: 512m
+  memory_swap: 1g
+  pids_limit: 256
+  ulimits:
+    - nofile=1024:2048
+  shm_size: 64m
+  restart_policy: on-failure:5
+`)
+	ketherObjectEntity := &KetherObjectEntity{}
+	assert.Nil(t, yaml.Unmarshal(yamlBytes, ketherObjectEntity))
+	assert.Nil(t, ketherObjectEntity.Validate())
+
+	hostResources, fieldErrors := getHostResources(ketherObjectEntity.Requirement)
+	assert.Empty(t, fieldErrors)
+	assert.Equal(t, int64(1500000000), hostResources.Resources.NanoCPUs)
+	assert.Equal(t, int64(512), hostResources.Resources.CPUShares)
+	assert.Equal(t, "0-1", hostResources.Resources.CpusetCpus)
+	assert.Equal(t, int64(512*1024*1024), hostResources.Resources.Memory)
+	assert.Equal(t, int64(1024*1024*1024), hostResources.Resources.MemorySwap)
+	assert.Equal(t, int64(256), *hostResources.Resources.PidsLimit)
+	assert.Equal(t, "nofile=1024:2048", hostResources.Resources.Ulimits[0].String())
+	assert.Equal(t, int64(64*1024*1024), hostResources.ShmSize)
+	assert.Equal(t, container.RestartPolicy{Name: "on-failure", MaximumRetryCount: 5}, hostResources.RestartPolicy)
+
+	for restartPolicy, expectErr := range map[string]bool{
+		"":               false,
+		"no":             false,
+		"always":         false,
+		"unless-stopped": false,
+		"on-failure":     false,
+		"always:3":       true,
+		"on-failure:-1":  true,
+		"sometimes":      true,
+	} {
+		_, err := parseRestartPolicy(restartPolicy)
+		assert.Equal(t, expectErr, err != nil, restartPolicy)
+	}
+
+	_, fieldErrors = getHostResources(RunDescriptionEntity{
+		Cpus:       "-1",
+		Memory:     "lots",
+		MemorySwap: "1g",
+		Ulimits:    []string{"nofile"},
+	})
+	fields := make([]string, 0)
+	for _, fieldError := range fieldErrors {
+		fields = append(fields, fieldError.Field)
+	}
+	assert.Equal(t, []string{"requirement.cpus", "requirement.memory", "requirement.memory_swap", "requirement.ulimits[0]"}, fields)
+}
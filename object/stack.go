@@ -0,0 +1,307 @@
+/*
+Copyright (c) 2022 Zhang Zhanpeng <zhangregister@outlook.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package object
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/MonteCarloClub/kether/flag"
+	"github.com/MonteCarloClub/kether/log"
+)
+
+// Stack 是一组协作的 Kether 对象，如区块链测试网的引导节点、验证节点、RPC 节点和浏览器，
+// 对象按 depends_on 构成的有向无环图部署
+type Stack struct {
+	KetherObjects      []*KetherObject
+	KetherObjectStates map[string]*KetherObjectState
+}
+
+func NewStack() *Stack {
+	return &Stack{
+		KetherObjects:      make([]*KetherObject, 0),
+		KetherObjectStates: make(map[string]*KetherObjectState),
+	}
+}
+
+func (stack *Stack) add(ketherObject *KetherObject, ketherObjectState *KetherObjectState) {
+	stack.KetherObjects = append(stack.KetherObjects, ketherObject)
+	stack.KetherObjectStates[ketherObject.Name] = ketherObjectState
+}
+
+// validate 检查对象名称是否重复、依赖的对象是否存在以及依赖关系是否成环
+func (stack *Stack) validate(fieldPrefixes []string) error {
+	fieldErrors := make(FieldErrors, 0)
+	names := make(map[string]bool, len(stack.KetherObjects))
+	for i, ketherObject := range stack.KetherObjects {
+		if ketherObject.Name != "" && names[ketherObject.Name] {
+			fieldErrors.add(fieldPrefixes[i]+"name", "duplicate name %v", ketherObject.Name)
+		}
+		names[ketherObject.Name] = true
+	}
+	for i, ketherObject := range stack.KetherObjects {
+		for j, dependency := range ketherObject.DependsOn {
+			if !names[dependency] {
+				fieldErrors.add(fmt.Sprintf("%vdepends_on[%v]", fieldPrefixes[i], j), "unknown kether object %v", dependency)
+			}
+		}
+	}
+	if len(fieldErrors) > 0 {
+		return fieldErrors
+	}
+
+	_, cycle := stack.sortTopologically()
+	if len(cycle) > 0 {
+		for i, ketherObject := range stack.KetherObjects {
+			if ketherObject.Name == cycle[0] {
+				fieldErrors.add(fieldPrefixes[i]+"depends_on", "%v", getDependencyCycleError(cycle))
+			}
+		}
+		return fieldErrors
+	}
+	return nil
+}
+
+func getDependencyCycleError(cycle []string) error {
+	return fmt.Errorf("dependency cycle found among kether objects %v", strings.Join(cycle, ", "))
+}
+
+// GetDeployOrder 返回拓扑排序后的部署批次，同一批次的对象互不依赖，依赖关系成环时返回错误
+func (stack *Stack) GetDeployOrder() ([][]string, error) {
+	batches, cycle := stack.sortTopologically()
+	if len(cycle) > 0 {
+		return nil, getDependencyCycleError(cycle)
+	}
+	return batches, nil
+}
+
+// sortTopologically 返回部署批次，依赖关系成环时返回环上及依赖环的对象
+func (stack *Stack) sortTopologically() ([][]string, []string) {
+	inDegree := make(map[string]int, len(stack.KetherObjects))
+	dependents := make(map[string][]string, len(stack.KetherObjects))
+	for _, ketherObject := range stack.KetherObjects {
+		inDegree[ketherObject.Name] += 0
+		for _, dependency := range getUniqueNames(ketherObject.DependsOn) {
+			inDegree[ketherObject.Name]++
+			dependents[dependency] = append(dependents[dependency], ketherObject.Name)
+		}
+	}
+
+	batches := make([][]string, 0)
+	batch := make([]string, 0)
+	for _, ketherObject := range stack.KetherObjects {
+		if inDegree[ketherObject.Name] == 0 {
+			batch = append(batch, ketherObject.Name)
+		}
+	}
+	sorted := 0
+	for len(batch) > 0 {
+		sort.Strings(batch)
+		batches = append(batches, batch)
+		sorted += len(batch)
+		nextBatch := make([]string, 0)
+		for _, name := range batch {
+			for _, dependent := range dependents[name] {
+				inDegree[dependent]--
+				if inDegree[dependent] == 0 {
+					nextBatch = append(nextBatch, dependent)
+				}
+			}
+		}
+		batch = nextBatch
+	}
+
+	if sorted < len(stack.KetherObjects) {
+		cycle := make([]string, 0)
+		for _, ketherObject := range stack.KetherObjects {
+			if inDegree[ketherObject.Name] > 0 {
+				cycle = append(cycle, ketherObject.Name)
+			}
+		}
+		return nil, cycle
+	}
+	return batches, nil
+}
+
+func getUniqueNames(names []string) []string {
+	seen := make(map[string]bool, len(names))
+	uniqueNames := make([]string, 0, len(names))
+	for _, name := range names {
+		if !seen[name] {
+			seen[name] = true
+			uniqueNames = append(uniqueNames, name)
+		}
+	}
+	return uniqueNames
+}
+
+// runInDependencyOrder 并行地对每个对象执行 fn，每个对象等待其前驱执行完毕，reverse 为 false 时前驱是它依赖的对象，
+// 否则是依赖它的对象；前驱失败时不执行 fn 而执行 skip。返回每个对象的错误
+func (stack *Stack) runInDependencyOrder(reverse bool, fn func(*KetherObject, *KetherObjectState) error, skip func(*KetherObject, *KetherObjectState, error) error) map[string]error {
+	predecessors := make(map[string][]string, len(stack.KetherObjects))
+	for _, ketherObject := range stack.KetherObjects {
+		for _, dependency := range getUniqueNames(ketherObject.DependsOn) {
+			if reverse {
+				predecessors[dependency] = append(predecessors[dependency], ketherObject.Name)
+			} else {
+				predecessors[ketherObject.Name] = append(predecessors[ketherObject.Name], dependency)
+			}
+		}
+	}
+
+	done := make(map[string]chan struct{}, len(stack.KetherObjects))
+	for _, ketherObject := range stack.KetherObjects {
+		done[ketherObject.Name] = make(chan struct{})
+	}
+	errs := make(map[string]error, len(stack.KetherObjects))
+	var errsMutex sync.Mutex
+
+	var wg sync.WaitGroup
+	for _, ketherObject := range stack.KetherObjects {
+		wg.Add(1)
+		go func(ketherObject *KetherObject) {
+			defer wg.Done()
+			defer close(done[ketherObject.Name])
+			ketherObjectState := stack.KetherObjectStates[ketherObject.Name]
+
+			var err error
+			for _, predecessor := range predecessors[ketherObject.Name] {
+				<-done[predecessor]
+				errsMutex.Lock()
+				predecessorErr := errs[predecessor]
+				errsMutex.Unlock()
+				if predecessorErr != nil && err == nil {
+					err = skip(ketherObject, ketherObjectState, fmt.Errorf("%v of %v failed: %v", predecessor, ketherObject.Name, predecessorErr))
+				}
+			}
+			if err == nil {
+				err = fn(ketherObject, ketherObjectState)
+			}
+
+			errsMutex.Lock()
+			errs[ketherObject.Name] = err
+			errsMutex.Unlock()
+		}(ketherObject)
+	}
+	wg.Wait()
+	return errs
+}
+
+// getStackError 按对象在栈中的顺序汇总错误
+func (stack *Stack) getStackError(errs map[string]error) error {
+	messages := make([]string, 0)
+	for _, ketherObject := range stack.KetherObjects {
+		if errs[ketherObject.Name] != nil {
+			messages = append(messages, fmt.Sprintf("%v: %v", ketherObject.Name, errs[ketherObject.Name]))
+		}
+	}
+	if len(messages) > 0 {
+		return fmt.Errorf("%v of %v kether objects failed: %v", len(messages), len(stack.KetherObjects), strings.Join(messages, "; "))
+	}
+	return nil
+}
+
+// Lock 按名称顺序获取栈中所有对象的租约锁，避免两个栈互相等待；任一对象的锁获取失败时释放已经获取的锁
+func (stack *Stack) Lock(ctx context.Context) error {
+	names := make([]string, 0, len(stack.KetherObjectStates))
+	for name := range stack.KetherObjectStates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		err := stack.KetherObjectStates[name].Lock(ctx)
+		if err != nil {
+			stack.Unlock(ctx)
+			return err
+		}
+	}
+	return nil
+}
+
+// Unlock 释放栈中所有对象的租约锁
+func (stack *Stack) Unlock(ctx context.Context) {
+	for _, ketherObjectState := range stack.KetherObjectStates {
+		ketherObjectState.Unlock(ctx)
+	}
+}
+
+// RegisterStack 解析 YAML 文件并注册其中的所有 Kether 对象，返回时仍持有所有对象的租约锁，
+// 调用方在部署完成后调用 Unlock 释放
+func RegisterStack(ctx context.Context, yamlPath string) (*Stack, error) {
+	stack, err := ParseStack(yamlPath)
+	if err != nil {
+		log.Error("fail to parse yaml file", "yamlPath", yamlPath, "err", err)
+		return nil, err
+	}
+	err = stack.Lock(ctx)
+	if err != nil {
+		log.Error("fail to lock kether objects", "yamlPath", yamlPath, "err", err)
+		return nil, err
+	}
+	for _, ketherObject := range stack.KetherObjects {
+		err = registerKetherObject(ctx, stack.KetherObjectStates[ketherObject.Name])
+		if err != nil {
+			log.Error("fail to register kether object", "name", ketherObject.Name, "err", err)
+			stack.Unlock(ctx)
+			return nil, err
+		}
+	}
+	return stack, nil
+}
+
+// DeployStack 按依赖关系的拓扑顺序部署栈中的对象，互不依赖的对象并行部署；依赖的对象部署失败时，对象被标记为部署失败
+func DeployStack(ctx context.Context, stack *Stack) error {
+	dryRun := ctx.Value(flag.ContextKey).(flag.ContextValType).DryRun
+	deployOrder, err := stack.GetDeployOrder()
+	if err != nil {
+		log.Error("fail to get deploy order of stack", "err", err)
+		return err
+	}
+	log.Info("kether objects will be deployed in order", "deployOrder", deployOrder)
+
+	errs := stack.runInDependencyOrder(false, func(ketherObject *KetherObject, ketherObjectState *KetherObjectState) error {
+		return Deploy(ctx, ketherObject, ketherObjectState)
+	}, func(ketherObject *KetherObject, ketherObjectState *KetherObjectState, err error) error {
+		err = fmt.Errorf("dependency %v", err)
+		log.Error("skip deploying kether object", "name", ketherObject.Name, "err", err)
+		if !dryRun {
+			ketherObjectState.SetState(ctx, FAIL_TO_DEPLOY, err.Error())
+		}
+		return err
+	})
+	return stack.getStackError(errs)
+}
+
+// UndeployStack 按部署的逆序卸载栈中的对象；依赖它的对象卸载失败时，对象不会被卸载
+func UndeployStack(ctx context.Context, stack *Stack, removeVolumes bool) error {
+	errs := stack.runInDependencyOrder(true, func(ketherObject *KetherObject, ketherObjectState *KetherObjectState) error {
+		return Undeploy(ctx, ketherObject, ketherObjectState, removeVolumes)
+	}, func(ketherObject *KetherObject, ketherObjectState *KetherObjectState, err error) error {
+		err = fmt.Errorf("dependent %v", err)
+		log.Error("skip undeploying kether object", "name", ketherObject.Name, "err", err)
+		return err
+	})
+	return stack.getStackError(errs)
+}
@@ -0,0 +1,152 @@
+/*
+Copyright (c) 2022 Zhang Zhanpeng <zhangregister@outlook.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package object
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/MonteCarloClub/kether/registry"
+	"github.com/stretchr/testify/assert"
+)
+
+const testStackYaml = `
+name: testnet
+kind: stack
+objects:
+  - name: bootnode
+    predicate:
+      repository: ethereum/client-go
+    requirement:
+      detach: true
+  - name: validator-0
+    predicate:
+      repository: ethereum/client-go
+    requirement:
+      detach: true
+    depends_on: [bootnode]
+  - name: validator-1
+    predicate:
+      repository: ethereum/client-go
+    requirement:
+      detach: true
+    depends_on: [bootnode]
+---
+name: explorer
+predicate:
+  repository: ethereum/client-go
+requirement:
+  detach: true
+depends_on: [validator-0, validator-1]
+`
+
+func writeTestYaml(t *testing.T, yamlString string) string {
+	yamlPath := filepath.Join(t.TempDir(), "stack.yml")
+	assert.Nil(t, ioutil.WriteFile(yamlPath, []byte(yamlString), 0644))
+	return yamlPath
+}
+
+func TestParseStack(t *testing.T) {
+	stack, err := ParseStack(writeTestYaml(t, testStackYaml))
+	assert.Nil(t, err)
+	assert.Len(t, stack.KetherObjects, 4)
+	deployOrder, err := stack.GetDeployOrder()
+	assert.Nil(t, err)
+	assert.Equal(t, [][]string{{"bootnode"}, {"validator-0", "validator-1"}, {"explorer"}}, deployOrder)
+
+	testCases := map[string]string{
+		"cycle": `
+name: a
+depends_on: [b]
+---
+name: b
+depends_on: [a]
+`,
+		"unknown dependency": `
+name: a
+depends_on: [c]
+`,
+		"self dependency": `
+name: a
+depends_on: [a]
+`,
+		"duplicate name": `
+name: a
+---
+name: a
+`,
+	}
+	for name, yamlString := range testCases {
+		_, err = ParseStack(writeTestYaml(t, yamlString))
+		assert.NotNil(t, err, name)
+	}
+
+	_, _, err = ParseYaml(writeTestYaml(t, testStackYaml))
+	assert.NotNil(t, err)
+}
+
+func TestDeployStack(t *testing.T) {
+	fakeEngine, ctx := newTestEnv(t)
+	fakeEngine.RemoteImages["ethereum/client-go"] = true
+	fakeEngine.Errors["CreateContainer validator-1"] = fmt.Errorf("no space left on device")
+	stack, err := RegisterStack(ctx, writeTestYaml(t, testStackYaml))
+	assert.Nil(t, err)
+	err = DeployStack(ctx, stack)
+	assert.NotNil(t, err)
+
+	expectStates := map[string]KetherObjectStateType{
+		"bootnode":    DEPLOYED,
+		"validator-0": DEPLOYED,
+		"validator-1": FAIL_TO_DEPLOY,
+		"explorer":    FAIL_TO_DEPLOY,
+	}
+	for name, expectState := range expectStates {
+		value, ok, err := registry.GetStateOfName(ctx, name)
+		assert.Nil(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, fmt.Sprint(int(expectState)), value, name)
+	}
+
+	createCalls := make([]string, 0)
+	for _, call := range fakeEngine.CallsWithTarget() {
+		if strings.HasPrefix(call, "CreateContainer ") {
+			createCalls = append(createCalls, call)
+		}
+	}
+	assert.Equal(t, "CreateContainer bootnode", createCalls[0])
+	assert.ElementsMatch(t, []string{"CreateContainer bootnode", "CreateContainer validator-0", "CreateContainer validator-1"}, createCalls)
+
+	delete(fakeEngine.Errors, "CreateContainer validator-1")
+	err = UndeployStack(ctx, stack, false)
+	assert.Nil(t, err)
+	stopCalls := make([]string, 0)
+	for _, call := range fakeEngine.CallsWithTarget() {
+		if strings.HasPrefix(call, "StopContainer ") {
+			stopCalls = append(stopCalls, call)
+		}
+	}
+	assert.Equal(t, "StopContainer explorer", stopCalls[0])
+	assert.Equal(t, "StopContainer bootnode", stopCalls[3])
+}
@@ -0,0 +1,204 @@
+/*
+Copyright (c) 2022 Zhang Zhanpeng <zhangregister@outlook.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package object
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/MonteCarloClub/kether/log"
+	"github.com/MonteCarloClub/kether/registry"
+)
+
+// KetherObjectStateType Kether 对象状态类型，成功状态和对应的失败状态的值互为相反数，状态转换中的状态没有对应的失败状态
+type KetherObjectStateType int8
+
+const (
+	FAIL_TO_UNDEPLOY KetherObjectStateType = -3
+	FAIL_TO_DEPLOY   KetherObjectStateType = -2
+	FAIL_TO_REGISTER KetherObjectStateType = -1
+	UNREGISTERED     KetherObjectStateType = 0
+	REGISTERED       KetherObjectStateType = 1
+	DEPLOYED         KetherObjectStateType = 2
+	UNDEPLOYED       KetherObjectStateType = 3
+	REGISTERING      KetherObjectStateType = 4
+	PULLING          KetherObjectStateType = 5
+	CREATING         KetherObjectStateType = 6
+	STARTING         KetherObjectStateType = 7
+	STOPPING         KetherObjectStateType = 8
+	UPDATING         KetherObjectStateType = 9
+)
+
+var ketherObjectStateTypeNames = map[KetherObjectStateType]string{
+	FAIL_TO_UNDEPLOY: "FAIL_TO_UNDEPLOY",
+	FAIL_TO_DEPLOY:   "FAIL_TO_DEPLOY",
+	FAIL_TO_REGISTER: "FAIL_TO_REGISTER",
+	UNREGISTERED:     "UNREGISTERED",
+	REGISTERED:       "REGISTERED",
+	DEPLOYED:         "DEPLOYED",
+	UNDEPLOYED:       "UNDEPLOYED",
+	REGISTERING:      "REGISTERING",
+	PULLING:          "PULLING",
+	CREATING:         "CREATING",
+	STARTING:         "STARTING",
+	STOPPING:         "STOPPING",
+	UPDATING:         "UPDATING",
+}
+
+// ketherObjectStateTransitions 是允许的状态转换，
+// 任何已注册的对象都可以被卸载，以便恢复被中断的部署
+var ketherObjectStateTransitions = map[KetherObjectStateType][]KetherObjectStateType{
+	UNREGISTERED:     {REGISTERING},
+	REGISTERING:      {REGISTERED, FAIL_TO_REGISTER, STOPPING},
+	FAIL_TO_REGISTER: {REGISTERING, STOPPING},
+	REGISTERED:       {REGISTERING, PULLING, CREATING, FAIL_TO_DEPLOY, STOPPING},
+	PULLING:          {CREATING, FAIL_TO_DEPLOY, STOPPING},
+	CREATING:         {STARTING, FAIL_TO_DEPLOY, STOPPING},
+	STARTING:         {DEPLOYED, FAIL_TO_DEPLOY, STOPPING},
+	DEPLOYED:         {UPDATING, STOPPING},
+	UPDATING:         {PULLING, CREATING, FAIL_TO_DEPLOY, STOPPING},
+	FAIL_TO_DEPLOY:   {REGISTERING, STOPPING},
+	STOPPING:         {UNDEPLOYED, FAIL_TO_UNDEPLOY, STOPPING},
+	UNDEPLOYED:       {REGISTERING, STOPPING},
+	FAIL_TO_UNDEPLOY: {STOPPING},
+}
+
+func (state KetherObjectStateType) String() string {
+	if name, ok := ketherObjectStateTypeNames[state]; ok {
+		return name
+	}
+	return fmt.Sprintf("UNKNOWN(%d)", int8(state))
+}
+
+// CanTransitionTo 检查状态转换是否合法
+func (state KetherObjectStateType) CanTransitionTo(to KetherObjectStateType) bool {
+	for _, allowed := range ketherObjectStateTransitions[state] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseKetherObjectStateType 解析注册表中存储的状态值
+func ParseKetherObjectStateType(value string) (KetherObjectStateType, error) {
+	state, err := strconv.ParseInt(value, 10, 8)
+	if err != nil {
+		return UNREGISTERED, fmt.Errorf("invalid kether object state %q: %v", value, err)
+	}
+	return KetherObjectStateType(state), nil
+}
+
+// getStateOfName 读取注册表中的 Kether 对象状态及其存储值，对象未注册时返回 UNREGISTERED 和空字符串
+func getStateOfName(ctx context.Context, name string) (KetherObjectStateType, string, error) {
+	value, ok, err := registry.GetStateOfName(ctx, name)
+	if err != nil {
+		return UNREGISTERED, "", err
+	}
+	if !ok {
+		return UNREGISTERED, "", nil
+	}
+	state, err := ParseKetherObjectStateType(value)
+	return state, value, err
+}
+
+// KetherObjectState 是 Kether 对象状态
+type KetherObjectState struct {
+	Name  string
+	State KetherObjectStateType
+
+	// lease 是持有的租约锁，状态更新时用于拒绝过期持有者的写入
+	lease        *registry.Lease
+	stopRenewing chan struct{}
+}
+
+// SetState 把 Kether 对象从注册表中的当前状态转换到 state，拒绝非法的状态转换，并记录状态转换历史；
+// 状态以比较并设置的方式更新，读取后被其他写入者修改或租约锁已经丢失时返回错误
+func (ketherObjectState *KetherObjectState) SetState(ctx context.Context, state KetherObjectStateType, reason string) error {
+	from, fromValue, err := getStateOfName(ctx, ketherObjectState.Name)
+	if err != nil {
+		log.Error("fail to get state of kether object", "name", ketherObjectState.Name, "err", err)
+		return err
+	}
+	if !from.CanTransitionTo(state) {
+		err = fmt.Errorf("kether object %v cannot transition from %v to %v", ketherObjectState.Name, from, state)
+		log.Error("illegal state transition of kether object", "name", ketherObjectState.Name, "from", from, "to", state, "err", err)
+		return err
+	}
+
+	err = registry.CompareAndSetStateOfName(ctx, ketherObjectState.Name, fromValue, strconv.Itoa(int(state)), ketherObjectState.lease)
+	if err != nil {
+		log.Error("fail to set state of kether object", "name", ketherObjectState.Name, "state", state, "err", err)
+		return err
+	}
+	ketherObjectState.State = state
+	log.Info("state of kether object set", "name", ketherObjectState.Name, "from", from, "to", state, "reason", reason)
+
+	// 状态已经转换，历史记录失败不影响后续操作
+	err = registry.AppendHistoryOfName(ctx, ketherObjectState.Name, registry.HistoryEntry{
+		Timestamp: time.Now().UTC(),
+		From:      strconv.Itoa(int(from)),
+		To:        strconv.Itoa(int(state)),
+		Reason:    reason,
+	})
+	if err != nil {
+		log.Warn("fail to append history of kether object", "name", ketherObjectState.Name, "err", err)
+	}
+	return nil
+}
+
+// KetherObjectTransition 是 Kether 对象的一次状态转换
+type KetherObjectTransition struct {
+	Timestamp time.Time `json:"timestamp" yaml:"timestamp"`
+	From      string    `json:"from" yaml:"from"`
+	To        string    `json:"to" yaml:"to"`
+	Reason    string    `json:"reason,omitempty" yaml:"reason,omitempty"`
+}
+
+func getStateName(value string) string {
+	state, err := ParseKetherObjectStateType(value)
+	if err != nil {
+		return value
+	}
+	return state.String()
+}
+
+// GetHistory 按时间顺序返回 Kether 对象的状态转换历史
+func GetHistory(ctx context.Context, name string) ([]*KetherObjectTransition, error) {
+	history, err := registry.GetHistoryOfName(ctx, name)
+	if err != nil {
+		log.Error("fail to get history of kether object", "name", name, "err", err)
+		return nil, err
+	}
+	transitions := make([]*KetherObjectTransition, 0, len(history))
+	for _, entry := range history {
+		transitions = append(transitions, &KetherObjectTransition{
+			Timestamp: entry.Timestamp,
+			From:      getStateName(entry.From),
+			To:        getStateName(entry.To),
+			Reason:    entry.Reason,
+		})
+	}
+	return transitions, nil
+}
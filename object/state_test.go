@@ -0,0 +1,84 @@
+/*
+Copyright (c) 2022 Zhang Zhanpeng <zhangregister@outlook.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package object
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCanTransitionTo(t *testing.T) {
+	assert.True(t, UNREGISTERED.CanTransitionTo(REGISTERING))
+	assert.False(t, UNREGISTERED.CanTransitionTo(REGISTERED))
+	assert.False(t, UNREGISTERED.CanTransitionTo(STOPPING))
+	assert.True(t, REGISTERED.CanTransitionTo(PULLING))
+	assert.True(t, REGISTERED.CanTransitionTo(CREATING))
+	assert.False(t, REGISTERED.CanTransitionTo(DEPLOYED))
+	assert.False(t, DEPLOYED.CanTransitionTo(REGISTERING))
+	assert.True(t, DEPLOYED.CanTransitionTo(STOPPING))
+	assert.True(t, PULLING.CanTransitionTo(STOPPING))
+	assert.False(t, FAIL_TO_UNDEPLOY.CanTransitionTo(REGISTERING))
+
+	for state := range ketherObjectStateTypeNames {
+		_, ok := ketherObjectStateTransitions[state]
+		assert.True(t, ok, state.String())
+	}
+}
+
+func TestStateHistory(t *testing.T) {
+	fakeEngine, ctx := newTestEnv(t)
+	delete(fakeEngine.LocalImages, testImageName)
+	fakeEngine.RemoteImages[testImageName] = true
+
+	ketherObject, ketherObjectState := getTestKetherObject(true, false)
+	assert.Nil(t, registerKetherObject(ctx, ketherObjectState))
+	assert.Nil(t, Deploy(ctx, ketherObject, ketherObjectState))
+
+	err := registerKetherObject(ctx, ketherObjectState)
+	assert.EqualError(t, err, "kether object deploy-test is already deployed, use kether apply to update it or kether undeploy to remove it first")
+	assert.Equal(t, DEPLOYED, ketherObjectState.State)
+
+	assert.Nil(t, Undeploy(ctx, ketherObject, ketherObjectState, false))
+	assert.Nil(t, Undeploy(ctx, ketherObject, ketherObjectState, false))
+
+	transitions, err := GetHistory(ctx, ketherObject.Name)
+	assert.Nil(t, err)
+	states := make([]string, 0)
+	for _, transition := range transitions {
+		states = append(states, transition.From+"->"+transition.To)
+	}
+	assert.Equal(t, []string{
+		"UNREGISTERED->REGISTERING",
+		"REGISTERING->REGISTERED",
+		"REGISTERED->PULLING",
+		"PULLING->CREATING",
+		"CREATING->STARTING",
+		"STARTING->DEPLOYED",
+		"DEPLOYED->STOPPING",
+		"STOPPING->UNDEPLOYED",
+		"UNDEPLOYED->STOPPING",
+		"STOPPING->UNDEPLOYED",
+	}, states)
+	assert.Equal(t, testImageName, transitions[2].Reason)
+	assert.Equal(t, "container not found", transitions[9].Reason)
+}
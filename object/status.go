@@ -0,0 +1,134 @@
+/*
+Copyright (c) 2022 Zhang Zhanpeng <zhangregister@outlook.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package object
+
+import (
+	"context"
+
+	"github.com/MonteCarloClub/kether/container"
+	"github.com/MonteCarloClub/kether/log"
+	"github.com/MonteCarloClub/kether/registry"
+)
+
+// ContainerStatus 是 Docker 引擎中容器的实际状态
+type ContainerStatus struct {
+	Exists    bool   `json:"exists" yaml:"exists"`
+	ID        string `json:"id,omitempty" yaml:"id,omitempty"`
+	Status    string `json:"status,omitempty" yaml:"status,omitempty"`
+	Running   bool   `json:"running" yaml:"running"`
+	ExitCode  int    `json:"exit_code" yaml:"exit_code"`
+	StartedAt string `json:"started_at,omitempty" yaml:"started_at,omitempty"`
+}
+
+// ImageStatus 是注册表中记录的最近一次部署实际运行的镜像
+type ImageStatus struct {
+	Name   string `json:"name" yaml:"name"`
+	Digest string `json:"digest,omitempty" yaml:"digest,omitempty"`
+	ID     string `json:"id,omitempty" yaml:"id,omitempty"`
+}
+
+// KetherObjectStatus 对照注册表中的 Kether 对象状态和容器的实际状态，Ports 是最近一次部署时容器实际绑定的主机端口
+type KetherObjectStatus struct {
+	Name       string          `json:"name" yaml:"name"`
+	Registered bool            `json:"registered" yaml:"registered"`
+	State      string          `json:"state" yaml:"state"`
+	Image      *ImageStatus    `json:"image,omitempty" yaml:"image,omitempty"`
+	Ports      []string        `json:"ports,omitempty" yaml:"ports,omitempty"`
+	Container  ContainerStatus `json:"container" yaml:"container"`
+}
+
+func GetStatus(ctx context.Context, name string) (*KetherObjectStatus, error) {
+	ketherObjectStatus := &KetherObjectStatus{
+		Name:  name,
+		State: UNREGISTERED.String(),
+	}
+
+	value, ok, err := registry.GetStateOfName(ctx, name)
+	if err != nil {
+		log.Error("fail to get state of kether object", "name", name, "err", err)
+		return nil, err
+	}
+	if ok {
+		state, err := ParseKetherObjectStateType(value)
+		if err != nil {
+			log.Warn("invalid state of kether object", "name", name, "value", value, "err", err)
+			ketherObjectStatus.State = value
+		} else {
+			ketherObjectStatus.State = state.String()
+		}
+		ketherObjectStatus.Registered = true
+	}
+
+	deployment, ok, err := registry.GetDeploymentOfName(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		ketherObjectStatus.Image = &ImageStatus{
+			Name:   deployment.Image,
+			Digest: deployment.ImageDigest,
+			ID:     deployment.ImageID,
+		}
+		for _, portBinding := range deployment.Ports {
+			ketherObjectStatus.Ports = append(ketherObjectStatus.Ports, formatPortBinding(portBinding))
+		}
+	}
+
+	ketherObject, _ := GetKetherObjectOfName(name)
+	containerJSON, ok, err := container.InspectDockerContainer(ctx, ketherObject.GetContainerName())
+	if err != nil {
+		log.Error("fail to inspect container of kether object", "name", name, "err", err)
+		return nil, err
+	}
+	if ok {
+		ketherObjectStatus.Container = ContainerStatus{
+			Exists: true,
+			ID:     containerJSON.ID,
+		}
+		if containerJSON.State != nil {
+			ketherObjectStatus.Container.Status = containerJSON.State.Status
+			ketherObjectStatus.Container.Running = containerJSON.State.Running
+			ketherObjectStatus.Container.ExitCode = containerJSON.State.ExitCode
+			ketherObjectStatus.Container.StartedAt = containerJSON.State.StartedAt
+		}
+	}
+	return ketherObjectStatus, nil
+}
+
+func ListStatus(ctx context.Context) ([]*KetherObjectStatus, error) {
+	names, err := registry.ListNames(ctx)
+	if err != nil {
+		log.Error("fail to list names of kether objects", "err", err)
+		return nil, err
+	}
+
+	ketherObjectStatusList := make([]*KetherObjectStatus, 0, len(names))
+	for _, name := range names {
+		ketherObjectStatus, err := GetStatus(ctx, name)
+		if err != nil {
+			log.Error("fail to get status of kether object", "name", name, "err", err)
+			return nil, err
+		}
+		ketherObjectStatusList = append(ketherObjectStatusList, ketherObjectStatus)
+	}
+	return ketherObjectStatusList, nil
+}
@@ -0,0 +1,96 @@
+/*
+Copyright (c) 2022 Zhang Zhanpeng <zhangregister@outlook.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package object
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/MonteCarloClub/kether/container"
+	"github.com/MonteCarloClub/kether/flag"
+	"github.com/MonteCarloClub/kether/log"
+	"github.com/MonteCarloClub/kether/registry"
+)
+
+func Undeploy(ctx context.Context, ketherObject *KetherObject, ketherObjectState *KetherObjectState, removeVolumes bool) error {
+	containerName := ketherObject.GetContainerName()
+
+	if ctx.Value(flag.ContextKey).(flag.ContextValType).DryRun {
+		log.Info("container will be stopped and removed", "containerName", containerName, "removeVolumes", removeVolumes)
+		log.Info("undeploying kether object in dry run mode will not change any state")
+		return nil
+	}
+
+	release, err := ketherObjectState.lock(ctx)
+	if err != nil {
+		log.Error("fail to lock kether object", "name", ketherObject.Name, "err", err)
+		return err
+	}
+	defer release()
+
+	err = ketherObjectState.SetState(ctx, STOPPING, containerName)
+	if err != nil {
+		return err
+	}
+	exist, err := container.StopDockerContainer(ctx, containerName)
+	if err == nil && !exist {
+		// 替换时重命名失败的容器仍使用临时名称，按部署记录中的容器 ID 查找
+		deployment, recorded, getErr := registry.GetDeploymentOfName(ctx, ketherObject.Name)
+		if getErr == nil && recorded && deployment.ContainerID != "" {
+			exist, err = container.StopDockerContainer(ctx, deployment.ContainerID)
+			if exist {
+				containerName = deployment.ContainerID
+			}
+		}
+	}
+	if err != nil {
+		log.Error("fail to stop docker container", "containerName", containerName, "err", err)
+		ketherObjectState.SetState(ctx, FAIL_TO_UNDEPLOY, fmt.Sprintf("fail to stop docker container: %v", err))
+		return err
+	}
+	reason := ""
+	if exist {
+		err = container.RemoveDockerContainer(ctx, containerName, removeVolumes)
+		if err != nil {
+			log.Error("fail to remove docker container", "containerName", containerName, "err", err)
+			ketherObjectState.SetState(ctx, FAIL_TO_UNDEPLOY, fmt.Sprintf("fail to remove docker container: %v", err))
+			return err
+		}
+		log.Info("container stopped and removed")
+	} else {
+		log.Warn("container of kether object not found", "containerName", containerName)
+		reason = "container not found"
+	}
+	err = registry.ReleasePortsOfName(ctx, ketherObject.Name, nil)
+	if err != nil {
+		ketherObjectState.SetState(ctx, FAIL_TO_UNDEPLOY, fmt.Sprintf("fail to release ports: %v", err))
+		return err
+	}
+
+	err = ketherObjectState.SetState(ctx, UNDEPLOYED, reason)
+	if err != nil {
+		log.Error("fail to set state of kether object", "name", ketherObjectState.Name, "state", ketherObjectState.State, "err", err)
+		return err
+	}
+	log.Info("state of kether object set", "name", ketherObjectState.Name, "state", ketherObjectState.State)
+	return nil
+}
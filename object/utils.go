@@ -28,11 +28,9 @@ import (
 
 	kethercontainer "github.com/MonteCarloClub/kether/container"
 	"github.com/MonteCarloClub/kether/log"
-	"github.com/MonteCarloClub/kether/machine"
 	"github.com/MonteCarloClub/kether/registry"
 	"github.com/docker/docker/api/types/container"
-	"github.com/docker/docker/api/types/network"
-	"github.com/docker/go-connections/nat"
+	"github.com/docker/docker/api/types/strslice"
 )
 
 type ResourceDescriptionEntity struct {
@@ -41,19 +39,43 @@ type ResourceDescriptionEntity struct {
 }
 
 type RunDescriptionEntity struct {
-	LocalImage  bool     `yaml:"local_image"`
-	Detach      bool     `yaml:"detach"`
-	NetworkList []string `yaml:"network_list"`
-	PublishList []string `yaml:"publish_list"`
-	VolumeList  []string `yaml:"volume_list"`
+	LocalImage  bool            `yaml:"local_image"`
+	Detach      bool            `yaml:"detach"`
+	NetworkList []NetworkEntity `yaml:"network_list"`
+	PublishList []PublishEntity `yaml:"publish_list"`
+	VolumeList  []MountEntity   `yaml:"volume_list"`
+
+	Command    CommandEntity     `yaml:"command"`
+	Entrypoint CommandEntity     `yaml:"entrypoint"`
+	Env        EnvironmentEntity `yaml:"env"`
+	EnvFile    []string          `yaml:"env_file"`
+	WorkingDir string            `yaml:"working_dir"`
+	User       string            `yaml:"user"`
+	Labels     map[string]string `yaml:"labels"`
+	Hostname   string            `yaml:"hostname"`
+	Tty        bool              `yaml:"tty"`
+	StdinOpen  bool              `yaml:"stdin_open"`
+
+	Cpus          string   `yaml:"cpus"`
+	CpuShares     int64    `yaml:"cpu_shares"`
+	Cpuset        string   `yaml:"cpuset"`
+	Memory        string   `yaml:"memory"`
+	MemorySwap    string   `yaml:"memory_swap"`
+	PidsLimit     int64    `yaml:"pids_limit"`
+	Ulimits       []string `yaml:"ulimits"`
+	ShmSize       string   `yaml:"shm_size"`
+	RestartPolicy string   `yaml:"restart_policy"`
 }
 
 type KetherObjectEntity struct {
-	Name        string                    `yaml:"name"`
-	Kind        string                    `yaml:"kind"`
-	Predicate   ResourceDescriptionEntity `yaml:"predicate"`
-	Priority    ResourceDescriptionEntity `yaml:"priority"`
-	Requirement RunDescriptionEntity      `yaml:"requirement"`
+	Name        string                     `yaml:"name"`
+	Kind        string                     `yaml:"kind"`
+	Predicate   ResourceDescriptionEntity  `yaml:"predicate"`
+	Priority    ResourceDescriptionEntity  `yaml:"priority"`
+	Requirement RunDescriptionEntity       `yaml:"requirement"`
+	DependsOn   []string                   `yaml:"depends_on"`
+	Healthcheck *HealthcheckEntity         `yaml:"healthcheck"`
+	Networks    []NetworkDeclarationEntity `yaml:"networks"`
 }
 
 // ResourceDescription 描述 Kether 对象的资源需求
@@ -67,43 +89,36 @@ type KetherObject struct {
 	Name                string
 	Predicate, Priority *ResourceDescription
 	Requirement         *RunDescription
-}
-
-// KetherObjectStateType Kether 对象状态类型
-type KetherObjectStateType int8
-
-const (
-	FAIL_TO_DEPLOY KetherObjectStateType = -2
-	UNREGISTERED   KetherObjectStateType = 0
-	REGISTERED     KetherObjectStateType = 1
-	DEPLOYED       KetherObjectStateType = 2
-	// TODO 新增后缀状态，包含状态转换中、成功和失败，建议成功和失败的状态值互为相反数
-)
-
-// KetherObjectState 是 Kether 对象状态
-type KetherObjectState struct {
-	Name  string
-	State KetherObjectStateType
+	// Dir 是 YAML 文件所在目录，用于解析 YAML 中的相对路径
+	Dir string
+	// DependsOn 是同一栈中必须先于本对象部署的对象名称
+	DependsOn []string
+	// Healthcheck 是健康检查和就绪条件，依赖本对象的对象在本对象就绪后部署
+	Healthcheck *Healthcheck
+	// Networks 是栈中声明的、本对象连接的网络，部署时不存在的网络按声明创建
+	Networks []*NetworkDeclaration
+
+	imageName string
+	// imageDigest 和 imageId 是部署时解析的镜像仓库摘要和镜像 ID
+	imageDigest string
+	imageId     string
+	// rollbackOf 是回滚的目标修订，由 Rollback 设置
+	rollbackOf int
+	// portReservations 是部署时在注册表中为对象预留的主机端口
+	portReservations []registry.PortReservation
 }
 
 func (ketherObjectEntity *KetherObjectEntity) GetKetherObject() *KetherObject {
+	predicate := ResourceDescription(ketherObjectEntity.Predicate)
+	priority := ResourceDescription(ketherObjectEntity.Priority)
+	requirement := RunDescription(ketherObjectEntity.Requirement)
 	return &KetherObject{
-		Name: ketherObjectEntity.Name,
-		Predicate: &ResourceDescription{
-			DockerImageRepository: ketherObjectEntity.Predicate.DockerImageRepository,
-			DockerImageTag:        ketherObjectEntity.Predicate.DockerImageTag,
-		},
-		Priority: &ResourceDescription{
-			DockerImageRepository: ketherObjectEntity.Priority.DockerImageRepository,
-			DockerImageTag:        ketherObjectEntity.Priority.DockerImageTag,
-		},
-		Requirement: &RunDescription{
-			LocalImage:  ketherObjectEntity.Requirement.LocalImage,
-			Detach:      ketherObjectEntity.Requirement.Detach,
-			NetworkList: ketherObjectEntity.Requirement.NetworkList,
-			PublishList: ketherObjectEntity.Requirement.PublishList,
-			VolumeList:  ketherObjectEntity.Requirement.VolumeList,
-		},
+		Name:        ketherObjectEntity.Name,
+		Predicate:   &predicate,
+		Priority:    &priority,
+		Requirement: &requirement,
+		DependsOn:   ketherObjectEntity.DependsOn,
+		Healthcheck: (*Healthcheck)(ketherObjectEntity.Healthcheck),
 	}
 }
 
@@ -113,6 +128,14 @@ func (ketherObjectEntity *KetherObjectEntity) GetKetherObjectState() *KetherObje
 	}
 }
 
+// GetKetherObjectOfName 根据名称构造 Kether 对象及其状态，用于不依赖 YAML 文件的操作
+func GetKetherObjectOfName(name string) (*KetherObject, *KetherObjectState) {
+	ketherObjectEntity := &KetherObjectEntity{
+		Name: name,
+	}
+	return ketherObjectEntity.GetKetherObject(), ketherObjectEntity.GetKetherObjectState()
+}
+
 func getImageName(repository string, tag string) string {
 	// assert: repository != ""
 	if tag == "" {
@@ -121,7 +144,12 @@ func getImageName(repository string, tag string) string {
 	return fmt.Sprintf("%v:%v", repository, tag)
 }
 
-func (ketherObject *KetherObject) GetImageName() string {
+// GetImageName 按优先级依次检查候选镜像名，返回第一个可用的镜像名，结果缓存在 Kether 对象中
+func (ketherObject *KetherObject) GetImageName(ctx context.Context) (string, error) {
+	if ketherObject.imageName != "" {
+		return ketherObject.imageName, nil
+	}
+
 	candidateRepository := make([]string, 0)
 	candidateTag := make([]string, 0)
 
@@ -141,138 +169,100 @@ func (ketherObject *KetherObject) GetImageName() string {
 	}
 	candidateTag = append(candidateTag, "")
 
+	rejectedReasons := make([]string, 0)
 	for _, repository := range candidateRepository {
 		for _, tag := range candidateTag {
 			candidateImageName := getImageName(repository, tag)
-			if kethercontainer.CheckIfDockerImageAvailable(candidateImageName) {
-				return candidateImageName
+			available, reason := kethercontainer.CheckIfDockerImageAvailable(ctx, candidateImageName, ketherObject.Requirement.LocalImage)
+			if available {
+				log.Info("image name chosen", "imageName", candidateImageName, "rejected", rejectedReasons)
+				ketherObject.imageName = candidateImageName
+				return candidateImageName, nil
 			}
+			log.Warn("candidate image name rejected", "imageName", candidateImageName, "reason", reason)
+			rejectedReasons = append(rejectedReasons, fmt.Sprintf("%v: %v", candidateImageName, reason))
 		}
 	}
-	log.Warn("no available image name specified", "candidateRepository", candidateRepository, "candidateTag", candidateTag)
-	return ""
+	err := fmt.Errorf("no available image name specified, rejected candidates: [%v]", strings.Join(rejectedReasons, "; "))
+	log.Warn("no available image name specified", "candidateRepository", candidateRepository, "candidateTag", candidateTag, "err", err)
+	return "", err
 }
 
-func (ketherObject *KetherObject) GetContainerAndHostConfig() (*container.Config, *container.HostConfig) {
-	publishList := ketherObject.Requirement.PublishList
-	hostPortMap := make(map[string]string, len(publishList))           // 主机端口 -> 容器端口
-	containerPortMap := make(map[string]nat.PortSet, len(publishList)) // 容器端口 -> 主机端口集
-	for _, portPair := range publishList {
-		portSlice := strings.Split(portPair, ":")
-		if len(portSlice) != 2 {
-			log.Warn("invalid port map", "portPair", portPair)
-			continue
-		}
-		// 同一主机端口不能被不同容器端口映射
-		if _, ok := hostPortMap[portSlice[0]]; ok {
-			if hostPortMap[portSlice[0]] != portSlice[1] {
-				log.Warn("host port conflict, the latter container port will be ignored", "host port", portSlice[0], "container ports", fmt.Sprintf("%v and %v", hostPortMap[portSlice[0]], portSlice[1]))
-				continue
-			}
-		} else {
-			hostPortMap[portSlice[0]] = portSlice[1]
-		}
-	}
-	for hostPort, containerPort := range hostPortMap {
-		if _, ok := containerPortMap[containerPort]; !ok {
-			containerPortMap[containerPort] = make(nat.PortSet)
-		}
-		containerPortMap[containerPort][nat.Port(hostPort)] = struct{}{}
+func (ketherObject *KetherObject) GetContainerAndHostConfig(ctx context.Context) (*container.Config, *container.HostConfig, error) {
+	imageName, err := ketherObject.GetImageName(ctx)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	exposedPorts := make(nat.PortSet) // 容器端口列表
-	portBindings := make(nat.PortMap) // 容器端口列表 -> 主机端口集列表
-	for containerPort, hostPortSet := range containerPortMap {
-		if containerPort == "" {
-			log.Warn("no exposed port, ignored")
-			continue
-		}
-		exposedPorts[nat.Port(containerPort)] = struct{}{}
-
-		portBindingsValue := make([]nat.PortBinding, 0)
-		var ifhostPortAvailable bool
-		if len(hostPortSet) == 0 {
-			altHostPort := machine.GetAvailableHostPort()
-			if altHostPort == "" {
-				log.Warn("fail to get alternate host port")
-				continue
-			}
-			hostPortSet[nat.Port(altHostPort)] = struct{}{}
-			ifhostPortAvailable = true
-			log.Info("no available host port specified", "alternate host port", altHostPort)
-		}
-		for hostPort := range hostPortSet {
-			var portBindingsValueElem nat.PortBinding
-			if ifhostPortAvailable || machine.CheckIfHostPortAvailable(string(hostPort)) {
-				portBindingsValueElem.HostPort = string(hostPort)
-			} else {
-				altHostPort := nat.Port(machine.GetAvailableHostPort())
-				if altHostPort == "" {
-					log.Warn("fail to get alternate host port", "unavailable host port", hostPort)
-					continue
-				}
-				portBindingsValueElem.HostPort = string(altHostPort)
-				log.Info("specified host port unavailable", "specified host port", hostPort, "alternate host port", altHostPort)
-			}
-			portBindingsValue = append(portBindingsValue, portBindingsValueElem)
-		}
-		portBindings[nat.Port(containerPort)] = portBindingsValue
+	exposedPorts, portBindings, err := ketherObject.getPortBindings(ctx)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	containerConfig := &container.Config{
-		Image:        ketherObject.GetImageName(),
-		ExposedPorts: exposedPorts,
+	env, err := ketherObject.getEnv()
+	if err != nil {
+		return nil, nil, err
 	}
-	hostConfig := &container.HostConfig{
-		PortBindings: portBindings,
+	env, err = resolvePeerPorts(ctx, env, true)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	volumeList := ketherObject.Requirement.VolumeList
-	if len(volumeList) > 0 {
-		hostConfig.Binds = volumeList
+	healthConfig, _, fieldErrors := getHealthConfig((*HealthcheckEntity)(ketherObject.Healthcheck))
+	if len(fieldErrors) > 0 {
+		return nil, nil, fieldErrors
 	}
 
-	return containerConfig, hostConfig
-}
+	configHash, err := ketherObject.GetConfigHash(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	labels := make(map[string]string, len(ketherObject.Requirement.Labels)+1)
+	for key, value := range ketherObject.Requirement.Labels {
+		labels[key] = value
+	}
+	labels[ConfigHashLabel] = configHash
 
-func (ketherObject *KetherObject) GetNetworkingConfig() *network.NetworkingConfig {
-	networkList := ketherObject.Requirement.NetworkList
-	if len(networkList) == 0 {
-		log.Info("empty network list")
-		return nil
+	containerConfig := &container.Config{
+		Image:        imageName,
+		ExposedPorts: exposedPorts,
+		Cmd:          strslice.StrSlice(ketherObject.Requirement.Command),
+		Entrypoint:   strslice.StrSlice(ketherObject.Requirement.Entrypoint),
+		Env:          env,
+		WorkingDir:   ketherObject.Requirement.WorkingDir,
+		User:         ketherObject.Requirement.User,
+		Labels:       labels,
+		Hostname:     ketherObject.Requirement.Hostname,
+		Tty:          ketherObject.Requirement.Tty,
+		OpenStdin:    ketherObject.Requirement.StdinOpen,
+		Healthcheck:  healthConfig,
+	}
+	resources, fieldErrors := getHostResources(RunDescriptionEntity(*ketherObject.Requirement))
+	if len(fieldErrors) > 0 {
+		return nil, nil, fieldErrors
+	}
+	hostConfig := &container.HostConfig{
+		NetworkMode:   container.NetworkMode(ketherObject.getNetworkMode()),
+		PortBindings:  portBindings,
+		Resources:     resources.Resources,
+		RestartPolicy: resources.RestartPolicy,
+		ShmSize:       resources.ShmSize,
 	}
 
-	// assert: len(networkList) < 2
-	// TODO 支持把同一容器部署到不同网络，对网络名和网关去重，确认网络和网关是否存在
-	endpointsConfig := make(map[string]*network.EndpointSettings)
-	for _, networkGatewayPair := range networkList {
-		networkSlice := strings.Split(networkGatewayPair, ":")
-		if len(networkSlice) != 2 {
-			log.Warn("invalid network-gateway pair", "networkGatewayPair", networkGatewayPair)
-			continue
-		}
-		endpointsConfig[networkSlice[0]] = &network.EndpointSettings{
-			Gateway: networkSlice[1],
-		}
+	mounts, binds, err := ketherObject.getMounts()
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(mounts) > 0 {
+		hostConfig.Mounts = mounts
 	}
-	networkingConfig := &network.NetworkingConfig{
-		EndpointsConfig: endpointsConfig,
+	if len(binds) > 0 {
+		hostConfig.Binds = binds
 	}
-	return networkingConfig
+
+	return containerConfig, hostConfig, nil
 }
 
 func (ketherObject *KetherObject) GetContainerName() string {
 	return ketherObject.Name
 }
-
-func (ketherObjectState *KetherObjectState) SetState(ctx context.Context, state KetherObjectStateType) error {
-	ketherObjectState.State = state
-
-	err := registry.SetStateOfName(ctx, ketherObjectState.Name, fmt.Sprint(ketherObjectState.State))
-	if err != nil {
-		log.Error("fail to set state of kether object", "name", ketherObjectState.Name, "state", ketherObjectState.State, "err", err)
-		return err
-	}
-	log.Info("state of kether object set", "name", ketherObjectState.Name, "state", ketherObjectState.State)
-	return nil
-}
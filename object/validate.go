@@ -0,0 +1,379 @@
+/*
+Copyright (c) 2022 Zhang Zhanpeng <zhangregister@outlook.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package object
+
+import (
+	"fmt"
+	"net"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/go-connections/nat"
+)
+
+// FieldError 是 YAML 中某个字段的错误，Field 是字段路径，如 requirement.env[0]，
+// Line 和 Column 是字段在 YAML 文件中的位置，从 1 开始，未定位时为 0
+type FieldError struct {
+	Field   string
+	Message string
+	Line    int
+	Column  int
+}
+
+func (fieldError *FieldError) Error() string {
+	if fieldError.Field == "" {
+		return fieldError.Message
+	}
+	return fmt.Sprintf("%v: %v", fieldError.Field, fieldError.Message)
+}
+
+// FieldErrors 是多个字段错误
+type FieldErrors []*FieldError
+
+func (fieldErrors FieldErrors) Error() string {
+	messages := make([]string, 0, len(fieldErrors))
+	for _, fieldError := range fieldErrors {
+		messages = append(messages, fieldError.Error())
+	}
+	return strings.Join(messages, "; ")
+}
+
+func (fieldErrors *FieldErrors) add(field string, format string, args ...interface{}) {
+	*fieldErrors = append(*fieldErrors, &FieldError{
+		Field:   field,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+func prefixFieldErrors(fieldErrors FieldErrors, prefix string) FieldErrors {
+	prefixedFieldErrors := make(FieldErrors, 0, len(fieldErrors))
+	for _, fieldError := range fieldErrors {
+		prefixedFieldErrors = append(prefixedFieldErrors, &FieldError{
+			Field:   prefix + fieldError.Field,
+			Message: fieldError.Message,
+			Line:    fieldError.Line,
+			Column:  fieldError.Column,
+		})
+	}
+	return prefixedFieldErrors
+}
+
+var (
+	hostnameRegexp   = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?$`)
+	userRegexp       = regexp.MustCompile(`^[a-zA-Z0-9_.-]+(:[a-zA-Z0-9_.-]+)?$`)
+	volumeNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]+$`)
+)
+
+// volumeModes 是 `docker run -v` 支持的挂载选项
+var volumeModes = map[string]bool{
+	"ro": true, "rw": true, "z": true, "Z": true, "nocopy": true,
+	"shared": true, "rshared": true, "slave": true, "rslave": true, "private": true, "rprivate": true,
+	"consistent": true, "cached": true, "delegated": true,
+}
+
+// Validate 检查 YAML 中各字段的取值，返回所有字段错误
+func (ketherObjectEntity *KetherObjectEntity) Validate() error {
+	fieldErrors := make(FieldErrors, 0)
+	requirement := &ketherObjectEntity.Requirement
+
+	if ketherObjectEntity.Name == "" {
+		fieldErrors.add("name", "empty name")
+	}
+	switch ketherObjectEntity.Kind {
+	case "", KindDeploy:
+	case KindStack:
+		fieldErrors.add("kind", "stack cannot be nested in another stack")
+	default:
+		fieldErrors.add("kind", "unknown kind %q, expect %v or %v", ketherObjectEntity.Kind, KindDeploy, KindStack)
+	}
+	if ketherObjectEntity.Predicate.DockerImageRepository == "" && ketherObjectEntity.Priority.DockerImageRepository == "" {
+		fieldErrors.add("predicate.repository", "no repository specified in predicate or priority")
+	}
+	for i, dependency := range ketherObjectEntity.DependsOn {
+		if dependency == ketherObjectEntity.Name {
+			fieldErrors.add(fmt.Sprintf("depends_on[%v]", i), "%v depends on itself", dependency)
+		}
+	}
+
+	// 以 host_port/protocol 索引已发布的端口映射，tcp 和 udp 可以使用同一主机端口
+	publishedHostPorts := make(map[string][]nat.PortMapping, len(requirement.PublishList))
+	for i, publishEntity := range requirement.PublishList {
+		if err := checkPortPolicy(publishEntity.Policy); err != nil {
+			fieldErrors.add(fmt.Sprintf("requirement.publish_list[%v].policy", i), "%v", err)
+		}
+		portMappings, err := parsePublish(publishEntity.Port)
+		if err != nil {
+			fieldErrors.add(fmt.Sprintf("requirement.publish_list[%v]", i), "%v", err)
+			continue
+		}
+		for _, portMapping := range portMappings {
+			hostPort := portMapping.Binding.HostPort
+			if hostPort == "" || isHostPortRange(hostPort) {
+				continue
+			}
+			key := fmt.Sprintf("%v/%v", hostPort, portMapping.Port.Proto())
+			if publishedPortMapping, ok := getOverlappingPortMapping(publishedHostPorts[key], portMapping); ok && publishedPortMapping.Port != portMapping.Port {
+				fieldErrors.add(fmt.Sprintf("requirement.publish_list[%v]", i), "host port %v is already published for container port %v", key, publishedPortMapping.Port)
+				break
+			}
+			publishedHostPorts[key] = append(publishedHostPorts[key], portMapping)
+		}
+	}
+	mountedTargets := make(map[string]bool, len(requirement.VolumeList))
+	for i, mountEntity := range requirement.VolumeList {
+		if err := checkMount(mountEntity); err != nil {
+			fieldErrors.add(fmt.Sprintf("requirement.volume_list[%v]", i), "%v", err)
+			continue
+		}
+		target := path.Clean(mountEntity.Target)
+		if mountedTargets[target] {
+			fieldErrors.add(fmt.Sprintf("requirement.volume_list[%v]", i), "target %v is mounted more than once", target)
+			continue
+		}
+		mountedTargets[target] = true
+	}
+	listedNetworks := make(map[string]NetworkEntity, len(requirement.NetworkList))
+	for i, networkEntity := range requirement.NetworkList {
+		if err := checkNetwork(networkEntity); err != nil {
+			fieldErrors.add(fmt.Sprintf("requirement.network_list[%v]", i), "%v", err)
+			continue
+		}
+		if listedNetwork, ok := listedNetworks[networkEntity.Name]; ok {
+			if !isSameNetwork(listedNetwork, networkEntity) {
+				fieldErrors.add(fmt.Sprintf("requirement.network_list[%v]", i), "network %v is listed more than once with different settings", networkEntity.Name)
+			}
+			continue
+		}
+		listedNetworks[networkEntity.Name] = networkEntity
+	}
+	// host 和 none 独占容器的网络栈，不能再连接其他网络
+	for i, networkEntity := range requirement.NetworkList {
+		if isExclusiveNetwork(networkEntity.Name) && len(listedNetworks) > 1 {
+			fieldErrors.add(fmt.Sprintf("requirement.network_list[%v]", i), "network %v cannot be combined with other networks", networkEntity.Name)
+		}
+	}
+	if _, ok := listedNetworks["host"]; ok && len(requirement.PublishList) > 0 {
+		fieldErrors.add("requirement.publish_list", "ports cannot be published on the host network")
+	}
+	for i, env := range requirement.Env {
+		if err := checkEnv(env); err != nil {
+			fieldErrors.add(fmt.Sprintf("requirement.env[%v]", i), "%v", err)
+		} else if err := checkPeerPorts(env); err != nil {
+			fieldErrors.add(fmt.Sprintf("requirement.env[%v]", i), "%v", err)
+		}
+	}
+	for i, envFile := range requirement.EnvFile {
+		if envFile == "" {
+			fieldErrors.add(fmt.Sprintf("requirement.env_file[%v]", i), "empty path")
+		}
+	}
+	if requirement.WorkingDir != "" && !path.IsAbs(requirement.WorkingDir) {
+		fieldErrors.add("requirement.working_dir", "%q is not an absolute path", requirement.WorkingDir)
+	}
+	if requirement.User != "" && !userRegexp.MatchString(requirement.User) {
+		fieldErrors.add("requirement.user", "%q should be in the form of user[:group]", requirement.User)
+	}
+	for key := range requirement.Labels {
+		if strings.TrimSpace(key) == "" {
+			fieldErrors.add("requirement.labels", "empty label key")
+		}
+	}
+	if requirement.Hostname != "" && !hostnameRegexp.MatchString(requirement.Hostname) {
+		fieldErrors.add("requirement.hostname", "%q is not a valid hostname", requirement.Hostname)
+	}
+	_, resourceFieldErrors := getHostResources(*requirement)
+	fieldErrors = append(fieldErrors, resourceFieldErrors...)
+	_, _, healthFieldErrors := getHealthConfig(ketherObjectEntity.Healthcheck)
+	fieldErrors = append(fieldErrors, healthFieldErrors...)
+
+	if len(fieldErrors) > 0 {
+		return fieldErrors
+	}
+	return nil
+}
+
+// getOverlappingPortMapping 返回主机 IP 与 portMapping 重叠的端口映射，监听所有地址的映射与任意主机 IP 重叠
+func getOverlappingPortMapping(portMappings []nat.PortMapping, portMapping nat.PortMapping) (nat.PortMapping, bool) {
+	for _, publishedPortMapping := range portMappings {
+		publishedHostIP, hostIP := publishedPortMapping.Binding.HostIP, portMapping.Binding.HostIP
+		if isAnyHostIP(publishedHostIP) || isAnyHostIP(hostIP) || publishedHostIP == hostIP {
+			return publishedPortMapping, true
+		}
+	}
+	return nat.PortMapping{}, false
+}
+
+// checkVolume 检查 source:target[:mode] 形式的挂载，source 是主机上的路径或命名卷，相对路径以 . 开头
+func checkVolume(volume string) error {
+	volumeSlice := strings.Split(volume, ":")
+	if len(volumeSlice) != 2 && len(volumeSlice) != 3 {
+		return fmt.Errorf("%q should be in the form of source:target[:mode]", volume)
+	}
+	source, target := volumeSlice[0], volumeSlice[1]
+	if source == "" {
+		return fmt.Errorf("empty source in %q", volume)
+	}
+	if !isHostPath(source) && !volumeNameRegexp.MatchString(source) {
+		return fmt.Errorf("source %q is neither a path nor a volume name", source)
+	}
+	if !path.IsAbs(target) {
+		return fmt.Errorf("target %q is not an absolute path", target)
+	}
+	if len(volumeSlice) == 3 {
+		for _, mode := range strings.Split(volumeSlice[2], ",") {
+			if !volumeModes[mode] {
+				return fmt.Errorf("unknown mode %q in %q", mode, volume)
+			}
+		}
+	}
+	return nil
+}
+
+// checkMount 检查挂载，字符串形式的挂载先按 checkVolume 检查；每种挂载类型只能指定对应的选项
+func checkMount(mountEntity MountEntity) error {
+	if mountEntity.volume != "" {
+		if err := checkVolume(mountEntity.volume); err != nil {
+			return err
+		}
+	}
+	if !path.IsAbs(mountEntity.Target) {
+		return fmt.Errorf("target %q is not an absolute path", mountEntity.Target)
+	}
+	switch mountEntity.Consistency {
+	case "", "default", "consistent", "cached", "delegated":
+	default:
+		return fmt.Errorf("unknown consistency %q", mountEntity.Consistency)
+	}
+	switch mountEntity.Type {
+	case string(mount.TypeBind):
+		if mountEntity.Source == "" {
+			return fmt.Errorf("empty source of bind mount")
+		}
+		if mountEntity.Bind != nil && mountEntity.Bind.Propagation != "" && !propagations[mountEntity.Bind.Propagation] {
+			return fmt.Errorf("unknown propagation %q", mountEntity.Bind.Propagation)
+		}
+	case string(mount.TypeVolume):
+		if mountEntity.Source != "" && !volumeNameRegexp.MatchString(mountEntity.Source) {
+			return fmt.Errorf("source %q is not a volume name", mountEntity.Source)
+		}
+	case string(mount.TypeTmpfs):
+		if mountEntity.Source != "" {
+			return fmt.Errorf("tmpfs mount cannot have a source")
+		}
+		if _, err := getMount(mountEntity, ""); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown mount type %q, expect bind, volume or tmpfs", mountEntity.Type)
+	}
+	for mountType, options := range map[string]bool{
+		string(mount.TypeBind):   mountEntity.Bind != nil,
+		string(mount.TypeVolume): mountEntity.Volume != nil,
+		string(mount.TypeTmpfs):  mountEntity.Tmpfs != nil,
+	} {
+		if options && mountType != mountEntity.Type {
+			return fmt.Errorf("%v options cannot be used with %v mount", mountType, mountEntity.Type)
+		}
+	}
+	return nil
+}
+
+// predefinedNetworks 是 Docker 预定义的网络，不能声明，也不支持静态地址
+var predefinedNetworks = map[string]bool{
+	"bridge": true, "host": true, "none": true,
+}
+
+// isExclusiveNetwork 判断网络是否独占容器的网络栈
+func isExclusiveNetwork(name string) bool {
+	return name == "host" || name == "none"
+}
+
+// checkNetwork 检查 network_list 中的网络，name:gateway 形式的网关可以为空
+func checkNetwork(networkEntity NetworkEntity) error {
+	if networkEntity.Name == "" {
+		return fmt.Errorf("empty network name")
+	}
+	gateway := networkEntity.gateway
+	if strings.Contains(gateway, "*") {
+		return fmt.Errorf("gateway %q contains placeholder *, fill it in with the gateway of network %v", gateway, networkEntity.Name)
+	}
+	if gateway != "" && net.ParseIP(gateway) == nil {
+		return fmt.Errorf("gateway %q is not an IP address", gateway)
+	}
+	if networkEntity.IPv4Address != "" {
+		if ip := net.ParseIP(networkEntity.IPv4Address); ip == nil || ip.To4() == nil {
+			return fmt.Errorf("ipv4_address %q is not an IPv4 address", networkEntity.IPv4Address)
+		}
+	}
+	if networkEntity.IPv6Address != "" {
+		if ip := net.ParseIP(networkEntity.IPv6Address); ip == nil || ip.To4() != nil {
+			return fmt.Errorf("ipv6_address %q is not an IPv6 address", networkEntity.IPv6Address)
+		}
+	}
+	if predefinedNetworks[networkEntity.Name] && (networkEntity.IPv4Address != "" || networkEntity.IPv6Address != "") {
+		return fmt.Errorf("static addresses are only supported on user-defined networks, not %v", networkEntity.Name)
+	}
+	for _, alias := range networkEntity.Aliases {
+		if alias == "" {
+			return fmt.Errorf("empty alias")
+		}
+	}
+	for _, link := range networkEntity.Links {
+		linkSlice := strings.Split(link, ":")
+		if len(linkSlice) > 2 || linkSlice[0] == "" || (len(linkSlice) == 2 && linkSlice[1] == "") {
+			return fmt.Errorf("link %q should be in the form of container[:alias]", link)
+		}
+	}
+	return nil
+}
+
+// checkNetworkDeclaration 检查声明的网络，网关需要与子网一同指定并且在子网内
+func checkNetworkDeclaration(networkDeclarationEntity NetworkDeclarationEntity) error {
+	if networkDeclarationEntity.Name == "" {
+		return fmt.Errorf("empty network name")
+	}
+	if predefinedNetworks[networkDeclarationEntity.Name] {
+		return fmt.Errorf("predefined network %v cannot be declared", networkDeclarationEntity.Name)
+	}
+	if networkDeclarationEntity.Subnet == "" {
+		if networkDeclarationEntity.Gateway != "" {
+			return fmt.Errorf("gateway %v requires a subnet", networkDeclarationEntity.Gateway)
+		}
+		return nil
+	}
+	_, subnet, err := net.ParseCIDR(networkDeclarationEntity.Subnet)
+	if err != nil {
+		return fmt.Errorf("subnet %q is not in CIDR notation", networkDeclarationEntity.Subnet)
+	}
+	if networkDeclarationEntity.Gateway != "" {
+		gateway := net.ParseIP(networkDeclarationEntity.Gateway)
+		if gateway == nil {
+			return fmt.Errorf("gateway %q is not an IP address", networkDeclarationEntity.Gateway)
+		}
+		if !subnet.Contains(gateway) {
+			return fmt.Errorf("gateway %v is not in subnet %v", networkDeclarationEntity.Gateway, networkDeclarationEntity.Subnet)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,179 @@
+/*
+Copyright (c) 2022 Zhang Zhanpeng <zhangregister@outlook.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package object
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
+)
+
+const testInvalidStackYaml = `name: testnet
+kind: stack
+objects:
+  - name: bootnode
+    predicate:
+      repository: ethereum/client-go
+    requirement:
+      detatch: true
+      publish_list:
+        - 30303:30303/quic
+        - {port: 8545, policy: retry}
+      network_list:
+        - kether-net:172.*.0.1
+  - name: validator
+    kind: deplyo
+    requirement:
+      volume_list:
+        - data/:/data
+    depends_on: [bootnode, explorer]
+---
+name: rpc
+predicate:
+  repository: ethereum/client-go
+healthcheck:
+  retries: many
+`
+
+func TestValidateStack(t *testing.T) {
+	fieldErrors, err := ValidateStack(writeTestYaml(t, testStackYaml))
+	assert.Nil(t, err)
+	assert.Empty(t, fieldErrors)
+
+	fieldErrors, err = ValidateStack(writeTestYaml(t, testInvalidStackYaml))
+	assert.Nil(t, err)
+	problems := make([]string, 0, len(fieldErrors))
+	for _, fieldError := range fieldErrors {
+		problems = append(problems, fmt.Sprintf("%v:%v %v", fieldError.Line, fieldError.Column, fieldError.Field))
+	}
+	assert.Equal(t, []string{
+		"8:7 documents[0].objects[0].requirement.detatch",
+		"10:11 documents[0].objects[0].requirement.publish_list[0]",
+		"11:32 documents[0].objects[0].requirement.publish_list[1].policy",
+		"13:11 documents[0].objects[0].requirement.network_list[0]",
+		"14:5 documents[0].objects[1].predicate.repository",
+		"15:11 documents[0].objects[1].kind",
+		"18:11 documents[0].objects[1].requirement.volume_list[0]",
+		"19:28 documents[0].objects[1].depends_on[1]",
+		"25:3 documents[1].healthcheck.retries",
+	}, problems)
+
+	fieldErrors, err = ValidateStack(writeTestYaml(t, "name: a\n  kind: deploy\n"))
+	assert.Nil(t, err)
+	assert.Len(t, fieldErrors, 1)
+	assert.Equal(t, 2, fieldErrors[0].Line)
+
+	_, err = ParseStack(writeTestYaml(t, "name: a\npredicate:\n  repository: busybox\nunknown: 1\n"))
+	assert.Nil(t, err)
+}
+
+func TestCheckSyntax(t *testing.T) {
+	for _, portSpec := range []string{"8080:80", ":80", "80", "53:53/udp", "9000:9000/sctp", "127.0.0.1:8545:8545", "9000-9010:9000-9010"} {
+		_, err := parsePublish(portSpec)
+		assert.Nil(t, err, portSpec)
+	}
+	for _, portSpec := range []string{"8080:", "0:80", "8080:0", "8080:80/http", "a:80", "1:2:3", "9000-9010:9000-9005", "localhost:8545:8545"} {
+		_, err := parsePublish(portSpec)
+		assert.NotNil(t, err, portSpec)
+	}
+	for _, policy := range []string{"", PortPolicyStrict, PortPolicyRemap, PortPolicyRandom} {
+		assert.Nil(t, checkPortPolicy(policy), policy)
+	}
+	assert.NotNil(t, checkPortPolicy("retry"))
+	for _, volume := range []string{"/data:/data", "chaindata:/data:ro", "/src:/src:ro,z"} {
+		assert.Nil(t, checkVolume(volume), volume)
+	}
+	for _, volume := range []string{"/data", "data/:/data", "/data:data", "/data:/data:rx", ":/data"} {
+		assert.NotNil(t, checkVolume(volume), volume)
+	}
+	for _, network := range []string{"kether-net", "kether-net:172.18.0.1", "'kether-net:'", "{name: kether-net, ipv4_address: 172.18.0.10, aliases: [geth]}",
+		"{name: kether-net, ipv6_address: 'fd00::10', links: ['db:database']}", "bridge"} {
+		assert.Nil(t, checkNetwork(getTestNetworkEntity(t, network)), network)
+	}
+	for _, network := range []string{":172.18.0.1", "kether-net:172.*.0.1", "kether-net:gateway", "{name: kether-net, ipv4_address: 'fd00::10'}",
+		"{name: kether-net, ipv6_address: 172.18.0.10}", "{name: bridge, ipv4_address: 172.17.0.10}", "{name: kether-net, aliases: ['']}",
+		"{name: kether-net, links: ['db:']}"} {
+		assert.NotNil(t, checkNetwork(getTestNetworkEntity(t, network)), network)
+	}
+	for _, networkDeclarationEntity := range []NetworkDeclarationEntity{{Name: "kether-net"}, {Name: "kether-net", Subnet: "172.28.0.0/16", Gateway: "172.28.0.1"}} {
+		assert.Nil(t, checkNetworkDeclaration(networkDeclarationEntity), networkDeclarationEntity)
+	}
+	for _, networkDeclarationEntity := range []NetworkDeclarationEntity{{}, {Name: "host"}, {Name: "kether-net", Gateway: "172.28.0.1"},
+		{Name: "kether-net", Subnet: "172.28.0.0"}, {Name: "kether-net", Subnet: "172.28.0.0/16", Gateway: "172.29.0.1"}} {
+		assert.NotNil(t, checkNetworkDeclaration(networkDeclarationEntity), networkDeclarationEntity)
+	}
+}
+
+func getTestNetworkEntity(t *testing.T, network string) NetworkEntity {
+	var networkEntity NetworkEntity
+	assert.Nil(t, yaml.Unmarshal([]byte(network), &networkEntity), network)
+	return networkEntity
+}
+
+func getYamlTags(entityType reflect.Type) []string {
+	tags := make([]string, 0, entityType.NumField())
+	for i := 0; i < entityType.NumField(); i++ {
+		tag := strings.Split(entityType.Field(i).Tag.Get("yaml"), ",")[0]
+		if tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+// TestSchema 检查 JSON Schema 与 YAML 实体的字段保持一致
+func TestSchema(t *testing.T) {
+	schemaBytes, err := ioutil.ReadFile("../schema/kether.schema.json")
+	assert.Nil(t, err)
+	schema := struct {
+		Definitions map[string]struct {
+			Properties map[string]interface{} `json:"properties"`
+		} `json:"definitions"`
+	}{}
+	assert.Nil(t, json.Unmarshal(schemaBytes, &schema))
+
+	for definition, entityType := range map[string]reflect.Type{
+		"object":              reflect.TypeOf(KetherObjectEntity{}),
+		"resourceDescription": reflect.TypeOf(ResourceDescriptionEntity{}),
+		"runDescription":      reflect.TypeOf(RunDescriptionEntity{}),
+		"healthcheck":         reflect.TypeOf(HealthcheckEntity{}),
+		"mount":               reflect.TypeOf(MountEntity{}),
+		"publish":             reflect.TypeOf(PublishEntity{}),
+		"network":             reflect.TypeOf(NetworkEntity{}),
+		"networkDeclaration":  reflect.TypeOf(NetworkDeclarationEntity{}),
+	} {
+		properties := make([]string, 0, len(schema.Definitions[definition].Properties))
+		for property := range schema.Definitions[definition].Properties {
+			properties = append(properties, property)
+		}
+		sort.Strings(properties)
+		assert.Equal(t, getYamlTags(entityType), properties, definition)
+	}
+}
@@ -0,0 +1,349 @@
+/*
+Copyright (c) 2022 Zhang Zhanpeng <zhangregister@outlook.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package object
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	kethercontainer "github.com/MonteCarloClub/kether/container"
+	"github.com/MonteCarloClub/kether/log"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/volume"
+	"github.com/docker/go-units"
+)
+
+// MountEntity 是 volume_list 中的一个挂载，可写成 source:target[:mode] 形式的字符串或映射，挂载类型为 bind、volume 或 tmpfs
+type MountEntity struct {
+	Type        string               `yaml:"type"`
+	Source      string               `yaml:"source"`
+	Target      string               `yaml:"target"`
+	ReadOnly    bool                 `yaml:"read_only"`
+	Consistency string               `yaml:"consistency"`
+	Bind        *BindOptionsEntity   `yaml:"bind"`
+	Volume      *VolumeOptionsEntity `yaml:"volume"`
+	Tmpfs       *TmpfsOptionsEntity  `yaml:"tmpfs"`
+
+	// volume 是字符串形式的挂载，由 checkMount 检查
+	volume string
+}
+
+type BindOptionsEntity struct {
+	Propagation string `yaml:"propagation"`
+	// CreateHostPath 为 true 时创建不存在的源路径，字符串形式的绑定挂载与 `docker run -v` 一致，总是创建
+	CreateHostPath bool `yaml:"create_host_path"`
+}
+
+type VolumeOptionsEntity struct {
+	NoCopy     bool              `yaml:"nocopy"`
+	Driver     string            `yaml:"driver"`
+	DriverOpts map[string]string `yaml:"driver_opts"`
+	Labels     map[string]string `yaml:"labels"`
+}
+
+type TmpfsOptionsEntity struct {
+	Size string `yaml:"size"`
+	// Mode 是 tmpfs 的权限，如 0755
+	Mode os.FileMode `yaml:"mode"`
+}
+
+func (mountEntity *MountEntity) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var volume string
+	if err := unmarshal(&volume); err == nil {
+		*mountEntity = parseVolume(volume)
+		return nil
+	}
+
+	type plainMountEntity MountEntity
+	return unmarshal((*plainMountEntity)(mountEntity))
+}
+
+// isHostPath 判断字符串形式的挂载的源是否为主机路径，否则是命名卷
+func isHostPath(source string) bool {
+	return path.IsAbs(source) || strings.HasPrefix(source, ".")
+}
+
+// parseVolume 把 source:target[:mode] 形式的挂载转换为结构化的挂载，格式错误由 checkMount 报告
+func parseVolume(volume string) MountEntity {
+	mountEntity := MountEntity{
+		volume: volume,
+	}
+	volumeSlice := strings.Split(volume, ":")
+	if len(volumeSlice) < 2 {
+		return mountEntity
+	}
+	mountEntity.Source, mountEntity.Target = volumeSlice[0], volumeSlice[1]
+	if isHostPath(mountEntity.Source) {
+		mountEntity.Type = string(mount.TypeBind)
+		mountEntity.Bind = &BindOptionsEntity{
+			CreateHostPath: true,
+		}
+	} else {
+		mountEntity.Type = string(mount.TypeVolume)
+	}
+	if len(volumeSlice) < 3 {
+		return mountEntity
+	}
+	for _, mode := range strings.Split(volumeSlice[2], ",") {
+		switch {
+		case mode == "ro":
+			mountEntity.ReadOnly = true
+		case mode == "nocopy":
+			mountEntity.Volume = &VolumeOptionsEntity{
+				NoCopy: true,
+			}
+		case mode == "consistent" || mode == "cached" || mode == "delegated":
+			mountEntity.Consistency = mode
+		case propagations[mode] && mountEntity.Bind != nil:
+			mountEntity.Bind.Propagation = mode
+		}
+	}
+	return mountEntity
+}
+
+// usesBinds 判断挂载是否以 Binds 传递给 Docker：字符串形式的绑定挂载与 `docker run -v` 一致，源路径不存在时由 Docker 创建，
+// 以 Mounts 传递时 Docker 会拒绝不存在的源路径；Mounts 不支持重新标记 SELinux 标签，带有 z 或 Z 选项的字符串形式的挂载也以 Binds 传递
+func (mountEntity MountEntity) usesBinds() bool {
+	if mountEntity.volume == "" {
+		return false
+	}
+	return mountEntity.Type == string(mount.TypeBind) || mountEntity.hasSELinuxLabel()
+}
+
+// hasSELinuxLabel 判断字符串形式的挂载是否带有 z 或 Z 选项
+func (mountEntity MountEntity) hasSELinuxLabel() bool {
+	volumeSlice := strings.Split(mountEntity.volume, ":")
+	if len(volumeSlice) < 3 {
+		return false
+	}
+	for _, mode := range strings.Split(volumeSlice[2], ",") {
+		if mode == "z" || mode == "Z" {
+			return true
+		}
+	}
+	return false
+}
+
+// propagations 是绑定挂载支持的传播方式
+var propagations = map[string]bool{
+	"shared": true, "rshared": true, "slave": true, "rslave": true, "private": true, "rprivate": true,
+}
+
+// resolveSource 把绑定挂载的相对源路径解析为相对 YAML 文件所在目录的绝对路径；远程 Docker 引擎的源路径在远程主机上，
+// 不能相对本地的 YAML 文件解析，必须是绝对路径
+func (ketherObject *KetherObject) resolveSource(mountEntity MountEntity) (string, error) {
+	if mountEntity.Type != string(mount.TypeBind) {
+		return mountEntity.Source, nil
+	}
+	if kethercontainer.IsRemoteDockerEngine() {
+		if !path.IsAbs(mountEntity.Source) {
+			return "", fmt.Errorf("bind source %v is relative, use an absolute path on the remote docker engine", mountEntity.Source)
+		}
+		return mountEntity.Source, nil
+	}
+	return filepath.Abs(ketherObject.resolvePath(mountEntity.Source))
+}
+
+// getMount 把结构化的挂载转换为 Docker 的挂载，source 是解析后的源
+func getMount(mountEntity MountEntity, source string) (mount.Mount, error) {
+	dockerMount := mount.Mount{
+		Type:        mount.Type(mountEntity.Type),
+		Source:      source,
+		Target:      mountEntity.Target,
+		ReadOnly:    mountEntity.ReadOnly,
+		Consistency: mount.Consistency(mountEntity.Consistency),
+	}
+	if mountEntity.Bind != nil && mountEntity.Bind.Propagation != "" {
+		dockerMount.BindOptions = &mount.BindOptions{
+			Propagation: mount.Propagation(mountEntity.Bind.Propagation),
+		}
+	}
+	if mountEntity.Volume != nil {
+		dockerMount.VolumeOptions = &mount.VolumeOptions{
+			NoCopy: mountEntity.Volume.NoCopy,
+			Labels: mountEntity.Volume.Labels,
+		}
+		if mountEntity.Volume.Driver != "" || len(mountEntity.Volume.DriverOpts) > 0 {
+			dockerMount.VolumeOptions.DriverConfig = &mount.Driver{
+				Name:    mountEntity.Volume.Driver,
+				Options: mountEntity.Volume.DriverOpts,
+			}
+		}
+	}
+	if mountEntity.Tmpfs != nil {
+		dockerMount.TmpfsOptions = &mount.TmpfsOptions{
+			Mode: mountEntity.Tmpfs.Mode,
+		}
+		if mountEntity.Tmpfs.Size != "" {
+			size, err := units.RAMInBytes(mountEntity.Tmpfs.Size)
+			if err != nil || size <= 0 {
+				return dockerMount, fmt.Errorf("tmpfs size %q is not a positive size", mountEntity.Tmpfs.Size)
+			}
+			dockerMount.TmpfsOptions.SizeBytes = size
+		}
+	}
+	return dockerMount, nil
+}
+
+// getMounts 返回容器的挂载，字符串形式的绑定挂载和带有 SELinux 标签选项的挂载以 Binds 返回
+func (ketherObject *KetherObject) getMounts() ([]mount.Mount, []string, error) {
+	mounts := make([]mount.Mount, 0, len(ketherObject.Requirement.VolumeList))
+	binds := make([]string, 0)
+	for _, mountEntity := range ketherObject.Requirement.VolumeList {
+		source, err := ketherObject.resolveSource(mountEntity)
+		if err != nil {
+			return nil, nil, err
+		}
+		if mountEntity.usesBinds() {
+			volumeSlice := strings.Split(mountEntity.volume, ":")
+			binds = append(binds, strings.Join(append([]string{source}, volumeSlice[1:]...), ":"))
+			continue
+		}
+		dockerMount, err := getMount(mountEntity, source)
+		if err != nil {
+			return nil, nil, err
+		}
+		mounts = append(mounts, dockerMount)
+	}
+	return mounts, binds, nil
+}
+
+// formatMount 以 type [source:]target[:ro] option=... 的形式描述挂载，用于比较和输出；没有指定的选项不输出
+func formatMount(dockerMount mount.Mount) string {
+	description := fmt.Sprintf("%v %v", dockerMount.Type, dockerMount.Target)
+	if dockerMount.Source != "" {
+		description = fmt.Sprintf("%v %v:%v", dockerMount.Type, dockerMount.Source, dockerMount.Target)
+	}
+	if dockerMount.ReadOnly {
+		description += ":ro"
+	}
+	options := make([]string, 0)
+	if dockerMount.Consistency != "" && dockerMount.Consistency != mount.ConsistencyDefault {
+		options = append(options, "consistency="+string(dockerMount.Consistency))
+	}
+	if dockerMount.BindOptions != nil && dockerMount.BindOptions.Propagation != "" {
+		options = append(options, "propagation="+string(dockerMount.BindOptions.Propagation))
+	}
+	if dockerMount.VolumeOptions != nil {
+		if dockerMount.VolumeOptions.NoCopy {
+			options = append(options, "nocopy")
+		}
+		if dockerMount.VolumeOptions.DriverConfig != nil && dockerMount.VolumeOptions.DriverConfig.Name != "" {
+			options = append(options, "driver="+dockerMount.VolumeOptions.DriverConfig.Name)
+		}
+	}
+	if dockerMount.TmpfsOptions != nil {
+		if dockerMount.TmpfsOptions.SizeBytes > 0 {
+			options = append(options, fmt.Sprintf("size=%v", dockerMount.TmpfsOptions.SizeBytes))
+		}
+		if dockerMount.TmpfsOptions.Mode != 0 {
+			options = append(options, fmt.Sprintf("mode=%o", dockerMount.TmpfsOptions.Mode))
+		}
+	}
+	sort.Strings(options)
+	return strings.TrimSpace(description + " " + strings.Join(options, " "))
+}
+
+// formatBind 以与 formatMount 相同的形式描述 Binds 中的挂载
+func formatBind(bind string) string {
+	mountEntity := parseVolume(bind)
+	dockerMount, _ := getMount(mountEntity, mountEntity.Source)
+	return formatMount(dockerMount)
+}
+
+// ensureMounts 确认挂载的源都存在：不存在的命名卷按 volume 选项创建；本地 Docker 引擎的绑定挂载源路径不存在时，
+// 在 create_host_path 为 true 时创建，否则返回错误。远程 Docker 引擎的源路径在远程主机上，不做检查；
+// 以 Binds 传递的挂载的源路径由 Docker 创建
+func (ketherObject *KetherObject) ensureMounts(ctx context.Context) error {
+	for _, mountEntity := range ketherObject.Requirement.VolumeList {
+		switch mountEntity.Type {
+		case string(mount.TypeVolume):
+			// 匿名卷由 Docker 在创建容器时创建
+			if mountEntity.Source == "" {
+				continue
+			}
+			err := ensureVolume(ctx, mountEntity)
+			if err != nil {
+				return err
+			}
+		case string(mount.TypeBind):
+			if kethercontainer.IsRemoteDockerEngine() || mountEntity.usesBinds() {
+				continue
+			}
+			source, err := ketherObject.resolveSource(mountEntity)
+			if err != nil {
+				return err
+			}
+			err = ensureHostPath(source, mountEntity.Bind != nil && mountEntity.Bind.CreateHostPath)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func ensureVolume(ctx context.Context, mountEntity MountEntity) error {
+	options := volume.VolumeCreateBody{
+		Name: mountEntity.Source,
+	}
+	if mountEntity.Volume != nil {
+		options.Driver = mountEntity.Volume.Driver
+		options.DriverOpts = mountEntity.Volume.DriverOpts
+		options.Labels = mountEntity.Volume.Labels
+	}
+	dockerVolume, ok, err := kethercontainer.InspectDockerVolume(ctx, mountEntity.Source)
+	if err != nil {
+		return err
+	}
+	if ok {
+		if options.Driver != "" && options.Driver != dockerVolume.Driver {
+			log.Warn("existing volume differs from its options", "volumeName", mountEntity.Source, "driver", options.Driver, "actualDriver", dockerVolume.Driver)
+		}
+		return nil
+	}
+	return kethercontainer.CreateDockerVolume(ctx, options)
+}
+
+func ensureHostPath(source string, createHostPath bool) error {
+	_, err := os.Stat(source)
+	if err == nil {
+		return nil
+	}
+	if !os.IsNotExist(err) {
+		return err
+	}
+	if !createHostPath {
+		return fmt.Errorf("bind source %v does not exist, create it or set bind.create_host_path", source)
+	}
+	err = os.MkdirAll(source, 0755)
+	if err != nil {
+		return err
+	}
+	log.Info("bind source created", "source", source)
+	return nil
+}
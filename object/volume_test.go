@@ -0,0 +1,132 @@
+/*
+Copyright (c) 2022 Zhang Zhanpeng <zhangregister@outlook.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package object
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/docker/api/types/mount"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
+)
+
+const testVolumeListYaml = `
+- ./data:/data:ro
+- chaindata:/chaindata:nocopy
+- /src:/src:z
+- type: volume
+  source: keystore
+  target: /keystore
+  volume:
+    driver: local
+    driver_opts:
+      type: none
+- type: tmpfs
+  target: /tmp
+  tmpfs:
+    size: 64m
+    mode: 01777
+- type: bind
+  source: logs
+  target: /logs
+  bind:
+    propagation: rslave
+    create_host_path: true
+`
+
+func getTestMountEntities(t *testing.T) []MountEntity {
+	var mountEntities []MountEntity
+	assert.Nil(t, yaml.Unmarshal([]byte(testVolumeListYaml), &mountEntities))
+	return mountEntities
+}
+
+func TestGetMounts(t *testing.T) {
+	ketherObject, _ := getTestKetherObject(true, true)
+	ketherObject.Dir = t.TempDir()
+	ketherObject.Requirement.VolumeList = getTestMountEntities(t)
+	for _, mountEntity := range ketherObject.Requirement.VolumeList {
+		assert.Nil(t, checkMount(mountEntity), mountEntity)
+	}
+
+	mounts, binds, err := ketherObject.getMounts()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{filepath.Join(ketherObject.Dir, "data") + ":/data:ro", "/src:/src:z"}, binds)
+	assert.Equal(t, []mount.Mount{
+		{Type: mount.TypeVolume, Source: "chaindata", Target: "/chaindata", VolumeOptions: &mount.VolumeOptions{NoCopy: true}},
+		{Type: mount.TypeVolume, Source: "keystore", Target: "/keystore", VolumeOptions: &mount.VolumeOptions{
+			DriverConfig: &mount.Driver{Name: "local", Options: map[string]string{"type": "none"}},
+		}},
+		{Type: mount.TypeTmpfs, Target: "/tmp", TmpfsOptions: &mount.TmpfsOptions{SizeBytes: 64 * 1024 * 1024, Mode: 01777}},
+		{Type: mount.TypeBind, Source: filepath.Join(ketherObject.Dir, "logs"), Target: "/logs", BindOptions: &mount.BindOptions{Propagation: mount.PropagationRSlave}},
+	}, mounts)
+	assert.Equal(t, "tmpfs /tmp mode=1777 size=67108864", formatMount(mounts[2]))
+	assert.Equal(t, "bind "+filepath.Join(ketherObject.Dir, "data")+":/data:ro", formatBind(binds[0]))
+	assert.Equal(t, "bind /src:/src", formatBind(binds[1]))
+
+	for _, volume := range []string{"{type: tmpfs, source: cache, target: /cache}", "{type: volume, source: /data, target: /data}", "{type: bind, target: /data}",
+		"{type: nfs, source: data, target: /data}", "{type: volume, source: data, target: data}", "{type: volume, source: data, target: /data, bind: {propagation: shared}}",
+		"{type: bind, source: ./data, target: /data, bind: {propagation: both}}", "{type: tmpfs, target: /tmp, tmpfs: {size: huge}}", "./data:/data:rx"} {
+		var mountEntity MountEntity
+		assert.Nil(t, yaml.Unmarshal([]byte(volume), &mountEntity), volume)
+		assert.NotNil(t, checkMount(mountEntity), volume)
+	}
+}
+
+func TestEnsureMounts(t *testing.T) {
+	fakeEngine, ctx := newTestEnv(t)
+
+	ketherObject, ketherObjectState := getTestKetherObject(true, true)
+	ketherObject.Dir = t.TempDir()
+	ketherObject.Requirement.VolumeList = []MountEntity{
+		{Type: "bind", Source: "config", Target: "/config", ReadOnly: true},
+		{Type: "volume", Source: "chaindata", Target: "/chaindata"},
+	}
+	_, err := Apply(ctx, ketherObject, ketherObjectState)
+	assert.NotNil(t, err)
+	assert.Equal(t, FAIL_TO_DEPLOY, ketherObjectState.State)
+	assert.Empty(t, filterCalls(fakeEngine.Calls(), "CreateContainer"))
+
+	ketherObject.Requirement.VolumeList[0].Bind = &BindOptionsEntity{CreateHostPath: true}
+	ketherObject.Requirement.VolumeList = append(ketherObject.Requirement.VolumeList, parseVolume("./logs:/logs"))
+	_, err = Apply(ctx, ketherObject, ketherObjectState)
+	assert.Nil(t, err)
+	fileInfo, err := os.Stat(filepath.Join(ketherObject.Dir, "config"))
+	assert.Nil(t, err)
+	assert.True(t, fileInfo.IsDir())
+	// 字符串形式的绑定挂载以 Binds 传递，源路径由 Docker 创建
+	_, err = os.Stat(filepath.Join(ketherObject.Dir, "logs"))
+	assert.True(t, os.IsNotExist(err))
+	_, _, hostConfig, ok := fakeEngine.GetContainer(ketherObject.GetContainerName())
+	assert.True(t, ok)
+	assert.Equal(t, []string{filepath.Join(ketherObject.Dir, "logs") + ":/logs"}, hostConfig.Binds)
+	dockerVolume, ok := fakeEngine.Volumes["chaindata"]
+	assert.True(t, ok)
+	assert.Equal(t, "local", dockerVolume.Driver)
+	assert.Equal(t, []string{"CreateVolume"}, filterCalls(fakeEngine.Calls(), "CreateVolume"))
+
+	// 挂载与 YAML 一致时不需要重建
+	plan, err := GetPlan(ctx, ketherObject)
+	assert.Nil(t, err)
+	assert.Equal(t, PlanActionNoop, plan.Action)
+}
@@ -0,0 +1,94 @@
+/*
+Copyright (c) 2022 Zhang Zhanpeng <zhangregister@outlook.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package registry
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/MonteCarloClub/kether/log"
+)
+
+const (
+	deploymentKeyPrefix = "deployment_"
+)
+
+// Deployment 是 Kether 对象最近一次成功部署的记录，ConfigHash 是期望配置的哈希，与容器标签中的哈希一致时配置没有变化
+type Deployment struct {
+	ConfigHash  string        `json:"config_hash"`
+	ContainerID string        `json:"container_id"`
+	Image       string        `json:"image"`
+	ImageDigest string        `json:"image_digest,omitempty"`
+	ImageID     string        `json:"image_id,omitempty"`
+	Ports       []PortBinding `json:"ports,omitempty"`
+	Timestamp   time.Time     `json:"timestamp"`
+}
+
+// PortBinding 是部署后容器端口实际绑定的主机地址和端口，按端口策略重新分配或由 Docker 选择的端口以此为准，ContainerPort 形如 80/tcp
+type PortBinding struct {
+	ContainerPort string `json:"container_port"`
+	HostIP        string `json:"host_ip,omitempty"`
+	HostPort      string `json:"host_port"`
+}
+
+func getDeploymentKey(name string) string {
+	return fmt.Sprintf("%v%v", deploymentKeyPrefix, name)
+}
+
+func SetDeploymentOfName(ctx context.Context, name string, deployment Deployment) error {
+	err := DefaultStore.SetDeployment(ctx, name, deployment)
+	if err != nil {
+		log.Error("fail to set deployment of kether object", "key", getDeploymentKey(name), "configHash", deployment.ConfigHash, "err", err)
+		return err
+	}
+	return nil
+}
+
+// GetDeploymentOfName 读取 Kether 对象的部署记录，对象没有部署过时返回 ok == false
+func GetDeploymentOfName(ctx context.Context, name string) (Deployment, bool, error) {
+	deployment, ok, err := DefaultStore.GetDeployment(ctx, name)
+	if err != nil {
+		log.Error("fail to get deployment of kether object", "key", getDeploymentKey(name), "err", err)
+		return Deployment{}, false, err
+	}
+	return deployment, ok, nil
+}
+
+// GetPublishedPortOfName 返回 Kether 对象最近一次部署时容器端口实际绑定的主机端口，containerPort 形如 8545/tcp；
+// 容器端口绑定了多个主机地址时优先返回监听所有地址的绑定
+func GetPublishedPortOfName(ctx context.Context, name string, containerPort string) (PortBinding, bool, error) {
+	deployment, ok, err := GetDeploymentOfName(ctx, name)
+	if err != nil || !ok {
+		return PortBinding{}, false, err
+	}
+	portBinding, found := PortBinding{}, false
+	for _, binding := range deployment.Ports {
+		if binding.ContainerPort != containerPort {
+			continue
+		}
+		if !found || binding.HostIP == "" || binding.HostIP == "0.0.0.0" {
+			portBinding, found = binding, true
+		}
+	}
+	return portBinding, found, nil
+}
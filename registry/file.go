@@ -0,0 +1,388 @@
+/*
+Copyright (c) 2022 Zhang Zhanpeng <zhangregister@outlook.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/MonteCarloClub/kether/log"
+)
+
+const (
+	fileStoreWatchInterval = 500 * time.Millisecond
+)
+
+// fileStoreData 是文件存储后端的 JSON 文件内容
+type fileStoreData struct {
+	States      map[string]string          `json:"states"`
+	History     map[string][]HistoryEntry  `json:"history,omitempty"`
+	Deployments map[string]Deployment      `json:"deployments,omitempty"`
+	Revisions   map[string][]Revision      `json:"revisions,omitempty"`
+	Ports       map[string]PortReservation `json:"ports,omitempty"`
+	Locks       map[string]lockRecord      `json:"locks,omitempty"`
+	Tokens      map[string]int64           `json:"tokens,omitempty"`
+}
+
+func (data *fileStoreData) lockTable() *lockTable {
+	return &lockTable{
+		states: data.States,
+		locks:  data.Locks,
+		tokens: data.Tokens,
+	}
+}
+
+// fileStore 把状态保存在本地 JSON 文件中，适用于单主机部署，跨进程的读写由文件锁保护
+type fileStore struct {
+	mu   sync.Mutex
+	path string
+}
+
+func getDefaultFileStorePath() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".kether", "registry.json"), nil
+}
+
+func NewFileStore(path string) (Store, error) {
+	if path == "" {
+		var err error
+		path, err = getDefaultFileStorePath()
+		if err != nil {
+			return nil, err
+		}
+	}
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return nil, err
+	}
+	return &fileStore{
+		path: path,
+	}, nil
+}
+
+// withLock 在进程内互斥锁和文件锁的保护下执行 fn
+func (store *fileStore) withLock(fn func() error) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	lockFile, err := os.OpenFile(store.path+".lock", os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	defer lockFile.Close()
+	err = lockFileExclusive(lockFile)
+	if err != nil {
+		return err
+	}
+	defer unlockFile(lockFile)
+	return fn()
+}
+
+func (store *fileStore) read() (*fileStoreData, error) {
+	data := &fileStoreData{}
+	dataBytes, err := ioutil.ReadFile(store.path)
+	if os.IsNotExist(err) {
+		dataBytes, err = nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(dataBytes) > 0 {
+		err = json.Unmarshal(dataBytes, data)
+		if err != nil {
+			return nil, fmt.Errorf("corrupted registry file %v: %v", store.path, err)
+		}
+	}
+	if data.States == nil {
+		data.States = make(map[string]string)
+	}
+	if data.History == nil {
+		data.History = make(map[string][]HistoryEntry)
+	}
+	if data.Deployments == nil {
+		data.Deployments = make(map[string]Deployment)
+	}
+	if data.Revisions == nil {
+		data.Revisions = make(map[string][]Revision)
+	}
+	if data.Ports == nil {
+		data.Ports = make(map[string]PortReservation)
+	}
+	if data.Locks == nil {
+		data.Locks = make(map[string]lockRecord)
+	}
+	if data.Tokens == nil {
+		data.Tokens = make(map[string]int64)
+	}
+	return data, nil
+}
+
+// write 先写临时文件再重命名，避免读到写了一半的文件
+func (store *fileStore) write(data *fileStoreData) error {
+	dataBytes, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmpPath := store.path + ".tmp"
+	err = ioutil.WriteFile(tmpPath, dataBytes, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, store.path)
+}
+
+func (store *fileStore) view(fn func(data *fileStoreData) error) error {
+	return store.withLock(func() error {
+		data, err := store.read()
+		if err != nil {
+			return err
+		}
+		return fn(data)
+	})
+}
+
+func (store *fileStore) update(fn func(data *fileStoreData) error) error {
+	return store.withLock(func() error {
+		data, err := store.read()
+		if err != nil {
+			return err
+		}
+		err = fn(data)
+		if err != nil {
+			return err
+		}
+		return store.write(data)
+	})
+}
+
+func (store *fileStore) GetState(ctx context.Context, name string) (state string, ok bool, err error) {
+	err = store.view(func(data *fileStoreData) error {
+		state, ok = data.States[name]
+		return nil
+	})
+	if err != nil {
+		log.Error("fail to read registry file", "path", store.path, "err", err)
+	}
+	return state, ok, err
+}
+
+func (store *fileStore) SetState(ctx context.Context, name string, state string) error {
+	err := store.update(func(data *fileStoreData) error {
+		data.States[name] = state
+		return nil
+	})
+	if err != nil {
+		log.Error("fail to update registry file", "path", store.path, "err", err)
+	}
+	return err
+}
+
+func (store *fileStore) ListStates(ctx context.Context) (map[string]string, error) {
+	var states map[string]string
+	err := store.view(func(data *fileStoreData) error {
+		states = data.States
+		return nil
+	})
+	if err != nil {
+		log.Error("fail to read registry file", "path", store.path, "err", err)
+	}
+	return states, err
+}
+
+func (store *fileStore) DeleteState(ctx context.Context, name string) error {
+	err := store.update(func(data *fileStoreData) error {
+		delete(data.States, name)
+		return nil
+	})
+	if err != nil {
+		log.Error("fail to update registry file", "path", store.path, "err", err)
+	}
+	return err
+}
+
+func (store *fileStore) AppendHistory(ctx context.Context, name string, entry HistoryEntry) error {
+	err := store.update(func(data *fileStoreData) error {
+		data.History[name] = append(data.History[name], entry)
+		return nil
+	})
+	if err != nil {
+		log.Error("fail to update registry file", "path", store.path, "err", err)
+	}
+	return err
+}
+
+func (store *fileStore) GetHistory(ctx context.Context, name string) ([]HistoryEntry, error) {
+	history := make([]HistoryEntry, 0)
+	err := store.view(func(data *fileStoreData) error {
+		history = append(history, data.History[name]...)
+		return nil
+	})
+	if err != nil {
+		log.Error("fail to read registry file", "path", store.path, "err", err)
+	}
+	return history, err
+}
+
+func (store *fileStore) SetDeployment(ctx context.Context, name string, deployment Deployment) error {
+	err := store.update(func(data *fileStoreData) error {
+		data.Deployments[name] = deployment
+		return nil
+	})
+	if err != nil {
+		log.Error("fail to update registry file", "path", store.path, "err", err)
+	}
+	return err
+}
+
+func (store *fileStore) GetDeployment(ctx context.Context, name string) (deployment Deployment, ok bool, err error) {
+	err = store.view(func(data *fileStoreData) error {
+		deployment, ok = data.Deployments[name]
+		return nil
+	})
+	if err != nil {
+		log.Error("fail to read registry file", "path", store.path, "err", err)
+	}
+	return deployment, ok, err
+}
+
+func (store *fileStore) AppendRevision(ctx context.Context, name string, revision Revision) (number int, err error) {
+	err = store.update(func(data *fileStoreData) error {
+		data.Revisions[name] = append(data.Revisions[name], revision)
+		number = len(data.Revisions[name])
+		return nil
+	})
+	if err != nil {
+		log.Error("fail to update registry file", "path", store.path, "err", err)
+	}
+	return number, err
+}
+
+func (store *fileStore) GetRevisions(ctx context.Context, name string) ([]Revision, error) {
+	revisions := make([]Revision, 0)
+	err := store.view(func(data *fileStoreData) error {
+		revisions = append(revisions, data.Revisions[name]...)
+		return nil
+	})
+	if err != nil {
+		log.Error("fail to read registry file", "path", store.path, "err", err)
+	}
+	return numberRevisions(revisions), err
+}
+
+func (store *fileStore) ReservePort(ctx context.Context, portReservation PortReservation) (current PortReservation, ok bool, err error) {
+	err = store.update(func(data *fileStoreData) error {
+		current, ok, err = reservePort(data.Ports, portReservation)
+		return err
+	})
+	return current, ok, err
+}
+
+func (store *fileStore) ReleasePort(ctx context.Context, portReservation PortReservation) error {
+	return store.update(func(data *fileStoreData) error {
+		releasePort(data.Ports, portReservation)
+		return nil
+	})
+}
+
+func (store *fileStore) ListPorts(ctx context.Context) (portReservations []PortReservation, err error) {
+	err = store.view(func(data *fileStoreData) error {
+		portReservations = listPorts(data.Ports)
+		return nil
+	})
+	return portReservations, err
+}
+
+func (store *fileStore) TryLock(ctx context.Context, name string, holder string, ttl time.Duration) (lease *Lease, currentHolder string, err error) {
+	err = store.update(func(data *fileStoreData) error {
+		lease, currentHolder = data.lockTable().tryLock(name, holder, ttl, time.Now())
+		return nil
+	})
+	if err != nil {
+		log.Error("fail to update registry file", "path", store.path, "err", err)
+	}
+	return lease, currentHolder, err
+}
+
+func (store *fileStore) RenewLock(ctx context.Context, lease *Lease, ttl time.Duration) error {
+	return store.update(func(data *fileStoreData) error {
+		return data.lockTable().renewLock(lease, ttl, time.Now())
+	})
+}
+
+func (store *fileStore) Unlock(ctx context.Context, lease *Lease) error {
+	err := store.update(func(data *fileStoreData) error {
+		data.lockTable().unlock(lease, time.Now())
+		return nil
+	})
+	if err != nil {
+		log.Error("fail to update registry file", "path", store.path, "err", err)
+	}
+	return err
+}
+
+func (store *fileStore) CompareAndSetState(ctx context.Context, name string, from string, to string, lease *Lease) error {
+	return store.update(func(data *fileStoreData) error {
+		return data.lockTable().compareAndSetState(name, from, to, lease, time.Now())
+	})
+}
+
+// WatchState 轮询文件，状态变化时发送新状态
+func (store *fileStore) WatchState(ctx context.Context, name string) (<-chan string, error) {
+	lastState, _, err := store.GetState(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	watcher := make(chan string, 16)
+	go func() {
+		defer close(watcher)
+		ticker := time.NewTicker(fileStoreWatchInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				state, _, err := store.GetState(ctx, name)
+				if err != nil || state == lastState {
+					continue
+				}
+				lastState = state
+				select {
+				case watcher <- state:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return watcher, nil
+}
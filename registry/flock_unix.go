@@ -1,3 +1,6 @@
+//go:build !windows
+// +build !windows
+
 /*
 Copyright (c) 2022 Zhang Zhanpeng <zhangregister@outlook.com>
 
@@ -19,25 +22,17 @@ LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
 OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
 THE SOFTWARE.
 */
-package main
+package registry
 
 import (
-	"github.com/MonteCarloClub/kether/container"
-	"github.com/MonteCarloClub/kether/log"
-	"github.com/MonteCarloClub/kether/registry"
+	"os"
+	"syscall"
 )
 
-func init() {
-	log.InitLogger()
-	log.Info("logger inited")
-
-	registry.InitRedisClient()
-	log.Info("redis client inited")
+func lockFileExclusive(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_EX)
+}
 
-	err := container.InitDockerApiClient()
-	if err != nil {
-		log.Error("fail to init docker api client", "err", err)
-		return
-	}
-	log.Info("docker api client inited")
+func unlockFile(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
 }
@@ -0,0 +1,66 @@
+/*
+Copyright (c) 2022 Zhang Zhanpeng <zhangregister@outlook.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package registry
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/MonteCarloClub/kether/log"
+)
+
+const (
+	historyKeyPrefix = "history_"
+)
+
+// HistoryEntry 是 Kether 对象的一次状态转换
+type HistoryEntry struct {
+	Timestamp time.Time `json:"timestamp"`
+	From      string    `json:"from"`
+	To        string    `json:"to"`
+	Reason    string    `json:"reason,omitempty"`
+}
+
+func getHistoryKey(name string) string {
+	return fmt.Sprintf("%v%v", historyKeyPrefix, name)
+}
+
+func AppendHistoryOfName(ctx context.Context, name string, entry HistoryEntry) error {
+	key := getHistoryKey(name)
+	err := DefaultStore.AppendHistory(ctx, name, entry)
+	if err != nil {
+		log.Error("fail to append history of kether object", "key", key, "from", entry.From, "to", entry.To, "err", err)
+		return err
+	}
+	return nil
+}
+
+// GetHistoryOfName 按时间顺序返回 Kether 对象的状态转换历史
+func GetHistoryOfName(ctx context.Context, name string) ([]HistoryEntry, error) {
+	history, err := DefaultStore.GetHistory(ctx, name)
+	if err != nil {
+		log.Error("fail to get history of kether object", "key", getHistoryKey(name), "err", err)
+		return nil, err
+	}
+	return history, nil
+}
@@ -0,0 +1,243 @@
+/*
+Copyright (c) 2022 Zhang Zhanpeng <zhangregister@outlook.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package registry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/MonteCarloClub/kether/log"
+)
+
+const (
+	lockKeyPrefix      = "lock_"
+	lockTokenKeyPrefix = "lock_token_"
+
+	lockPollInterval = 500 * time.Millisecond
+)
+
+var (
+	// ErrLeaseLost 表示租约已经过期或被其他持有者获取，持有者不能再修改对象状态
+	ErrLeaseLost = errors.New("lease of kether object lost")
+)
+
+// Lease 是 Kether 对象的租约锁，Token 是每个对象单调递增的栅栏令牌，用于拒绝过期持有者的写入
+type Lease struct {
+	Name   string
+	Holder string
+	Token  int64
+}
+
+func (lease *Lease) value() string {
+	return fmt.Sprintf("%d:%v", lease.Token, lease.Holder)
+}
+
+// parseLeaseValue 从存储的租约值中解析出持有者
+func parseLeaseValue(value string) (int64, string) {
+	parts := strings.SplitN(value, ":", 2)
+	if len(parts) != 2 {
+		return 0, value
+	}
+	token, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return 0, value
+	}
+	return token, parts[1]
+}
+
+// LockHeldError 表示对象的租约锁被其他持有者持有
+type LockHeldError struct {
+	Name   string
+	Holder string
+}
+
+func (err *LockHeldError) Error() string {
+	return fmt.Sprintf("kether object %v is being modified by %v", err.Name, err.Holder)
+}
+
+// StateConflictError 表示对象状态在读取后被其他写入者修改
+type StateConflictError struct {
+	Name     string
+	Expected string
+	Actual   string
+}
+
+func (err *StateConflictError) Error() string {
+	return fmt.Sprintf("state of kether object %v has been changed from %q to %q by another writer", err.Name, err.Expected, err.Actual)
+}
+
+// getLockKey 返回租约锁的键，以状态键作为哈希标签，使 Redis 集群中的锁和状态位于同一个槽，可以在同一个脚本中访问
+func getLockKey(name string) string {
+	return fmt.Sprintf("%v{%v}", lockKeyPrefix, getStateKey(name))
+}
+
+func getLockTokenKey(name string) string {
+	return fmt.Sprintf("%v{%v}", lockTokenKeyPrefix, getStateKey(name))
+}
+
+// GetLockHolder 返回标识当前进程的持有者名称，形如 user@host:pid
+func GetLockHolder() string {
+	username := "unknown"
+	if currentUser, err := user.Current(); err == nil {
+		username = currentUser.Username
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	return fmt.Sprintf("%v@%v:%v", username, hostname, os.Getpid())
+}
+
+// LockOfName 获取 Kether 对象的租约锁，锁被其他持有者持有时最多等待 wait，仍未获取时返回 LockHeldError
+func LockOfName(ctx context.Context, name string, holder string, ttl time.Duration, wait time.Duration) (*Lease, error) {
+	deadline := time.Now().Add(wait)
+	for {
+		lease, currentHolder, err := DefaultStore.TryLock(ctx, name, holder, ttl)
+		if err != nil {
+			log.Error("fail to lock kether object", "key", getLockKey(name), "err", err)
+			return nil, err
+		}
+		if lease != nil {
+			log.Info("kether object locked", "key", getLockKey(name), "holder", holder, "token", lease.Token)
+			return lease, nil
+		}
+		if !time.Now().Before(deadline) {
+			err = &LockHeldError{Name: name, Holder: currentHolder}
+			log.Error("fail to lock kether object", "key", getLockKey(name), "err", err)
+			return nil, err
+		}
+		log.Info("waiting for lock of kether object", "key", getLockKey(name), "holder", currentHolder)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(lockPollInterval):
+		}
+	}
+}
+
+func RenewLockOfName(ctx context.Context, lease *Lease, ttl time.Duration) error {
+	err := DefaultStore.RenewLock(ctx, lease, ttl)
+	if err != nil {
+		log.Error("fail to renew lock of kether object", "key", getLockKey(lease.Name), "token", lease.Token, "err", err)
+		return err
+	}
+	return nil
+}
+
+func UnlockOfName(ctx context.Context, lease *Lease) error {
+	err := DefaultStore.Unlock(ctx, lease)
+	if err != nil {
+		log.Error("fail to unlock kether object", "key", getLockKey(lease.Name), "token", lease.Token, "err", err)
+		return err
+	}
+	log.Info("kether object unlocked", "key", getLockKey(lease.Name), "token", lease.Token)
+	return nil
+}
+
+// CompareAndSetStateOfName 仅当状态仍为 from 且 lease 仍然有效时设置 Kether 对象状态
+func CompareAndSetStateOfName(ctx context.Context, name string, from string, to string, lease *Lease) error {
+	key := getStateKey(name)
+	err := DefaultStore.CompareAndSetState(ctx, name, from, to, lease)
+	if err != nil {
+		log.Error("fail to set state of kether object", "key", key, "from", from, "to", to, "err", err)
+		return err
+	}
+	log.Info("state of kether object set", "key", key, "from", from, "value", to)
+	return nil
+}
+
+// lockRecord 是内存和文件存储后端中的租约锁
+type lockRecord struct {
+	Token     int64     `json:"token"`
+	Holder    string    `json:"holder"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// lockTable 实现内存和文件存储后端共用的租约锁和比较并设置语义，调用方负责互斥
+type lockTable struct {
+	states map[string]string
+	locks  map[string]lockRecord
+	tokens map[string]int64
+}
+
+func (table *lockTable) getLock(name string, now time.Time) (lockRecord, bool) {
+	record, ok := table.locks[name]
+	if !ok || !now.Before(record.ExpiresAt) {
+		return lockRecord{}, false
+	}
+	return record, true
+}
+
+func (table *lockTable) tryLock(name string, holder string, ttl time.Duration, now time.Time) (*Lease, string) {
+	if record, ok := table.getLock(name, now); ok {
+		return nil, record.Holder
+	}
+	table.tokens[name]++
+	table.locks[name] = lockRecord{
+		Token:     table.tokens[name],
+		Holder:    holder,
+		ExpiresAt: now.Add(ttl),
+	}
+	return &Lease{Name: name, Holder: holder, Token: table.tokens[name]}, ""
+}
+
+func (table *lockTable) holds(lease *Lease, now time.Time) bool {
+	record, ok := table.getLock(lease.Name, now)
+	return ok && record.Token == lease.Token && record.Holder == lease.Holder
+}
+
+func (table *lockTable) renewLock(lease *Lease, ttl time.Duration, now time.Time) error {
+	if !table.holds(lease, now) {
+		return ErrLeaseLost
+	}
+	record := table.locks[lease.Name]
+	record.ExpiresAt = now.Add(ttl)
+	table.locks[lease.Name] = record
+	return nil
+}
+
+func (table *lockTable) unlock(lease *Lease, now time.Time) {
+	if table.holds(lease, now) {
+		delete(table.locks, lease.Name)
+	}
+}
+
+func (table *lockTable) compareAndSetState(name string, from string, to string, lease *Lease, now time.Time) error {
+	if lease == nil {
+		if record, ok := table.getLock(name, now); ok {
+			return &LockHeldError{Name: name, Holder: record.Holder}
+		}
+	} else if lease.Name != name || !table.holds(lease, now) {
+		return ErrLeaseLost
+	}
+	if state := table.states[name]; state != from {
+		return &StateConflictError{Name: name, Expected: from, Actual: state}
+	}
+	table.states[name] = to
+	return nil
+}
@@ -0,0 +1,215 @@
+/*
+Copyright (c) 2022 Zhang Zhanpeng <zhangregister@outlook.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package registry
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// memoryStore 把状态保存在进程内存中，用于测试
+type memoryStore struct {
+	mu          sync.Mutex
+	states      map[string]string
+	history     map[string][]HistoryEntry
+	deployments map[string]Deployment
+	revisions   map[string][]Revision
+	ports       map[string]PortReservation
+	locks       *lockTable
+	watchers    map[string][]chan string
+}
+
+func NewMemoryStore() Store {
+	states := make(map[string]string)
+	return &memoryStore{
+		states:      states,
+		history:     make(map[string][]HistoryEntry),
+		deployments: make(map[string]Deployment),
+		revisions:   make(map[string][]Revision),
+		ports:       make(map[string]PortReservation),
+		locks: &lockTable{
+			states: states,
+			locks:  make(map[string]lockRecord),
+			tokens: make(map[string]int64),
+		},
+		watchers: make(map[string][]chan string),
+	}
+}
+
+func (store *memoryStore) GetState(ctx context.Context, name string) (string, bool, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	state, ok := store.states[name]
+	return state, ok, nil
+}
+
+func (store *memoryStore) SetState(ctx context.Context, name string, state string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	store.states[name] = state
+	store.notify(name, state)
+	return nil
+}
+
+// notify 通知监听者状态变化，调用方持有互斥锁
+func (store *memoryStore) notify(name string, state string) {
+	for _, watcher := range store.watchers[name] {
+		select {
+		case watcher <- state:
+		default:
+		}
+	}
+}
+
+func (store *memoryStore) ListStates(ctx context.Context) (map[string]string, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	states := make(map[string]string, len(store.states))
+	for name, state := range store.states {
+		states[name] = state
+	}
+	return states, nil
+}
+
+func (store *memoryStore) DeleteState(ctx context.Context, name string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	delete(store.states, name)
+	return nil
+}
+
+func (store *memoryStore) WatchState(ctx context.Context, name string) (<-chan string, error) {
+	watcher := make(chan string, 16)
+	store.mu.Lock()
+	store.watchers[name] = append(store.watchers[name], watcher)
+	store.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		store.mu.Lock()
+		defer store.mu.Unlock()
+		watchers := store.watchers[name]
+		for i := range watchers {
+			if watchers[i] == watcher {
+				store.watchers[name] = append(watchers[:i], watchers[i+1:]...)
+				break
+			}
+		}
+		close(watcher)
+	}()
+	return watcher, nil
+}
+
+func (store *memoryStore) AppendHistory(ctx context.Context, name string, entry HistoryEntry) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	store.history[name] = append(store.history[name], entry)
+	return nil
+}
+
+func (store *memoryStore) GetHistory(ctx context.Context, name string) ([]HistoryEntry, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	history := make([]HistoryEntry, len(store.history[name]))
+	copy(history, store.history[name])
+	return history, nil
+}
+
+func (store *memoryStore) SetDeployment(ctx context.Context, name string, deployment Deployment) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	store.deployments[name] = deployment
+	return nil
+}
+
+func (store *memoryStore) GetDeployment(ctx context.Context, name string) (Deployment, bool, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	deployment, ok := store.deployments[name]
+	return deployment, ok, nil
+}
+
+func (store *memoryStore) AppendRevision(ctx context.Context, name string, revision Revision) (int, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	store.revisions[name] = append(store.revisions[name], revision)
+	return len(store.revisions[name]), nil
+}
+
+func (store *memoryStore) GetRevisions(ctx context.Context, name string) ([]Revision, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	revisions := make([]Revision, len(store.revisions[name]))
+	copy(revisions, store.revisions[name])
+	return numberRevisions(revisions), nil
+}
+
+func (store *memoryStore) ReservePort(ctx context.Context, portReservation PortReservation) (PortReservation, bool, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	return reservePort(store.ports, portReservation)
+}
+
+func (store *memoryStore) ReleasePort(ctx context.Context, portReservation PortReservation) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	releasePort(store.ports, portReservation)
+	return nil
+}
+
+func (store *memoryStore) ListPorts(ctx context.Context) ([]PortReservation, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	return listPorts(store.ports), nil
+}
+
+func (store *memoryStore) TryLock(ctx context.Context, name string, holder string, ttl time.Duration) (*Lease, string, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	lease, currentHolder := store.locks.tryLock(name, holder, ttl, time.Now())
+	return lease, currentHolder, nil
+}
+
+func (store *memoryStore) RenewLock(ctx context.Context, lease *Lease, ttl time.Duration) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	return store.locks.renewLock(lease, ttl, time.Now())
+}
+
+func (store *memoryStore) Unlock(ctx context.Context, lease *Lease) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	store.locks.unlock(lease, time.Now())
+	return nil
+}
+
+func (store *memoryStore) CompareAndSetState(ctx context.Context, name string, from string, to string, lease *Lease) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	err := store.locks.compareAndSetState(name, from, to, lease, time.Now())
+	if err != nil {
+		return err
+	}
+	store.notify(name, to)
+	return nil
+}
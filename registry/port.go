@@ -0,0 +1,206 @@
+/*
+Copyright (c) 2022 Zhang Zhanpeng <zhangregister@outlook.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package registry
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/MonteCarloClub/kether/log"
+)
+
+const (
+	portKeyPrefix = "port_"
+)
+
+// PortReservation 是 Kether 对象在主机上预留的端口，同一主机上的同一端口和协议只能被一个对象预留
+type PortReservation struct {
+	Host      string    `json:"host"`
+	Port      int       `json:"port"`
+	Protocol  string    `json:"protocol"`
+	Name      string    `json:"name"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// PortRange 是分配主机端口的范围，包含两端
+type PortRange struct {
+	Start, End int
+}
+
+var (
+	// PortRanges 是请求的主机端口被占用时分配端口的范围，由 registry.port_ranges 配置
+	PortRanges = []PortRange{{8000, 8999}}
+)
+
+func (portReservation PortReservation) key() string {
+	return fmt.Sprintf("%v/%v/%v", portReservation.Host, portReservation.Port, portReservation.Protocol)
+}
+
+func getPortKey(portReservation PortReservation) string {
+	return fmt.Sprintf("%v%v", portKeyPrefix, portReservation.key())
+}
+
+// ParsePortRanges 解析 start-end 形式的端口范围
+func ParsePortRanges(values []string) ([]PortRange, error) {
+	portRanges := make([]PortRange, 0, len(values))
+	for _, value := range values {
+		bounds := strings.SplitN(value, "-", 2)
+		start, err := strconv.Atoi(bounds[0])
+		end := start
+		if err == nil && len(bounds) == 2 {
+			end, err = strconv.Atoi(bounds[1])
+		}
+		if err != nil || start < 1 || end > 65535 || start > end {
+			return nil, fmt.Errorf("invalid port range %q, expect start-end between 1 and 65535", value)
+		}
+		portRanges = append(portRanges, PortRange{start, end})
+	}
+	return portRanges, nil
+}
+
+// ReservePortOfName 为 Kether 对象预留主机端口，端口已经被该对象预留时也成功；被其他对象预留时返回 ok == false 和当前的预留
+func ReservePortOfName(ctx context.Context, name string, host string, port int, protocol string) (PortReservation, bool, error) {
+	portReservation := PortReservation{
+		Host:      host,
+		Port:      port,
+		Protocol:  protocol,
+		Name:      name,
+		Timestamp: time.Now().UTC(),
+	}
+	current, ok, err := DefaultStore.ReservePort(ctx, portReservation)
+	if err != nil {
+		log.Error("fail to reserve port of kether object", "key", getPortKey(portReservation), "name", name, "err", err)
+		return PortReservation{}, false, err
+	}
+	return current, ok, nil
+}
+
+// AllocatePortOfName 在 PortRanges 中为 Kether 对象分配并预留一个主机端口，isAvailable 检查端口是否被主机上的其他进程占用；
+// 对象已经预留的端口也可以分配，exclude 中的端口，如对象在同一次部署中已经使用的端口，不再分配
+func AllocatePortOfName(ctx context.Context, name string, host string, protocol string, isAvailable func(port int) bool, exclude map[int]bool) (int, error) {
+	for _, portRange := range PortRanges {
+		for port := portRange.Start; port <= portRange.End; port++ {
+			if exclude[port] || !isAvailable(port) {
+				continue
+			}
+			_, ok, err := ReservePortOfName(ctx, name, host, port, protocol)
+			if err != nil {
+				return 0, err
+			}
+			if ok {
+				return port, nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("no %v port available on host %v in port ranges %v", protocol, host, PortRanges)
+}
+
+// ReleasePortOfName 释放 Kether 对象预留的一个主机端口，端口被其他对象预留时不做改动
+func ReleasePortOfName(ctx context.Context, name string, host string, port int, protocol string) error {
+	portReservation := PortReservation{
+		Host:     host,
+		Port:     port,
+		Protocol: protocol,
+		Name:     name,
+	}
+	err := DefaultStore.ReleasePort(ctx, portReservation)
+	if err != nil {
+		log.Error("fail to release port of kether object", "key", getPortKey(portReservation), "name", name, "err", err)
+		return err
+	}
+	return nil
+}
+
+// ReleasePortsOfName 释放 Kether 对象预留的端口，keep 中的端口保留
+func ReleasePortsOfName(ctx context.Context, name string, keep []PortReservation) error {
+	portReservations, err := DefaultStore.ListPorts(ctx)
+	if err != nil {
+		log.Error("fail to list ports", "err", err)
+		return err
+	}
+	kept := make(map[string]bool, len(keep))
+	for _, portReservation := range keep {
+		kept[portReservation.key()] = true
+	}
+	for _, portReservation := range portReservations {
+		if portReservation.Name != name || kept[portReservation.key()] {
+			continue
+		}
+		err = DefaultStore.ReleasePort(ctx, portReservation)
+		if err != nil {
+			log.Error("fail to release port of kether object", "key", getPortKey(portReservation), "name", name, "err", err)
+			return err
+		}
+		log.Info("port of kether object released", "name", name, "host", portReservation.Host, "port", portReservation.Port, "protocol", portReservation.Protocol)
+	}
+	return nil
+}
+
+// ListPortReservations 按主机、端口和协议的顺序返回所有预留的端口
+func ListPortReservations(ctx context.Context) ([]PortReservation, error) {
+	portReservations, err := DefaultStore.ListPorts(ctx)
+	if err != nil {
+		log.Error("fail to list ports", "err", err)
+		return nil, err
+	}
+	sortPortReservations(portReservations)
+	return portReservations, nil
+}
+
+func sortPortReservations(portReservations []PortReservation) {
+	sort.Slice(portReservations, func(i, j int) bool {
+		if portReservations[i].Host != portReservations[j].Host {
+			return portReservations[i].Host < portReservations[j].Host
+		}
+		if portReservations[i].Port != portReservations[j].Port {
+			return portReservations[i].Port < portReservations[j].Port
+		}
+		return portReservations[i].Protocol < portReservations[j].Protocol
+	})
+}
+
+// reservePort 在以 PortReservation.key 索引的预留表中预留端口，供内存和文件存储后端使用
+func reservePort(ports map[string]PortReservation, portReservation PortReservation) (PortReservation, bool, error) {
+	if current, ok := ports[portReservation.key()]; ok && current.Name != portReservation.Name {
+		return current, false, nil
+	}
+	ports[portReservation.key()] = portReservation
+	return portReservation, true, nil
+}
+
+func releasePort(ports map[string]PortReservation, portReservation PortReservation) {
+	if current, ok := ports[portReservation.key()]; ok && current.Name == portReservation.Name {
+		delete(ports, portReservation.key())
+	}
+}
+
+func listPorts(ports map[string]PortReservation) []PortReservation {
+	portReservations := make([]PortReservation, 0, len(ports))
+	for _, portReservation := range ports {
+		portReservations = append(portReservations, portReservation)
+	}
+	return portReservations
+}
@@ -0,0 +1,80 @@
+/*
+Copyright (c) 2022 Zhang Zhanpeng <zhangregister@outlook.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package registry
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParsePortRanges(t *testing.T) {
+	portRanges, err := ParsePortRanges([]string{"8000-8999", "30000"})
+	assert.Nil(t, err)
+	assert.Equal(t, []PortRange{{8000, 8999}, {30000, 30000}}, portRanges)
+	for _, value := range []string{"", "0-10", "9000-8000", "8000-70000", "a-b"} {
+		_, err = ParsePortRanges([]string{value})
+		assert.NotNil(t, err, value)
+	}
+}
+
+func TestAllocatePortOfName(t *testing.T) {
+	ctx := context.Background()
+	DefaultStore = NewMemoryStore()
+	defaultPortRanges := PortRanges
+	PortRanges = []PortRange{{9000, 9002}}
+	defer func() {
+		PortRanges = defaultPortRanges
+	}()
+
+	_, ok, err := ReservePortOfName(ctx, "o1", "h1", 9000, "tcp")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	isAvailable := func(port int) bool {
+		return port != 9001
+	}
+	port, err := AllocatePortOfName(ctx, "o2", "h1", "tcp", isAvailable, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 9002, port)
+	_, err = AllocatePortOfName(ctx, "o3", "h1", "tcp", isAvailable, nil)
+	assert.NotNil(t, err)
+	port, err = AllocatePortOfName(ctx, "o3", "h1", "udp", isAvailable, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 9000, port)
+
+	// 对象已经预留的端口可以再次分配，除非被排除
+	port, err = AllocatePortOfName(ctx, "o2", "h1", "tcp", isAvailable, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 9002, port)
+	_, err = AllocatePortOfName(ctx, "o2", "h1", "tcp", isAvailable, map[int]bool{9002: true})
+	assert.NotNil(t, err)
+
+	assert.Nil(t, ReleasePortsOfName(ctx, "o2", nil))
+	assert.Nil(t, ReleasePortsOfName(ctx, "o3", []PortReservation{{Host: "h1", Port: 9000, Protocol: "udp"}}))
+	portReservations, err := ListPortReservations(ctx)
+	assert.Nil(t, err)
+	assert.Len(t, portReservations, 2)
+	assert.Equal(t, "o1", portReservations[0].Name)
+	assert.Equal(t, "tcp", portReservations[0].Protocol)
+	assert.Equal(t, "o3", portReservations[1].Name)
+}
@@ -22,22 +22,559 @@ THE SOFTWARE.
 package registry
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"time"
+
 	"github.com/MonteCarloClub/kether/log"
 	"github.com/go-redis/redis/v8"
 )
 
+const (
+	defaultRedisAddr        = "localhost:6379"
+	defaultRedisDialTimeout = 5 * time.Second
+)
+
 var (
-	RedisClient *redis.Client
+	RedisClient redis.UniversalClient
 )
 
-func initRedisClient() *redis.Client {
-	redisClient := redis.NewClient(&redis.Options{
-		Addr: "localhost:6379",
-	})
-	return redisClient
+// RedisTLSConfig 是连接 Redis 的 TLS 配置，对应配置文件的 redis.tls 字段
+type RedisTLSConfig struct {
+	Enabled            bool
+	CAFile             string
+	CertFile           string
+	KeyFile            string
+	ServerName         string
+	InsecureSkipVerify bool
+}
+
+// RedisConfig 是 Redis 连接配置，对应配置文件的 redis 字段；
+// 指定 SentinelMaster 时通过哨兵连接主节点，Cluster 为 true 时 Addrs 是集群的种子节点
+type RedisConfig struct {
+	Addrs            []string
+	Username         string
+	Password         string
+	DB               int
+	TLS              RedisTLSConfig
+	SentinelMaster   string
+	SentinelAddrs    []string
+	SentinelUsername string
+	SentinelPassword string
+	Cluster          bool
+	DialTimeout      time.Duration
+}
+
+func (redisConfig *RedisConfig) getTLSConfig() (*tls.Config, error) {
+	tlsConfig := redisConfig.TLS
+	if !tlsConfig.Enabled && tlsConfig.CAFile == "" && tlsConfig.CertFile == "" {
+		return nil, nil
+	}
+	config := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         tlsConfig.ServerName,
+		InsecureSkipVerify: tlsConfig.InsecureSkipVerify,
+	}
+	if tlsConfig.CAFile != "" {
+		caBytes, err := ioutil.ReadFile(tlsConfig.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("fail to read redis.tls.ca_file: %v", err)
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(caBytes) {
+			return nil, fmt.Errorf("no certificate found in redis.tls.ca_file %v", tlsConfig.CAFile)
+		}
+	}
+	if (tlsConfig.CertFile == "") != (tlsConfig.KeyFile == "") {
+		return nil, fmt.Errorf("redis.tls.cert_file and redis.tls.key_file must be set together")
+	}
+	if tlsConfig.CertFile != "" {
+		certificate, err := tls.LoadX509KeyPair(tlsConfig.CertFile, tlsConfig.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("fail to load redis.tls.cert_file and redis.tls.key_file: %v", err)
+		}
+		config.Certificates = []tls.Certificate{certificate}
+	}
+	return config, nil
 }
 
-func InitRedisClient() {
-	RedisClient = initRedisClient()
+// getUniversalOptions 把 Redis 连接配置转换为 go-redis 的选项
+func (redisConfig *RedisConfig) getUniversalOptions() (*redis.UniversalOptions, error) {
+	if redisConfig.SentinelMaster != "" && redisConfig.Cluster {
+		return nil, fmt.Errorf("redis.sentinel.master and redis.cluster cannot be set together")
+	}
+	tlsConfig, err := redisConfig.getTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	universalOptions := &redis.UniversalOptions{
+		Addrs:       redisConfig.Addrs,
+		DB:          redisConfig.DB,
+		Username:    redisConfig.Username,
+		Password:    redisConfig.Password,
+		TLSConfig:   tlsConfig,
+		DialTimeout: redisConfig.DialTimeout,
+	}
+	if len(universalOptions.Addrs) == 0 {
+		universalOptions.Addrs = []string{defaultRedisAddr}
+	}
+	if universalOptions.DialTimeout <= 0 {
+		universalOptions.DialTimeout = defaultRedisDialTimeout
+	}
+	if redisConfig.SentinelMaster != "" {
+		if len(redisConfig.SentinelAddrs) == 0 {
+			return nil, fmt.Errorf("redis.sentinel.addrs is required when redis.sentinel.master is set")
+		}
+		universalOptions.MasterName = redisConfig.SentinelMaster
+		universalOptions.Addrs = redisConfig.SentinelAddrs
+		universalOptions.SentinelUsername = redisConfig.SentinelUsername
+		universalOptions.SentinelPassword = redisConfig.SentinelPassword
+	}
+	if redisConfig.Cluster && redisConfig.DB != 0 {
+		return nil, fmt.Errorf("redis.db must be 0 in cluster mode")
+	}
+	return universalOptions, nil
+}
+
+func initRedisClient(redisConfig RedisConfig) (redis.UniversalClient, error) {
+	universalOptions, err := redisConfig.getUniversalOptions()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case universalOptions.MasterName != "":
+		return redis.NewFailoverClient(universalOptions.Failover()), nil
+	case redisConfig.Cluster:
+		return redis.NewClusterClient(universalOptions.Cluster()), nil
+	default:
+		if len(universalOptions.Addrs) > 1 {
+			return nil, fmt.Errorf("%v redis addresses given, set redis.cluster to connect to a cluster", len(universalOptions.Addrs))
+		}
+		return redis.NewClient(universalOptions.Simple()), nil
+	}
+}
+
+// InitRedisClient 按配置连接 Redis 并在超时时间内检查连接，连接失败时返回说明如何修正配置的错误
+func InitRedisClient(redisConfig RedisConfig) error {
+	redisClient, err := initRedisClient(redisConfig)
+	if err != nil {
+		log.Error("invalid redis config", "err", err)
+		return fmt.Errorf("invalid redis config: %v", err)
+	}
+
+	dialTimeout := redisConfig.DialTimeout
+	if dialTimeout <= 0 {
+		dialTimeout = defaultRedisDialTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), dialTimeout)
+	defer cancel()
+	err = redisClient.Ping(ctx).Err()
+	if err != nil {
+		redisClient.Close()
+		addrs := redisConfig.Addrs
+		if redisConfig.SentinelMaster != "" {
+			addrs = redisConfig.SentinelAddrs
+		}
+		if len(addrs) == 0 {
+			addrs = []string{defaultRedisAddr}
+		}
+		log.Error("fail to ping redis", "addrs", addrs, "err", err)
+		return fmt.Errorf("cannot connect to redis at %v: %v; "+
+			"check redis.addr, redis.password, redis.tls and redis.sentinel in the config file or the KETHER_REDIS_* environment variables, "+
+			"or set registry.backend to file to run without redis", strings.Join(addrs, ","), err)
+	}
+	RedisClient = redisClient
 	log.Info("redis client inited")
+	return nil
+}
+
+var (
+	// renewLockScript 仅当租约值未变时延长租约
+	renewLockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0
+`)
+	// unlockScript 仅当租约值未变时释放租约
+	unlockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+	// releasePortScript 仅当端口仍被同一对象预留时释放端口
+	releasePortScript = redis.NewScript(`
+local value = redis.call("GET", KEYS[1])
+if value and cjson.decode(value).name == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+	// compareAndSetStateScript 检查租约和当前状态后设置状态并发布，ARGV[3] 为空表示调用方不持有租约
+	compareAndSetStateScript = redis.NewScript(`
+local lock = redis.call("GET", KEYS[2])
+if ARGV[3] == "" then
+	if lock then
+		return {"locked", lock}
+	end
+elseif lock ~= ARGV[3] then
+	return {"lost", ""}
+end
+local state = redis.call("GET", KEYS[1])
+if not state then
+	state = ""
+end
+if state ~= ARGV[1] then
+	return {"conflict", state}
+end
+redis.call("SET", KEYS[1], ARGV[2])
+redis.call("PUBLISH", KEYS[1], ARGV[2])
+return {"ok", ""}
+`)
+)
+
+// redisStore 把状态保存在 Redis 中，状态变化通过与状态键同名的频道发布
+type redisStore struct {
+	redisClient redis.UniversalClient
+}
+
+func NewRedisStore(redisClient redis.UniversalClient) Store {
+	return &redisStore{
+		redisClient: redisClient,
+	}
+}
+
+func (store *redisStore) GetState(ctx context.Context, name string) (string, bool, error) {
+	state, err := store.redisClient.Get(ctx, getStateKey(name)).Result()
+	if err == redis.Nil {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return state, true, nil
+}
+
+func (store *redisStore) SetState(ctx context.Context, name string, state string) error {
+	key := getStateKey(name)
+	err := store.redisClient.Set(ctx, key, state, 0).Err()
+	if err != nil {
+		return err
+	}
+	err = store.redisClient.Publish(ctx, key, state).Err()
+	if err != nil {
+		log.Warn("fail to publish state of kether object", "key", key, "err", err)
+	}
+	return nil
+}
+
+// scanKeys 扫描匹配 pattern 的键，集群模式下扫描每个主节点
+func (store *redisStore) scanKeys(ctx context.Context, pattern string) ([]string, error) {
+	var keysMutex sync.Mutex
+	keys := make([]string, 0)
+	scan := func(ctx context.Context, redisClient redis.UniversalClient) error {
+		iter := redisClient.Scan(ctx, 0, pattern, 0).Iterator()
+		for iter.Next(ctx) {
+			keysMutex.Lock()
+			keys = append(keys, iter.Val())
+			keysMutex.Unlock()
+		}
+		return iter.Err()
+	}
+
+	clusterClient, ok := store.redisClient.(*redis.ClusterClient)
+	if !ok {
+		return keys, scan(ctx, store.redisClient)
+	}
+	err := clusterClient.ForEachMaster(ctx, func(ctx context.Context, redisClient *redis.Client) error {
+		return scan(ctx, redisClient)
+	})
+	return keys, err
+}
+
+func (store *redisStore) ListStates(ctx context.Context) (map[string]string, error) {
+	keys, err := store.scanKeys(ctx, stateKeyPrefix+"*")
+	if err != nil {
+		return nil, err
+	}
+
+	// 集群模式下状态键分布在不同的槽，用流水线逐个读取而不是 MGET
+	getCmds := make([]*redis.StringCmd, len(keys))
+	_, err = store.redisClient.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, key := range keys {
+			getCmds[i] = pipe.Get(ctx, key)
+		}
+		return nil
+	})
+	if err != nil && err != redis.Nil {
+		return nil, err
+	}
+	states := make(map[string]string, len(keys))
+	for i, key := range keys {
+		state, err := getCmds[i].Result()
+		// 扫描和读取之间被删除的键
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		states[strings.TrimPrefix(key, stateKeyPrefix)] = state
+	}
+	return states, nil
+}
+
+func (store *redisStore) DeleteState(ctx context.Context, name string) error {
+	return store.redisClient.Del(ctx, getStateKey(name)).Err()
+}
+
+func (store *redisStore) WatchState(ctx context.Context, name string) (<-chan string, error) {
+	pubSub := store.redisClient.Subscribe(ctx, getStateKey(name))
+	_, err := pubSub.Receive(ctx)
+	if err != nil {
+		pubSub.Close()
+		return nil, err
+	}
+
+	watcher := make(chan string, 16)
+	go func() {
+		defer close(watcher)
+		defer pubSub.Close()
+		messageChan := pubSub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case message, ok := <-messageChan:
+				if !ok {
+					return
+				}
+				select {
+				case watcher <- message.Payload:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return watcher, nil
+}
+
+// AppendHistory 把状态转换历史以 JSON 追加到与历史键同名的列表
+func (store *redisStore) AppendHistory(ctx context.Context, name string, entry HistoryEntry) error {
+	entryBytes, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return store.redisClient.RPush(ctx, getHistoryKey(name), entryBytes).Err()
+}
+
+func (store *redisStore) GetHistory(ctx context.Context, name string) ([]HistoryEntry, error) {
+	values, err := store.redisClient.LRange(ctx, getHistoryKey(name), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	history := make([]HistoryEntry, 0, len(values))
+	for _, value := range values {
+		entry := HistoryEntry{}
+		err = json.Unmarshal([]byte(value), &entry)
+		if err != nil {
+			log.Warn("invalid history of kether object", "key", getHistoryKey(name), "value", value, "err", err)
+			continue
+		}
+		history = append(history, entry)
+	}
+	return history, nil
+}
+
+// SetDeployment 把部署记录以 JSON 保存在部署键中
+func (store *redisStore) SetDeployment(ctx context.Context, name string, deployment Deployment) error {
+	deploymentBytes, err := json.Marshal(deployment)
+	if err != nil {
+		return err
+	}
+	return store.redisClient.Set(ctx, getDeploymentKey(name), deploymentBytes, 0).Err()
+}
+
+func (store *redisStore) GetDeployment(ctx context.Context, name string) (Deployment, bool, error) {
+	value, err := store.redisClient.Get(ctx, getDeploymentKey(name)).Result()
+	if err == redis.Nil {
+		return Deployment{}, false, nil
+	}
+	if err != nil {
+		return Deployment{}, false, err
+	}
+	deployment := Deployment{}
+	err = json.Unmarshal([]byte(value), &deployment)
+	if err != nil {
+		return Deployment{}, false, fmt.Errorf("invalid deployment of kether object %v: %v", name, err)
+	}
+	return deployment, true, nil
+}
+
+// AppendRevision 把修订以 JSON 追加到与修订键同名的列表，列表的长度即新修订的编号
+func (store *redisStore) AppendRevision(ctx context.Context, name string, revision Revision) (int, error) {
+	revisionBytes, err := json.Marshal(revision)
+	if err != nil {
+		return 0, err
+	}
+	length, err := store.redisClient.RPush(ctx, getRevisionKey(name), revisionBytes).Result()
+	return int(length), err
+}
+
+// GetRevisions 按列表中的位置为修订编号，无法解析的修订被跳过但不影响其他修订的编号
+func (store *redisStore) GetRevisions(ctx context.Context, name string) ([]Revision, error) {
+	values, err := store.redisClient.LRange(ctx, getRevisionKey(name), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	revisions := make([]Revision, 0, len(values))
+	for i, value := range values {
+		revision := Revision{}
+		err = json.Unmarshal([]byte(value), &revision)
+		if err != nil {
+			log.Warn("invalid revision of kether object", "key", getRevisionKey(name), "value", value, "err", err)
+			continue
+		}
+		revision.Revision = i + 1
+		revisions = append(revisions, revision)
+	}
+	return revisions, nil
+}
+
+// ReservePort 用 SET NX 预留端口，预留失败时读取当前的预留
+func (store *redisStore) ReservePort(ctx context.Context, portReservation PortReservation) (PortReservation, bool, error) {
+	portReservationBytes, err := json.Marshal(portReservation)
+	if err != nil {
+		return PortReservation{}, false, err
+	}
+	key := getPortKey(portReservation)
+	reserved, err := store.redisClient.SetNX(ctx, key, portReservationBytes, 0).Result()
+	if err != nil {
+		return PortReservation{}, false, err
+	}
+	if reserved {
+		return portReservation, true, nil
+	}
+	value, err := store.redisClient.Get(ctx, key).Result()
+	// 读取前被释放的端口重新预留
+	if err == redis.Nil {
+		return store.ReservePort(ctx, portReservation)
+	}
+	if err != nil {
+		return PortReservation{}, false, err
+	}
+	current := PortReservation{}
+	err = json.Unmarshal([]byte(value), &current)
+	if err != nil {
+		return PortReservation{}, false, fmt.Errorf("invalid port reservation %v: %v", key, err)
+	}
+	return current, current.Name == portReservation.Name, nil
+}
+
+func (store *redisStore) ReleasePort(ctx context.Context, portReservation PortReservation) error {
+	return releasePortScript.Run(ctx, store.redisClient, []string{getPortKey(portReservation)}, portReservation.Name).Err()
+}
+
+func (store *redisStore) ListPorts(ctx context.Context) ([]PortReservation, error) {
+	keys, err := store.scanKeys(ctx, portKeyPrefix+"*")
+	if err != nil {
+		return nil, err
+	}
+	portReservations := make([]PortReservation, 0, len(keys))
+	for _, key := range keys {
+		value, err := store.redisClient.Get(ctx, key).Result()
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		portReservation := PortReservation{}
+		err = json.Unmarshal([]byte(value), &portReservation)
+		if err != nil {
+			log.Warn("invalid port reservation", "key", key, "value", value, "err", err)
+			continue
+		}
+		portReservations = append(portReservations, portReservation)
+	}
+	return portReservations, nil
+}
+
+// TryLock 用 INCR 生成栅栏令牌，再用 SET NX PX 获取租约
+func (store *redisStore) TryLock(ctx context.Context, name string, holder string, ttl time.Duration) (*Lease, string, error) {
+	token, err := store.redisClient.Incr(ctx, getLockTokenKey(name)).Result()
+	if err != nil {
+		return nil, "", err
+	}
+	lease := &Lease{Name: name, Holder: holder, Token: token}
+	ok, err := store.redisClient.SetNX(ctx, getLockKey(name), lease.value(), ttl).Result()
+	if err != nil {
+		return nil, "", err
+	}
+	if ok {
+		return lease, "", nil
+	}
+	value, err := store.redisClient.Get(ctx, getLockKey(name)).Result()
+	if err == redis.Nil {
+		// 租约恰好过期，由调用方重试
+		return nil, "", nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	_, currentHolder := parseLeaseValue(value)
+	return nil, currentHolder, nil
+}
+
+func (store *redisStore) RenewLock(ctx context.Context, lease *Lease, ttl time.Duration) error {
+	renewed, err := renewLockScript.Run(ctx, store.redisClient, []string{getLockKey(lease.Name)}, lease.value(), ttl.Milliseconds()).Int64()
+	if err != nil {
+		return err
+	}
+	if renewed == 0 {
+		return ErrLeaseLost
+	}
+	return nil
+}
+
+func (store *redisStore) Unlock(ctx context.Context, lease *Lease) error {
+	return unlockScript.Run(ctx, store.redisClient, []string{getLockKey(lease.Name)}, lease.value()).Err()
+}
+
+func (store *redisStore) CompareAndSetState(ctx context.Context, name string, from string, to string, lease *Lease) error {
+	leaseValue := ""
+	if lease != nil {
+		if lease.Name != name {
+			return ErrLeaseLost
+		}
+		leaseValue = lease.value()
+	}
+	result, err := compareAndSetStateScript.Run(ctx, store.redisClient, []string{getStateKey(name), getLockKey(name)}, from, to, leaseValue).StringSlice()
+	if err != nil {
+		return err
+	}
+	if len(result) != 2 {
+		return fmt.Errorf("unexpected result of compare and set state: %v", result)
+	}
+	switch result[0] {
+	case "ok":
+		return nil
+	case "locked":
+		_, currentHolder := parseLeaseValue(result[1])
+		return &LockHeldError{Name: name, Holder: currentHolder}
+	case "lost":
+		return ErrLeaseLost
+	case "conflict":
+		return &StateConflictError{Name: name, Expected: from, Actual: result[1]}
+	default:
+		return fmt.Errorf("unexpected result of compare and set state: %v", result)
+	}
 }
@@ -24,16 +24,23 @@ package registry
 import (
 	"context"
 	"testing"
+	"time"
 
+	"github.com/MonteCarloClub/kether/log"
 	"github.com/go-redis/redis/v8"
 	"github.com/stretchr/testify/assert"
 )
 
+func init() {
+	log.InitLogger()
+}
+
 func TestInitRedisClient(t *testing.T) {
-	redisClient := initRedisClient()
+	redisClient, err := initRedisClient(RedisConfig{})
+	assert.Nil(t, err)
 
 	ctx := context.Background()
-	err := redisClient.Set(ctx, "k1", "v1", 0).Err()
+	err = redisClient.Set(ctx, "k1", "v1", 0).Err()
 	assert.Nil(t, err)
 
 	v1, err := redisClient.Get(ctx, "k1").Result()
@@ -47,3 +54,45 @@ func TestInitRedisClient(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, int64(1), delInt64)
 }
+
+func TestRedisConfig(t *testing.T) {
+	universalOptions, err := (&RedisConfig{}).getUniversalOptions()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{defaultRedisAddr}, universalOptions.Addrs)
+	assert.Equal(t, defaultRedisDialTimeout, universalOptions.DialTimeout)
+	assert.Nil(t, universalOptions.TLSConfig)
+
+	universalOptions, err = (&RedisConfig{
+		Addrs:          []string{"10.0.0.1:6379"},
+		Password:       "secret",
+		DB:             2,
+		TLS:            RedisTLSConfig{Enabled: true, ServerName: "redis.internal"},
+		SentinelMaster: "mymaster",
+		SentinelAddrs:  []string{"10.0.0.2:26379", "10.0.0.3:26379"},
+	}).getUniversalOptions()
+	assert.Nil(t, err)
+	assert.Equal(t, "mymaster", universalOptions.MasterName)
+	assert.Equal(t, []string{"10.0.0.2:26379", "10.0.0.3:26379"}, universalOptions.Addrs)
+	assert.Equal(t, "redis.internal", universalOptions.TLSConfig.ServerName)
+
+	redisClient, err := initRedisClient(RedisConfig{Addrs: []string{"10.0.0.1:6379", "10.0.0.2:6379"}, Cluster: true})
+	assert.Nil(t, err)
+	_, ok := redisClient.(*redis.ClusterClient)
+	assert.True(t, ok)
+	redisClient.Close()
+
+	for _, redisConfig := range []RedisConfig{
+		{Addrs: []string{"10.0.0.1:6379", "10.0.0.2:6379"}},
+		{SentinelMaster: "mymaster"},
+		{SentinelMaster: "mymaster", SentinelAddrs: []string{"10.0.0.2:26379"}, Cluster: true},
+		{Cluster: true, DB: 1},
+		{TLS: RedisTLSConfig{CertFile: "client.crt"}},
+		{TLS: RedisTLSConfig{CAFile: "/nonexistent/ca.crt"}},
+	} {
+		_, err = initRedisClient(redisConfig)
+		assert.NotNil(t, err, redisConfig)
+	}
+
+	err = InitRedisClient(RedisConfig{Addrs: []string{"127.0.0.1:1"}, DialTimeout: 100 * time.Millisecond})
+	assert.Contains(t, err.Error(), "cannot connect to redis at 127.0.0.1:1")
+}
@@ -0,0 +1,81 @@
+/*
+Copyright (c) 2022 Zhang Zhanpeng <zhangregister@outlook.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/MonteCarloClub/kether/log"
+)
+
+const (
+	revisionKeyPrefix = "revision_"
+)
+
+// Revision 是 Kether 对象的一次成功部署的修订，Spec 是解析后的完整对象，可以据此重新部署；
+// Revision 由存储后端按追加顺序从 1 开始编号
+type Revision struct {
+	Revision    int             `json:"revision"`
+	Spec        json.RawMessage `json:"spec"`
+	ConfigHash  string          `json:"config_hash"`
+	Image       string          `json:"image"`
+	ImageDigest string          `json:"image_digest,omitempty"`
+	ImageID     string          `json:"image_id,omitempty"`
+	// RollbackOf 是回滚的目标修订，不是回滚产生的修订为 0
+	RollbackOf int       `json:"rollback_of,omitempty"`
+	Timestamp  time.Time `json:"timestamp"`
+}
+
+func getRevisionKey(name string) string {
+	return fmt.Sprintf("%v%v", revisionKeyPrefix, name)
+}
+
+// AppendRevisionOfName 追加一个修订，返回它的编号
+func AppendRevisionOfName(ctx context.Context, name string, revision Revision) (int, error) {
+	number, err := DefaultStore.AppendRevision(ctx, name, revision)
+	if err != nil {
+		log.Error("fail to append revision of kether object", "key", getRevisionKey(name), "configHash", revision.ConfigHash, "err", err)
+		return 0, err
+	}
+	return number, nil
+}
+
+// GetRevisionsOfName 按编号顺序返回 Kether 对象的修订
+func GetRevisionsOfName(ctx context.Context, name string) ([]Revision, error) {
+	revisions, err := DefaultStore.GetRevisions(ctx, name)
+	if err != nil {
+		log.Error("fail to get revisions of kether object", "key", getRevisionKey(name), "err", err)
+		return nil, err
+	}
+	return revisions, nil
+}
+
+// numberRevisions 按追加顺序为修订编号
+func numberRevisions(revisions []Revision) []Revision {
+	for i := range revisions {
+		revisions[i].Revision = i + 1
+	}
+	return revisions
+}
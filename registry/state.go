@@ -24,17 +24,22 @@ package registry
 import (
 	"context"
 	"fmt"
+	"sort"
 
 	"github.com/MonteCarloClub/kether/log"
 )
 
+const (
+	stateKeyPrefix = "state_"
+)
+
 func getStateKey(name string) string {
-	return fmt.Sprintf("state_%v", name)
+	return fmt.Sprintf("%v%v", stateKeyPrefix, name)
 }
 
 func SetStateOfName(ctx context.Context, name string, state string) error {
 	key := getStateKey(name)
-	err := RedisClient.Set(ctx, key, state, 0).Err()
+	err := DefaultStore.SetState(ctx, name, state)
 	if err != nil {
 		log.Error("fail to set state of kether object", "key", key, "value", state, "err", err)
 		return err
@@ -42,3 +47,49 @@ func SetStateOfName(ctx context.Context, name string, state string) error {
 	log.Info("state of kether object set", "key", key, "value", state)
 	return nil
 }
+
+// GetStateOfName 读取 Kether 对象状态，对象未注册时返回 ok == false
+func GetStateOfName(ctx context.Context, name string) (state string, ok bool, err error) {
+	state, ok, err = DefaultStore.GetState(ctx, name)
+	if err != nil {
+		log.Error("fail to get state of kether object", "key", getStateKey(name), "err", err)
+		return "", false, err
+	}
+	return state, ok, nil
+}
+
+// ListNames 返回所有已注册 Kether 对象的名称
+func ListNames(ctx context.Context) ([]string, error) {
+	states, err := DefaultStore.ListStates(ctx)
+	if err != nil {
+		log.Error("fail to list states of kether objects", "err", err)
+		return nil, err
+	}
+	names := make([]string, 0, len(states))
+	for name := range states {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func DeleteStateOfName(ctx context.Context, name string) error {
+	key := getStateKey(name)
+	err := DefaultStore.DeleteState(ctx, name)
+	if err != nil {
+		log.Error("fail to delete state of kether object", "key", key, "err", err)
+		return err
+	}
+	log.Info("state of kether object deleted", "key", key)
+	return nil
+}
+
+// WatchStateOfName 监听 Kether 对象状态的变化，ctx 结束时停止监听
+func WatchStateOfName(ctx context.Context, name string) (<-chan string, error) {
+	watcher, err := DefaultStore.WatchState(ctx, name)
+	if err != nil {
+		log.Error("fail to watch state of kether object", "key", getStateKey(name), "err", err)
+		return nil, err
+	}
+	return watcher, nil
+}
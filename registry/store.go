@@ -0,0 +1,117 @@
+/*
+Copyright (c) 2022 Zhang Zhanpeng <zhangregister@outlook.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package registry
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/MonteCarloClub/kether/log"
+)
+
+const (
+	BackendRedis  = "redis"
+	BackendMemory = "memory"
+	BackendFile   = "file"
+)
+
+// Store 是 Kether 对象状态的存储后端
+type Store interface {
+	// GetState 读取对象状态，对象未注册时返回 ok == false
+	GetState(ctx context.Context, name string) (state string, ok bool, err error)
+	SetState(ctx context.Context, name string, state string) error
+	// ListStates 返回所有对象名称到状态的映射
+	ListStates(ctx context.Context) (map[string]string, error)
+	DeleteState(ctx context.Context, name string) error
+	// WatchState 监听对象状态的变化，ctx 结束时关闭返回的通道
+	WatchState(ctx context.Context, name string) (<-chan string, error)
+	// AppendHistory 追加一条状态转换历史，历史只追加，删除对象状态时保留
+	AppendHistory(ctx context.Context, name string, entry HistoryEntry) error
+	GetHistory(ctx context.Context, name string) ([]HistoryEntry, error)
+	// SetDeployment 记录对象最近一次成功的部署，GetDeployment 在没有记录时返回 ok == false
+	SetDeployment(ctx context.Context, name string, deployment Deployment) error
+	GetDeployment(ctx context.Context, name string) (deployment Deployment, ok bool, err error)
+	// AppendRevision 追加一个修订并返回它的编号，修订只追加，删除对象状态时保留
+	AppendRevision(ctx context.Context, name string, revision Revision) (int, error)
+	GetRevisions(ctx context.Context, name string) ([]Revision, error)
+	// ReservePort 原子地预留端口，端口没有被预留或已经被同一对象预留时返回 ok == true，否则返回当前的预留
+	ReservePort(ctx context.Context, portReservation PortReservation) (current PortReservation, ok bool, err error)
+	// ReleasePort 仅当端口仍被 portReservation.Name 预留时释放端口
+	ReleasePort(ctx context.Context, portReservation PortReservation) error
+	ListPorts(ctx context.Context) ([]PortReservation, error)
+	// TryLock 尝试获取对象的租约锁，锁被其他持有者持有时返回 nil 和当前持有者
+	TryLock(ctx context.Context, name string, holder string, ttl time.Duration) (*Lease, string, error)
+	// RenewLock 延长租约，租约已经过期或被其他持有者获取时返回 ErrLeaseLost
+	RenewLock(ctx context.Context, lease *Lease, ttl time.Duration) error
+	Unlock(ctx context.Context, lease *Lease) error
+	// CompareAndSetState 仅当对象状态为 from（未注册时为空字符串）且 lease 仍然有效时把状态设置为 to，
+	// lease 为 nil 时要求对象没有被锁定
+	CompareAndSetState(ctx context.Context, name string, from string, to string, lease *Lease) error
+}
+
+// StoreConfig 是存储后端的配置，对应配置文件的 registry 字段
+type StoreConfig struct {
+	Backend  string
+	FilePath string
+	Redis    RedisConfig
+	// PortRanges 是分配主机端口的范围，形如 8000-8999，为空时使用缺省范围
+	PortRanges []string
+}
+
+var (
+	DefaultStore Store
+)
+
+func InitStore(storeConfig StoreConfig) error {
+	if len(storeConfig.PortRanges) > 0 {
+		portRanges, err := ParsePortRanges(storeConfig.PortRanges)
+		if err != nil {
+			log.Error("fail to parse port ranges", "portRanges", storeConfig.PortRanges, "err", err)
+			return err
+		}
+		PortRanges = portRanges
+	}
+	switch storeConfig.Backend {
+	case "", BackendRedis:
+		err := InitRedisClient(storeConfig.Redis)
+		if err != nil {
+			return err
+		}
+		DefaultStore = NewRedisStore(RedisClient)
+	case BackendMemory:
+		DefaultStore = NewMemoryStore()
+	case BackendFile:
+		fileStore, err := NewFileStore(storeConfig.FilePath)
+		if err != nil {
+			log.Error("fail to init file store", "path", storeConfig.FilePath, "err", err)
+			return err
+		}
+		DefaultStore = fileStore
+	default:
+		err := fmt.Errorf("unknown registry backend %q", storeConfig.Backend)
+		log.Error("fail to init store", "err", err)
+		return err
+	}
+	log.Info("store inited", "backend", storeConfig.Backend)
+	return nil
+}
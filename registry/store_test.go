@@ -0,0 +1,207 @@
+/*
+Copyright (c) 2022 Zhang Zhanpeng <zhangregister@outlook.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package registry
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testStore(t *testing.T, store Store) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	_, ok, err := store.GetState(ctx, "o1")
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	watcher, err := store.WatchState(ctx, "o1")
+	assert.Nil(t, err)
+
+	assert.Nil(t, store.SetState(ctx, "o1", "1"))
+	assert.Nil(t, store.SetState(ctx, "o2", "2"))
+	state, ok, err := store.GetState(ctx, "o1")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "1", state)
+
+	select {
+	case state := <-watcher:
+		assert.Equal(t, "1", state)
+	case <-time.After(5 * time.Second):
+		t.Fatal("state change not watched")
+	}
+
+	states, err := store.ListStates(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"o1": "1", "o2": "2"}, states)
+
+	timestamp := time.Unix(1640995200, 0).UTC()
+	assert.Nil(t, store.AppendHistory(ctx, "o1", HistoryEntry{Timestamp: timestamp, From: "0", To: "4"}))
+	assert.Nil(t, store.AppendHistory(ctx, "o1", HistoryEntry{Timestamp: timestamp, From: "4", To: "1", Reason: "registered"}))
+
+	assert.Nil(t, store.DeleteState(ctx, "o1"))
+	_, ok, err = store.GetState(ctx, "o1")
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	history, err := store.GetHistory(ctx, "o1")
+	assert.Nil(t, err)
+	assert.Equal(t, []HistoryEntry{
+		{Timestamp: timestamp, From: "0", To: "4"},
+		{Timestamp: timestamp, From: "4", To: "1", Reason: "registered"},
+	}, history)
+	history, err = store.GetHistory(ctx, "o2")
+	assert.Nil(t, err)
+	assert.Empty(t, history)
+
+	_, ok, err = store.GetDeployment(ctx, "o1")
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	deployment := Deployment{ConfigHash: "c0ffee", ContainerID: "fake01", Image: "busybox:1.35", Timestamp: timestamp}
+	assert.Nil(t, store.SetDeployment(ctx, "o1", deployment))
+	gottenDeployment, ok, err := store.GetDeployment(ctx, "o1")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, deployment, gottenDeployment)
+
+	for i, image := range []string{"busybox:1.34", "busybox:1.35"} {
+		number, err := store.AppendRevision(ctx, "o1", Revision{Spec: []byte(`{"Name":"o1"}`), Image: image, Timestamp: timestamp})
+		assert.Nil(t, err)
+		assert.Equal(t, i+1, number)
+	}
+	revisions, err := store.GetRevisions(ctx, "o1")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(revisions))
+	assert.Equal(t, 2, revisions[1].Revision)
+	assert.Equal(t, "busybox:1.35", revisions[1].Image)
+	assert.JSONEq(t, `{"Name":"o1"}`, string(revisions[1].Spec))
+	revisions, err = store.GetRevisions(ctx, "o2")
+	assert.Nil(t, err)
+	assert.Empty(t, revisions)
+}
+
+func testLock(t *testing.T, store Store) {
+	ctx := context.Background()
+
+	lease, _, err := store.TryLock(ctx, "o1", "alice", time.Minute)
+	assert.Nil(t, err)
+	assert.NotNil(t, lease)
+	assert.Equal(t, int64(1), lease.Token)
+
+	otherLease, holder, err := store.TryLock(ctx, "o1", "bob", time.Minute)
+	assert.Nil(t, err)
+	assert.Nil(t, otherLease)
+	assert.Equal(t, "alice", holder)
+
+	var lockHeldError *LockHeldError
+	err = store.CompareAndSetState(ctx, "o1", "", "4", nil)
+	assert.True(t, errors.As(err, &lockHeldError))
+	assert.EqualError(t, err, "kether object o1 is being modified by alice")
+
+	var stateConflictError *StateConflictError
+	assert.Nil(t, store.CompareAndSetState(ctx, "o1", "", "4", lease))
+	err = store.CompareAndSetState(ctx, "o1", "", "1", lease)
+	assert.True(t, errors.As(err, &stateConflictError))
+	assert.Equal(t, "4", stateConflictError.Actual)
+	assert.Nil(t, store.CompareAndSetState(ctx, "o1", "4", "1", lease))
+	assert.Nil(t, store.RenewLock(ctx, lease, time.Minute))
+	assert.Nil(t, store.Unlock(ctx, lease))
+	assert.Equal(t, ErrLeaseLost, store.RenewLock(ctx, lease, time.Minute))
+	assert.Equal(t, ErrLeaseLost, store.CompareAndSetState(ctx, "o1", "1", "5", lease))
+
+	// 过期的持有者被新的持有者取代后不能再写入
+	lease, _, err = store.TryLock(ctx, "o1", "alice", 50*time.Millisecond)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), lease.Token)
+	time.Sleep(100 * time.Millisecond)
+	otherLease, _, err = store.TryLock(ctx, "o1", "bob", time.Minute)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(3), otherLease.Token)
+	assert.Equal(t, ErrLeaseLost, store.CompareAndSetState(ctx, "o1", "1", "5", lease))
+	assert.Nil(t, store.CompareAndSetState(ctx, "o1", "1", "5", otherLease))
+	assert.Nil(t, store.Unlock(ctx, lease))
+	_, holder, err = store.TryLock(ctx, "o1", "alice", time.Minute)
+	assert.Nil(t, err)
+	assert.Equal(t, "bob", holder)
+
+	state, _, err := store.GetState(ctx, "o1")
+	assert.Nil(t, err)
+	assert.Equal(t, "5", state)
+}
+
+func testPorts(t *testing.T, store Store) {
+	ctx := context.Background()
+
+	portReservation := PortReservation{Host: "h1", Port: 8080, Protocol: "tcp", Name: "o1"}
+	current, ok, err := store.ReservePort(ctx, portReservation)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "o1", current.Name)
+	_, ok, err = store.ReservePort(ctx, portReservation)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	current, ok, err = store.ReservePort(ctx, PortReservation{Host: "h1", Port: 8080, Protocol: "tcp", Name: "o2"})
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	assert.Equal(t, "o1", current.Name)
+	// 不同的协议和主机互不影响
+	_, ok, err = store.ReservePort(ctx, PortReservation{Host: "h1", Port: 8080, Protocol: "udp", Name: "o2"})
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	_, ok, err = store.ReservePort(ctx, PortReservation{Host: "h2", Port: 8080, Protocol: "tcp", Name: "o2"})
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	assert.Nil(t, store.ReleasePort(ctx, PortReservation{Host: "h1", Port: 8080, Protocol: "tcp", Name: "o2"}))
+	portReservations, err := store.ListPorts(ctx)
+	assert.Nil(t, err)
+	assert.Len(t, portReservations, 3)
+	assert.Nil(t, store.ReleasePort(ctx, portReservation))
+	portReservations, err = store.ListPorts(ctx)
+	assert.Nil(t, err)
+	assert.Len(t, portReservations, 2)
+}
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, NewMemoryStore())
+	testLock(t, NewMemoryStore())
+	testPorts(t, NewMemoryStore())
+}
+
+func TestFileStore(t *testing.T) {
+	store, err := NewFileStore(filepath.Join(t.TempDir(), "registry.json"))
+	assert.Nil(t, err)
+	testStore(t, store)
+	store, err = NewFileStore(filepath.Join(t.TempDir(), "registry.json"))
+	assert.Nil(t, err)
+	testLock(t, store)
+	store, err = NewFileStore(filepath.Join(t.TempDir(), "registry.json"))
+	assert.Nil(t, err)
+	testPorts(t, store)
+}